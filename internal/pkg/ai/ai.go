@@ -2,85 +2,316 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
 
-	"github.com/rifaideen/talkative"
+var (
+	ErrURL      = errors.New("invalid url")
+	ErrCallback = errors.New("callback is required")
+	ErrEncoding = errors.New("unable to encode the request")
+	ErrDecoding = errors.New("unable to decode the response")
+	ErrInvoke   = errors.New("unable to reach the server, please make sure it is running and the url is correct")
 )
 
+// APIError is returned when the api responds with an error status or reports an error while streaming
+type APIError struct {
+	StatusCode int    // http status of the response, zero when reported while streaming
+	Message    string // error reported by the api
+	Body       string // raw body of the response
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("api error: %s", e.Message)
+	}
+
+	return fmt.Sprintf("api error (status %d): %s", e.StatusCode, e.Message)
+}
+
+// NotFound reports whether the requested model or resource does not exist
+func (e *APIError) NotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// temporary reports whether the request can be retried
+func (e *APIError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// RetryPolicy decides how requests failing before the api responds successfully are retried.
+// Network errors and 429 or 5xx responses are retried, a stream is never retried once it started.
+type RetryPolicy struct {
+	Attempts int           // total number of attempts, a single attempt when zero
+	Backoff  time.Duration // delay before the first retry, doubled after every retry
+}
+
+// AI is a client of the ollama api
 type AI struct {
-	url string
+	url     string
+	client  *http.Client
+	timeout time.Duration // timeout of the requests which are not streamed, disabled when zero
+	retry   RetryPolicy
+	headers http.Header // headers sent with every request
 }
 
-type PullResponse struct {
-	Status    string `json:"status"`
-	Digest    string `json:"digest"`
-	Total     int    `json:"total"`
-	Completed int    `json:"completed"`
+// Option configures the optional behaviour of the client
+type Option func(*AI)
+
+// WithHTTPClient sets the http client the requests are sent with
+func WithHTTPClient(client *http.Client) Option {
+	return func(ai *AI) {
+		ai.client = client
+	}
+}
+
+// WithTransport sets the transport the requests are sent with
+func WithTransport(transport http.RoundTripper) Option {
+	return func(ai *AI) {
+		ai.client = &http.Client{Transport: transport}
+	}
 }
 
-type PullCallBack func(*PullResponse, error)
+// WithHeader sets a header sent with every request, such as the authorization of a hosted api
+func WithHeader(key, value string) Option {
+	return func(ai *AI) {
+		ai.headers.Set(key, value)
+	}
+}
 
-func New(url string) (*AI, error) {
-	return &AI{url: url}, nil
+// WithTimeout sets the timeout of the requests which are not streamed. Streamed requests such as
+// chats and pulls last as long as their context.
+func WithTimeout(timeout time.Duration) Option {
+	return func(ai *AI) {
+		ai.timeout = timeout
+	}
 }
 
-func (ai *AI) Pull(model string, cb PullCallBack) (<-chan bool, error) {
-	if cb == nil {
-		return nil, talkative.ErrCallback
+// WithRetry sets how failing requests are retried
+func WithRetry(policy RetryPolicy) Option {
+	return func(ai *AI) {
+		ai.retry = policy
 	}
+}
 
-	client := http.Client{}
+// New creates a client of the ollama server running at the url
+func New(rawURL string, options ...Option) (*AI, error) {
+	u, err := url.Parse(rawURL)
 
-	payload := map[string]string{
-		"model": model,
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrURL, rawURL)
 	}
 
-	body := &bytes.Buffer{}
+	ai := &AI{
+		url: strings.TrimSuffix(rawURL, "/"),
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   10 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				MaxIdleConnsPerHost: 16,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+		headers: make(http.Header),
+	}
 
-	if err := json.NewEncoder(body).Encode(payload); err != nil {
-		return nil, fmt.Errorf("%w:%v", talkative.ErrEncoding, err)
+	for _, option := range options {
+		option(ai)
 	}
 
-	res, err := client.Post(
-		fmt.Sprintf("%s/api/pull", ai.url),
-		"application/json",
-		body,
-	)
+	return ai, nil
+}
+
+// request invokes the api endpoint with the json payload and decodes the response into out when given
+func (ai *AI) request(ctx context.Context, method, path string, payload, out interface{}) error {
+	if ai.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, ai.timeout)
+		defer cancel()
+	}
+
+	res, err := ai.send(ctx, method, path, payload)
 
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: %v", ErrDecoding, err)
+	}
+
+	return nil
+}
+
+// stream invokes the api endpoint with the json payload and decodes every chunk of the streamed
+// response, handing it to the callback until the stream completes or the context is done
+func stream[T any](ctx context.Context, ai *AI, path string, payload interface{}, cb func(*T)) error {
+	res, err := ai.send(ctx, http.MethodPost, path, payload)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	// a single decoder reads the whole stream, a chunk may arrive along with the next ones
+	decoder := json.NewDecoder(res.Body)
+
+	for {
+		var raw json.RawMessage
+
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return fmt.Errorf("%w: %v", ErrDecoding, err)
+		}
+
+		if reason := errorMessage(raw); reason != "" {
+			return &APIError{Message: reason, Body: string(raw)}
+		}
+
+		var response T
+
+		if err := json.Unmarshal(raw, &response); err != nil {
+			return fmt.Errorf("%w: %v", ErrDecoding, err)
+		}
+
+		cb(&response)
 	}
+}
 
-	if res.StatusCode != http.StatusOK {
-		switch res.StatusCode {
-		case http.StatusNotFound:
-			defer res.Body.Close()
+// send the request, retrying according to the retry policy until the api responds successfully
+func (ai *AI) send(ctx context.Context, method, path string, payload interface{}) (*http.Response, error) {
+	var body []byte
 
-			body, _ := io.ReadAll(res.Body)
+	if payload != nil {
+		data, err := json.Marshal(payload)
 
-			return nil, fmt.Errorf("%w\n%v", errors.New("the requested resource not found"), body)
-		case http.StatusBadRequest:
-			defer res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrEncoding, err)
+		}
 
-			body, _ := io.ReadAll(res.Body)
+		body = data
+	}
+
+	backoff := ai.retry.Backoff
+
+	for attempt := 1; ; attempt++ {
+		res, err := ai.do(ctx, method, path, body)
 
-			return nil, fmt.Errorf("%w\n%v", talkative.ErrBadRequest, body)
-		default:
-			return nil, fmt.Errorf("%w: please make sure ollama server is running and url is correct", talkative.ErrInvoke)
+		if err == nil {
+			return res, nil
 		}
+
+		var apiErr *APIError
+
+		retryable := ctx.Err() == nil && (!errors.As(err, &apiErr) || apiErr.temporary())
+
+		if !retryable || attempt >= ai.retry.Attempts {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		backoff *= 2
+	}
+}
+
+// do sends a single request and turns error responses into an APIError
+func (ai *AI) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, ai.url+path, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
 	}
 
-	chDone := make(chan bool)
+	req.Header.Set("Content-Type", "application/json")
+
+	for key, values := range ai.headers {
+		req.Header[key] = values
+	}
 
-	go func() {
-		talkative.StreamResponse(res.Body, cb)
+	res, err := ai.client.Do(req)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, fmt.Errorf("%w: %v", ErrInvoke, err)
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+
+	data, _ := io.ReadAll(res.Body)
+
+	apiErr := &APIError{
+		StatusCode: res.StatusCode,
+		Message:    http.StatusText(res.StatusCode),
+		Body:       string(data),
+	}
+
+	if reason := errorMessage(data); reason != "" {
+		apiErr.Message = reason
+	}
+
+	return nil, apiErr
+}
+
+// errorMessage extracts the error described in the body, either as a string as ollama does
+// or as an object with a message as the openai api does
+func errorMessage(data []byte) string {
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+
+	if json.Unmarshal(data, &body) != nil || len(body.Error) == 0 {
+		return ""
+	}
+
+	var reason string
+
+	if json.Unmarshal(body.Error, &reason) == nil {
+		return reason
+	}
+
+	var object struct {
+		Message string `json:"message"`
+	}
 
-		chDone <- true
-	}()
+	json.Unmarshal(body.Error, &object)
 
-	return chDone, nil
+	return object.Message
 }
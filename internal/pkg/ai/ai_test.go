@@ -0,0 +1,184 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serve starts a fake ollama server and returns a client of it
+func serve(t *testing.T, handler http.HandlerFunc, options ...Option) *AI {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	ai, err := New(server.URL, options...)
+
+	if err != nil {
+		t.Fatalf("unable to create client: %v", err)
+	}
+
+	return ai
+}
+
+func TestNew(t *testing.T) {
+	for _, url := range []string{"", "localhost:11434", "://ollama"} {
+		if _, err := New(url); !errors.Is(err, ErrURL) {
+			t.Errorf("expected ErrURL for %q, got %v", url, err)
+		}
+	}
+}
+
+func TestChat(t *testing.T) {
+	t.Run("chunks written at once", func(t *testing.T) {
+		ai := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			// several chunks in a single write must all be decoded
+			w.Write([]byte(
+				`{"message":{"role":"assistant","content":"Hello"}}` + "\n" +
+					`{"message":{"role":"assistant","content":", world"}}` + "\n" +
+					`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":3,"eval_count":2}` + "\n",
+			))
+		})
+
+		var content strings.Builder
+		var last *ChatResponse
+
+		err := ai.Chat(context.Background(), &ChatRequest{Model: "phi"}, func(cr *ChatResponse) {
+			content.WriteString(cr.Message.Content)
+			last = cr
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if content.String() != "Hello, world" {
+			t.Errorf("expected the whole message, got %q", content.String())
+		}
+
+		if !last.Done || last.PromptEvalCount != 3 || last.EvalCount != 2 {
+			t.Errorf("expected the statistics with the last chunk, got %+v", last)
+		}
+	})
+
+	t.Run("error while streaming", func(t *testing.T) {
+		ai := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"message":{"content":"Hel"}}` + "\n" + `{"error":"model crashed"}` + "\n"))
+		})
+
+		err := ai.Chat(context.Background(), &ChatRequest{Model: "phi"}, func(cr *ChatResponse) {})
+
+		var apiErr *APIError
+
+		if !errors.As(err, &apiErr) || apiErr.Message != "model crashed" {
+			t.Errorf("expected the streamed error, got %v", err)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		ai := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"message":{"content":"Hel"}}` + "\n"))
+			w.(http.Flusher).Flush()
+
+			<-r.Context().Done()
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		err := ai.Chat(ctx, &ChatRequest{Model: "phi"}, func(cr *ChatResponse) {
+			cancel()
+		})
+
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestAPIError(t *testing.T) {
+	ai := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"model 'gemma' not found"}`))
+	})
+
+	_, err := ai.Show(context.Background(), "gemma")
+
+	var apiErr *APIError
+
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+
+	if !apiErr.NotFound() || apiErr.Message != "model 'gemma' not found" || apiErr.Body != `{"error":"model 'gemma' not found"}` {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := WithRetry(RetryPolicy{Attempts: 3, Backoff: time.Millisecond})
+
+	t.Run("temporary errors are retried", func(t *testing.T) {
+		attempts := &atomic.Int32{}
+
+		ai := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			if attempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			w.Write([]byte(`{"models":[{"name":"phi"}]}`))
+		}, policy)
+
+		models, err := ai.List(context.Background())
+
+		if err != nil || len(models) != 1 || attempts.Load() != 3 {
+			t.Errorf("expected success on the third attempt, got %v after %d attempts", err, attempts.Load())
+		}
+	})
+
+	t.Run("attempts are limited", func(t *testing.T) {
+		attempts := &atomic.Int32{}
+
+		ai := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, policy)
+
+		_, err := ai.List(context.Background())
+
+		if err == nil || attempts.Load() != 3 {
+			t.Errorf("expected failure after 3 attempts, got %v after %d attempts", err, attempts.Load())
+		}
+	})
+
+	t.Run("client errors are not retried", func(t *testing.T) {
+		attempts := &atomic.Int32{}
+
+		ai := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		}, policy)
+
+		err := ai.Delete(context.Background(), "phi")
+
+		if err == nil || attempts.Load() != 1 {
+			t.Errorf("expected failure after a single attempt, got %v after %d attempts", err, attempts.Load())
+		}
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	ai := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}, WithTimeout(50*time.Millisecond))
+
+	_, err := ai.Running(context.Background())
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to time out, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// roles of the chat messages
+const (
+	SYSTEM    = "system"
+	USER      = "user"
+	ASSISTANT = "assistant"
+	TOOL      = "tool"
+)
+
+// Message is a message of a chat
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	Images     []string   `json:"images,omitempty"`       // base64 encoded images for the vision models
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // tools the assistant asks to call
+	ToolCallID string     `json:"tool_call_id,omitempty"` // id of the call a tool message answers
+	ToolName   string     `json:"tool_name,omitempty"`    // name of the tool a tool message answers
+}
+
+// Tool describes a function the model can ask to call
+type Tool struct {
+	Type     string       `json:"type"` // always "function"
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction is the name, purpose and arguments of a tool
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"` // json schema of the arguments
+}
+
+// ToolCall is a call of a tool requested by the model
+type ToolCall struct {
+	ID       string           `json:"id,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction is the tool to call along with its arguments
+type ToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"` // json object of the arguments
+}
+
+// ChatRequest is a request to generate the next message of a chat
+type ChatRequest struct {
+	Model     string                 `json:"model"`
+	Messages  []Message              `json:"messages"`
+	Format    json.RawMessage        `json:"format,omitempty"`  // "json" or a json schema the response must follow
+	Options   map[string]interface{} `json:"options,omitempty"` // generation options such as temperature
+	KeepAlive string                 `json:"keep_alive,omitempty"`
+	Tools     []Tool                 `json:"tools,omitempty"` // tools offered to the models supporting them
+}
+
+// ChatResponse is a chunk of the generated message, the last one carries the statistics of the generation
+type ChatResponse struct {
+	Model              string    `json:"model"`
+	CreatedAt          time.Time `json:"created_at"`
+	Message            Message   `json:"message"`
+	Done               bool      `json:"done"`
+	DoneReason         string    `json:"done_reason,omitempty"`
+	TotalDuration      int64     `json:"total_duration,omitempty"`
+	LoadDuration       int64     `json:"load_duration,omitempty"`
+	PromptEvalCount    int       `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64     `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int       `json:"eval_count,omitempty"`
+	EvalDuration       int64     `json:"eval_duration,omitempty"`
+}
+
+// ChatCallBack receives the chunks of the generated message
+type ChatCallBack func(*ChatResponse)
+
+// Chat generates the next message of the chat, streaming it to the callback until the generation
+// completes or the context is done
+func (ai *AI) Chat(ctx context.Context, request *ChatRequest, cb ChatCallBack) error {
+	if cb == nil {
+		return ErrCallback
+	}
+
+	payload := struct {
+		*ChatRequest
+		Stream bool `json:"stream"`
+	}{
+		ChatRequest: request,
+		Stream:      true,
+	}
+
+	return stream(ctx, ai, "/api/chat", payload, cb)
+}
@@ -0,0 +1,130 @@
+package ai
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"time"
+)
+
+// FakeDimensions is the size of the embeddings computed by the fake provider
+const FakeDimensions = 64
+
+// Fake is a deterministic provider for tests and local development. It replies with the
+// configured reply, or echoes the last message when none is configured, a word at a time.
+type Fake struct {
+	Models []string      // models the provider serves
+	Reply  string        // reply to every chat, the last message is echoed when empty
+	Delay  time.Duration // delay between the words of the reply
+	Err    error         // error returned by every call when set
+}
+
+func (f *Fake) Chat(ctx context.Context, request *ChatRequest, cb ChatCallBack) error {
+	if cb == nil {
+		return ErrCallback
+	}
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	reply := f.Reply
+	prompt := 0
+
+	for _, message := range request.Messages {
+		prompt += len(strings.Fields(message.Content))
+	}
+
+	if reply == "" && len(request.Messages) > 0 {
+		reply = request.Messages[len(request.Messages)-1].Content
+	}
+
+	words := strings.SplitAfter(reply, " ")
+
+	for _, word := range words {
+		if f.Delay > 0 {
+			select {
+			case <-time.After(f.Delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		cb(&ChatResponse{
+			Model:     request.Model,
+			CreatedAt: time.Now(),
+			Message: Message{
+				Role:    ASSISTANT,
+				Content: word,
+			},
+		})
+	}
+
+	cb(&ChatResponse{
+		Model:           request.Model,
+		CreatedAt:       time.Now(),
+		Message:         Message{Role: ASSISTANT},
+		Done:            true,
+		DoneReason:      "stop",
+		PromptEvalCount: prompt,
+		EvalCount:       len(strings.Fields(reply)),
+	})
+
+	return nil
+}
+
+func (f *Fake) List(ctx context.Context) ([]Model, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	models := make([]Model, 0, len(f.Models))
+
+	for _, name := range f.Models {
+		models = append(models, Model{Name: name, Model: name})
+	}
+
+	return models, nil
+}
+
+// Embed hashes the words of every input into a normalized bag of words vector, so inputs
+// sharing words are similar
+func (f *Fake) Embed(ctx context.Context, model string, input []string) ([][]float32, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	embeddings := make([][]float32, len(input))
+
+	for i, text := range input {
+		vector := make([]float32, FakeDimensions)
+
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			hash := fnv.New32a()
+			hash.Write([]byte(strings.Trim(word, ".,;:!?\"'()")))
+
+			vector[hash.Sum32()%FakeDimensions]++
+		}
+
+		var norm float64
+
+		for _, value := range vector {
+			norm += float64(value * value)
+		}
+
+		if norm > 0 {
+			for j := range vector {
+				vector[j] /= float32(math.Sqrt(norm))
+			}
+		}
+
+		embeddings[i] = vector
+	}
+
+	return embeddings, nil
+}
@@ -0,0 +1,152 @@
+package ai
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// PullResponse is the progress of a pull
+type PullResponse struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest"`
+	Total     int    `json:"total"`
+	Completed int    `json:"completed"`
+}
+
+// PullCallBack receives the progress of a pull
+type PullCallBack func(*PullResponse)
+
+// ModelDetails describes the format and family of a model
+type ModelDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// Model is a model available locally
+type Model struct {
+	Name       string       `json:"name"`
+	Model      string       `json:"model"`
+	ModifiedAt time.Time    `json:"modified_at"`
+	Size       int64        `json:"size"`
+	Digest     string       `json:"digest"`
+	Details    ModelDetails `json:"details"`
+}
+
+// RunningModel is a model currently loaded into memory
+type RunningModel struct {
+	Name      string       `json:"name"`
+	Model     string       `json:"model"`
+	Size      int64        `json:"size"`
+	Digest    string       `json:"digest"`
+	Details   ModelDetails `json:"details"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	SizeVRAM  int64        `json:"size_vram"`
+}
+
+// ModelInfo holds the modelfile, parameters, template and details of a model
+type ModelInfo struct {
+	License    string                 `json:"license,omitempty"`
+	Modelfile  string                 `json:"modelfile"`
+	Parameters string                 `json:"parameters"`
+	Template   string                 `json:"template"`
+	System     string                 `json:"system,omitempty"`
+	Details    ModelDetails           `json:"details"`
+	ModelInfo  map[string]interface{} `json:"model_info,omitempty"`
+	ModifiedAt time.Time              `json:"modified_at"`
+}
+
+// Pull the model, the progress is streamed to the callback until the pull completes or the context is done
+func (ai *AI) Pull(ctx context.Context, model string, cb PullCallBack) error {
+	if cb == nil {
+		return ErrCallback
+	}
+
+	payload := map[string]string{
+		"model": model,
+	}
+
+	return stream(ctx, ai, "/api/pull", payload, cb)
+}
+
+// List the models available locally
+func (ai *AI) List(ctx context.Context) ([]Model, error) {
+	response := struct {
+		Models []Model `json:"models"`
+	}{}
+
+	if err := ai.request(ctx, http.MethodGet, "/api/tags", nil, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Models, nil
+}
+
+// Show the details of the model
+func (ai *AI) Show(ctx context.Context, model string) (*ModelInfo, error) {
+	info := &ModelInfo{}
+
+	payload := map[string]string{
+		"model": model,
+	}
+
+	if err := ai.request(ctx, http.MethodPost, "/api/show", payload, info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Delete the model and its data
+func (ai *AI) Delete(ctx context.Context, model string) error {
+	payload := map[string]string{
+		"model": model,
+	}
+
+	return ai.request(ctx, http.MethodDelete, "/api/delete", payload, nil)
+}
+
+// Copy the model, creating a model with another name from the existing one
+func (ai *AI) Copy(ctx context.Context, source, destination string) error {
+	payload := map[string]string{
+		"source":      source,
+		"destination": destination,
+	}
+
+	return ai.request(ctx, http.MethodPost, "/api/copy", payload, nil)
+}
+
+// Running lists the models currently loaded into memory
+func (ai *AI) Running(ctx context.Context) ([]RunningModel, error) {
+	response := struct {
+		Models []RunningModel `json:"models"`
+	}{}
+
+	if err := ai.request(ctx, http.MethodGet, "/api/ps", nil, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Models, nil
+}
+
+// Embed computes the embedding of every input with the model
+func (ai *AI) Embed(ctx context.Context, model string, input []string) ([][]float32, error) {
+	response := struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}{}
+
+	payload := map[string]interface{}{
+		"model": model,
+		"input": input,
+	}
+
+	if err := ai.request(ctx, http.MethodPost, "/api/embed", payload, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Embeddings, nil
+}
@@ -0,0 +1,354 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// OpenAI is a provider backed by an api compatible with the openai chat completions api,
+// such as openai itself, vllm or llama.cpp
+type OpenAI struct {
+	api *AI
+}
+
+// NewOpenAI creates a provider of the api served at the url, including the version such as
+// https://api.openai.com/v1. The key is sent as a bearer token when given.
+func NewOpenAI(url, key string, options ...Option) (*OpenAI, error) {
+	if key != "" {
+		options = append(options, WithHeader("Authorization", "Bearer "+key))
+	}
+
+	api, err := New(url, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &OpenAI{api: api}, nil
+}
+
+// openAIChunk is a chunk of a streamed chat completion
+type openAIChunk struct {
+	Model   string `json:"model"`
+	Created int64  `json:"created"`
+	Choices []struct {
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"` // streamed a part at a time
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// openAIOptions names the generation options of ollama in the openai api
+var openAIOptions = map[string]string{
+	"temperature": "temperature",
+	"top_p":       "top_p",
+	"stop":        "stop",
+	"seed":        "seed",
+	"num_predict": "max_tokens",
+}
+
+func (o *OpenAI) Chat(ctx context.Context, request *ChatRequest, cb ChatCallBack) error {
+	if cb == nil {
+		return ErrCallback
+	}
+
+	payload := map[string]interface{}{
+		"model":    request.Model,
+		"messages": openAIMessages(request.Messages),
+		"stream":   true,
+		"stream_options": map[string]bool{
+			"include_usage": true,
+		},
+	}
+
+	for option, value := range request.Options {
+		// the ollama options the openai api has no counterpart of are left out
+		if name, ok := openAIOptions[option]; ok {
+			payload[name] = value
+		}
+	}
+
+	if format := responseFormat(request.Format); format != nil {
+		payload["response_format"] = format
+	}
+
+	if len(request.Tools) > 0 {
+		payload["tools"] = request.Tools
+	}
+
+	res, err := o.api.send(ctx, http.MethodPost, "/chat/completions", payload)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	// the statistics of the generation are reported along with the last chunk
+	last := &ChatResponse{
+		Model:   request.Model,
+		Message: Message{Role: ASSISTANT},
+		Done:    true,
+	}
+
+	// the tool calls are streamed in parts, they are reported once complete
+	var calls []ToolCall
+	var arguments []string
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+
+		data := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
+
+		if bytes.Equal(data, []byte("[DONE]")) {
+			break
+		}
+
+		if reason := errorMessage(data); reason != "" {
+			return &APIError{Message: reason, Body: string(data)}
+		}
+
+		chunk := &openAIChunk{}
+
+		if err := json.Unmarshal(data, chunk); err != nil {
+			return fmt.Errorf("%w: %v", ErrDecoding, err)
+		}
+
+		if chunk.Usage != nil {
+			last.PromptEvalCount = chunk.Usage.PromptTokens
+			last.EvalCount = chunk.Usage.CompletionTokens
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil {
+				last.DoneReason = *choice.FinishReason
+			}
+
+			for _, call := range choice.Delta.ToolCalls {
+				if call.Index < 0 {
+					return fmt.Errorf("%w: tool call index %d out of range", ErrDecoding, call.Index)
+				}
+
+				// the calls are numbered from zero, the first delta of a call comes with a new index
+				if call.Index >= len(calls) {
+					calls = append(calls, make([]ToolCall, call.Index+1-len(calls))...)
+					arguments = append(arguments, make([]string, call.Index+1-len(arguments))...)
+				}
+
+				if call.ID != "" {
+					calls[call.Index].ID = call.ID
+				}
+
+				calls[call.Index].Function.Name += call.Function.Name
+				arguments[call.Index] += call.Function.Arguments
+			}
+
+			if choice.Delta.Content == "" {
+				continue
+			}
+
+			cb(&ChatResponse{
+				Model:     chunk.Model,
+				CreatedAt: time.Unix(chunk.Created, 0),
+				Message: Message{
+					Role:    ASSISTANT,
+					Content: choice.Delta.Content,
+				},
+			})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return fmt.Errorf("%w: %v", ErrDecoding, err)
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if len(calls) > 0 {
+		for i := range calls {
+			if arguments[i] == "" {
+				arguments[i] = "{}"
+			}
+
+			calls[i].Function.Arguments = json.RawMessage(arguments[i])
+		}
+
+		cb(&ChatResponse{
+			Model:     request.Model,
+			CreatedAt: time.Now(),
+			Message:   Message{Role: ASSISTANT, ToolCalls: calls},
+		})
+	}
+
+	last.CreatedAt = time.Now()
+
+	cb(last)
+
+	return nil
+}
+
+// openAIMessages translates the messages to the ones of openai. The images are sent as data urls
+// in content parts and the arguments of the tool calls as json strings.
+func openAIMessages(messages []Message) []map[string]interface{} {
+	translated := make([]map[string]interface{}, len(messages))
+
+	for i, message := range messages {
+		translated[i] = map[string]interface{}{
+			"role":    message.Role,
+			"content": message.Content,
+		}
+
+		if len(message.Images) > 0 {
+			parts := []map[string]interface{}{
+				{"type": "text", "text": message.Content},
+			}
+
+			for _, image := range message.Images {
+				// the type is sniffed from the first bytes of the image
+				head, _ := base64.StdEncoding.DecodeString(image[:min(len(image), 64)])
+
+				parts = append(parts, map[string]interface{}{
+					"type": "image_url",
+					"image_url": map[string]string{
+						"url": "data:" + http.DetectContentType(head) + ";base64," + image,
+					},
+				})
+			}
+
+			translated[i]["content"] = parts
+		}
+
+		if len(message.ToolCalls) > 0 {
+			calls := make([]map[string]interface{}, len(message.ToolCalls))
+
+			for j, call := range message.ToolCalls {
+				calls[j] = map[string]interface{}{
+					"id":   call.ID,
+					"type": "function",
+					"function": map[string]string{
+						"name":      call.Function.Name,
+						"arguments": string(call.Function.Arguments),
+					},
+				}
+			}
+
+			translated[i]["tool_calls"] = calls
+		}
+
+		if message.Role == TOOL {
+			translated[i]["tool_call_id"] = message.ToolCallID
+		}
+	}
+
+	return translated
+}
+
+// responseFormat translates the ollama format, either "json" or a json schema, to the response format of openai
+func responseFormat(format json.RawMessage) interface{} {
+	if len(format) == 0 {
+		return nil
+	}
+
+	var name string
+
+	if json.Unmarshal(format, &name) == nil {
+		if name == "json" {
+			return map[string]string{"type": "json_object"}
+		}
+
+		return nil
+	}
+
+	return map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name":   "response",
+			"schema": format,
+		},
+	}
+}
+
+func (o *OpenAI) List(ctx context.Context) ([]Model, error) {
+	response := struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Created int64  `json:"created"`
+		} `json:"data"`
+	}{}
+
+	if err := o.api.request(ctx, http.MethodGet, "/models", nil, &response); err != nil {
+		return nil, err
+	}
+
+	models := make([]Model, 0, len(response.Data))
+
+	for _, model := range response.Data {
+		models = append(models, Model{
+			Name:       model.ID,
+			Model:      model.ID,
+			ModifiedAt: time.Unix(model.Created, 0),
+		})
+	}
+
+	return models, nil
+}
+
+func (o *OpenAI) Embed(ctx context.Context, model string, input []string) ([][]float32, error) {
+	response := struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}{}
+
+	payload := map[string]interface{}{
+		"model": model,
+		"input": input,
+	}
+
+	if err := o.api.request(ctx, http.MethodPost, "/embeddings", payload, &response); err != nil {
+		return nil, err
+	}
+
+	embeddings := make([][]float32, len(input))
+
+	for _, data := range response.Data {
+		if data.Index >= 0 && data.Index < len(embeddings) {
+			embeddings[data.Index] = data.Embedding
+		}
+	}
+
+	return embeddings, nil
+}
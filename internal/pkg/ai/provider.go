@@ -0,0 +1,122 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+)
+
+// Provider is a backend generating chats and embeddings
+type Provider interface {
+	// Chat generates the next message of the chat, streaming it to the callback until the
+	// generation completes or the context is done
+	Chat(ctx context.Context, request *ChatRequest, cb ChatCallBack) error
+	// List the models the provider serves
+	List(ctx context.Context) ([]Model, error)
+	// Embed computes the embedding of every input with the model
+	Embed(ctx context.Context, model string, input []string) ([][]float32, error)
+}
+
+var (
+	_ Provider = (*AI)(nil)
+	_ Provider = (*OpenAI)(nil)
+	_ Provider = (*Fake)(nil)
+	_ Provider = (*Router)(nil)
+)
+
+// route assigns the models matching the pattern to a provider
+type route struct {
+	pattern  string
+	provider Provider
+}
+
+// Router is a provider dispatching every request to the provider serving the model
+type Router struct {
+	fallback Provider
+	routes   []route
+}
+
+// NewRouter creates a router serving the models without a route with the fallback provider
+func NewRouter(fallback Provider) *Router {
+	return &Router{
+		fallback: fallback,
+	}
+}
+
+// Route serves the models matching the pattern with the provider, see path.Match for the syntax
+// of the pattern. The routes are matched in the order they were added.
+func (r *Router) Route(pattern string, provider Provider) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	r.routes = append(r.routes, route{pattern: pattern, provider: provider})
+
+	return nil
+}
+
+// Provider returns the provider serving the model
+func (r *Router) Provider(model string) Provider {
+	for _, route := range r.routes {
+		if matched, _ := path.Match(route.pattern, model); matched {
+			return route.provider
+		}
+	}
+
+	return r.fallback
+}
+
+func (r *Router) Chat(ctx context.Context, request *ChatRequest, cb ChatCallBack) error {
+	return r.Provider(request.Model).Chat(ctx, request, cb)
+}
+
+func (r *Router) Embed(ctx context.Context, model string, input []string) ([][]float32, error) {
+	return r.Provider(model).Embed(ctx, model, input)
+}
+
+// List the models of every provider which are routed to it. A provider failing to list its models
+// is left out, the listing only fails when every provider does.
+func (r *Router) List(ctx context.Context) ([]Model, error) {
+	providers := []Provider{r.fallback}
+
+	for _, route := range r.routes {
+		known := false
+
+		for _, provider := range providers {
+			known = known || provider == route.provider
+		}
+
+		if !known {
+			providers = append(providers, route.provider)
+		}
+	}
+
+	var models []Model
+	var errs []error
+
+	for _, provider := range providers {
+		list, err := provider.List(ctx)
+
+		if err != nil {
+			slog.Error("unable to list the models of a provider", "provider", fmt.Sprintf("%T", provider), "error", err)
+
+			errs = append(errs, err)
+
+			continue
+		}
+
+		for _, model := range list {
+			if r.Provider(model.Name) == provider {
+				models = append(models, model)
+			}
+		}
+	}
+
+	if len(errs) == len(providers) {
+		return nil, errors.Join(errs...)
+	}
+
+	return models, nil
+}
@@ -0,0 +1,313 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAI(t *testing.T) {
+	var received map[string]interface{}
+
+	server := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"invalid api key"}}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			json.NewDecoder(r.Body).Decode(&received)
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(
+				"data: {\"model\":\"gpt\",\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"\"}}]}\n\n" +
+					"data: {\"model\":\"gpt\",\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+					"data: {\"model\":\"gpt\",\"choices\":[{\"delta\":{\"content\":\" there\"},\"finish_reason\":\"stop\"}]}\n\n" +
+					"data: {\"model\":\"gpt\",\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2}}\n\n" +
+					"data: [DONE]\n\n",
+			))
+		case "/v1/models":
+			w.Write([]byte(`{"data":[{"id":"gpt"},{"id":"embedder"}]}`))
+		case "/v1/embeddings":
+			w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+		}
+	})
+
+	openai, err := NewOpenAI(server.url+"/v1", "secret")
+
+	if err != nil {
+		t.Fatalf("unable to create provider: %v", err)
+	}
+
+	t.Run("chat", func(t *testing.T) {
+		var chunks []*ChatResponse
+
+		request := &ChatRequest{
+			Model:    "gpt",
+			Messages: []Message{{Role: USER, Content: "hi"}, {Role: USER, Content: "what is this?", Images: []string{"iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR4nGNgYPj/HwADAgH/eL9GtQAAAABJRU5ErkJggg=="}}},
+			Options:  map[string]interface{}{"num_predict": 10, "temperature": 0.5, "num_ctx": 4096, "repeat_penalty": 1.1},
+			Format:   json.RawMessage(`"json"`),
+		}
+
+		err := openai.Chat(context.Background(), request, func(cr *ChatResponse) {
+			chunks = append(chunks, cr)
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(chunks) != 3 || chunks[0].Message.Content+chunks[1].Message.Content != "Hello there" {
+			t.Fatalf("unexpected chunks %+v", chunks)
+		}
+
+		last := chunks[2]
+
+		if !last.Done || last.DoneReason != "stop" || last.PromptEvalCount != 5 || last.EvalCount != 2 {
+			t.Errorf("expected the statistics with the last chunk, got %+v", last)
+		}
+
+		if received["max_tokens"] != float64(10) || received["temperature"] != 0.5 {
+			t.Errorf("expected the options to be translated, got %v", received)
+		}
+
+		if received["num_ctx"] != nil || received["repeat_penalty"] != nil || received["num_predict"] != nil {
+			t.Errorf("expected the options of ollama only to be left out, got %v", received)
+		}
+
+		if format, _ := received["response_format"].(map[string]interface{}); format["type"] != "json_object" {
+			t.Errorf("expected the json response format, got %v", received["response_format"])
+		}
+
+		messages := received["messages"].([]interface{})
+
+		if messages[0].(map[string]interface{})["content"] != "hi" {
+			t.Errorf("expected the text message as is, got %v", messages[0])
+		}
+
+		// images are sent as content parts
+		parts := messages[1].(map[string]interface{})["content"].([]interface{})
+		image := parts[1].(map[string]interface{})["image_url"].(map[string]interface{})
+
+		if len(parts) != 2 || !strings.HasPrefix(image["url"].(string), "data:image/png;base64,iVBOR") {
+			t.Errorf("expected the image as a data url, got %v", parts)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		models, err := openai.List(context.Background())
+
+		if err != nil || len(models) != 2 || models[0].Name != "gpt" {
+			t.Errorf("unexpected models %v: %v", models, err)
+		}
+	})
+
+	t.Run("embed", func(t *testing.T) {
+		embeddings, err := openai.Embed(context.Background(), "embedder", []string{"a", "b"})
+
+		if err != nil || embeddings[0][0] != 1 || embeddings[1][1] != 1 {
+			t.Errorf("expected the embeddings in the order of the input, got %v: %v", embeddings, err)
+		}
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		unauthorized, _ := NewOpenAI(server.url+"/v1", "wrong")
+
+		_, err := unauthorized.List(context.Background())
+
+		if err == nil || !strings.Contains(err.Error(), "invalid api key") {
+			t.Errorf("expected the error message of the api, got %v", err)
+		}
+	})
+}
+
+func TestOpenAIToolCalls(t *testing.T) {
+	var received map[string]interface{}
+
+	server := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+
+		// the arguments of the call are streamed in parts
+		w.Write([]byte(
+			"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"function\":{\"name\":\"ticket\",\"arguments\":\"{\\\"id\\\":\"}}]}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"42}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n" +
+				"data: [DONE]\n\n",
+		))
+	})
+
+	openai, _ := NewOpenAI(server.url, "")
+
+	request := &ChatRequest{
+		Model: "gpt",
+		Messages: []Message{
+			{Role: USER, Content: "status of ticket 41?"},
+			{Role: ASSISTANT, ToolCalls: []ToolCall{{ID: "call_0", Function: ToolCallFunction{Name: "ticket", Arguments: json.RawMessage(`{"id":41}`)}}}},
+			{Role: TOOL, Content: "closed", ToolCallID: "call_0", ToolName: "ticket"},
+			{Role: USER, Content: "and 42?"},
+		},
+		Tools: []Tool{{Type: "function", Function: ToolFunction{Name: "ticket", Parameters: json.RawMessage(`{"type":"object"}`)}}},
+	}
+
+	var calls []ToolCall
+	var last *ChatResponse
+
+	err := openai.Chat(context.Background(), request, func(cr *ChatResponse) {
+		calls = append(calls, cr.Message.ToolCalls...)
+		last = cr
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(calls) != 1 || calls[0].ID != "call_1" || calls[0].Function.Name != "ticket" || string(calls[0].Function.Arguments) != `{"id":42}` {
+		t.Errorf("expected the complete tool call, got %+v", calls)
+	}
+
+	if !last.Done || last.DoneReason != "tool_calls" {
+		t.Errorf("expected the generation to stop for the tool calls, got %+v", last)
+	}
+
+	if tools, _ := received["tools"].([]interface{}); len(tools) != 1 {
+		t.Errorf("expected the tools to be offered, got %v", received["tools"])
+	}
+
+	messages := received["messages"].([]interface{})
+	call := messages[1].(map[string]interface{})["tool_calls"].([]interface{})[0].(map[string]interface{})
+	result := messages[2].(map[string]interface{})
+
+	if call["function"].(map[string]interface{})["arguments"] != `{"id":41}` || result["tool_call_id"] != "call_0" {
+		t.Errorf("expected the tool calls and results to be translated, got %v", messages)
+	}
+}
+
+func TestOpenAIToolCallIndex(t *testing.T) {
+	server := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(
+			"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":-1,\"id\":\"call_1\",\"function\":{\"name\":\"ticket\"}}]}}]}\n\n" +
+				"data: [DONE]\n\n",
+		))
+	})
+
+	openai, _ := NewOpenAI(server.url, "")
+
+	err := openai.Chat(context.Background(), &ChatRequest{Model: "gpt", Messages: []Message{{Role: USER, Content: "hi"}}}, func(cr *ChatResponse) {
+		t.Errorf("unexpected response %+v", cr)
+	})
+
+	if !errors.Is(err, ErrDecoding) {
+		t.Errorf("expected a decoding error, got %v", err)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	ollama := &Fake{Models: []string{"phi", "llama3.2", "gpt-4o"}, Reply: "from ollama"}
+	openai := &Fake{Models: []string{"gpt-4o", "gpt-4o-mini", "dall-e"}, Reply: "from openai"}
+
+	router := NewRouter(ollama)
+
+	if err := router.Route("gpt-*", openai); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := router.Route("[", openai); err == nil {
+		t.Errorf("expected an invalid pattern to be rejected")
+	}
+
+	reply := func(model string) string {
+		var content strings.Builder
+
+		router.Chat(context.Background(), &ChatRequest{Model: model}, func(cr *ChatResponse) {
+			content.WriteString(cr.Message.Content)
+		})
+
+		return content.String()
+	}
+
+	t.Run("chat", func(t *testing.T) {
+		if got := reply("gpt-4o"); got != "from openai" {
+			t.Errorf("expected gpt-4o to be served by openai, got %q", got)
+		}
+
+		if got := reply("phi"); got != "from ollama" {
+			t.Errorf("expected phi to be served by ollama, got %q", got)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		models, err := router.List(context.Background())
+
+		var names []string
+
+		for _, model := range models {
+			names = append(names, model.Name)
+		}
+
+		// every model is listed once by the provider serving it
+		if err != nil || strings.Join(names, ",") != "phi,llama3.2,gpt-4o,gpt-4o-mini" {
+			t.Errorf("unexpected models %v: %v", names, err)
+		}
+	})
+
+	t.Run("list with a failing provider", func(t *testing.T) {
+		openai.Err = errors.New("unavailable")
+		defer func() { openai.Err = nil }()
+
+		models, err := router.List(context.Background())
+
+		// the models of the providers which answered are still listed
+		if err != nil || len(models) != 2 {
+			t.Errorf("unexpected models %v: %v", models, err)
+		}
+
+		ollama.Err = errors.New("unavailable")
+		defer func() { ollama.Err = nil }()
+
+		if _, err := router.List(context.Background()); err == nil {
+			t.Errorf("expected the listing to fail when every provider fails")
+		}
+	})
+}
+
+func TestFake(t *testing.T) {
+	fake := &Fake{}
+
+	t.Run("echo", func(t *testing.T) {
+		var chunks []string
+		var last *ChatResponse
+
+		fake.Chat(context.Background(), &ChatRequest{Messages: []Message{{Role: USER, Content: "hello fake world"}}}, func(cr *ChatResponse) {
+			chunks = append(chunks, cr.Message.Content)
+			last = cr
+		})
+
+		if strings.Join(chunks, "") != "hello fake world" || last.EvalCount != 3 || last.PromptEvalCount != 3 {
+			t.Errorf("unexpected chat %q %+v", chunks, last)
+		}
+	})
+
+	t.Run("embeddings are deterministic and similar for shared words", func(t *testing.T) {
+		embeddings, _ := fake.Embed(context.Background(), "", []string{"The cat sat", "the cat sat.", "stock prices fell"})
+
+		dot := func(a, b []float32) (sum float32) {
+			for i := range a {
+				sum += a[i] * b[i]
+			}
+
+			return sum
+		}
+
+		if similarity := dot(embeddings[0], embeddings[1]); similarity < 0.99 {
+			t.Errorf("expected identical words to be similar, got %f", similarity)
+		}
+
+		if dot(embeddings[0], embeddings[2]) >= dot(embeddings[0], embeddings[1]) {
+			t.Errorf("expected unrelated text to be less similar")
+		}
+	})
+}
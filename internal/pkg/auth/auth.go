@@ -16,6 +16,25 @@ type VerifyRequest struct {
 }
 
 type VerifyResponse struct {
-	Valid bool   `json:"valid"`
-	Error string `json:"error,omitempty"`
+	Valid  bool    `json:"valid"`
+	Claims *Claims `json:"claims,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// Claims holds the identity carried by a verified token
+type Claims struct {
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Org       string `json:"org,omitempty"`
+	Role      string `json:"role,omitempty"`
+	ExpiresAt int64  `json:"expires_at"` // unix timestamp in seconds
+}
+
+type RevokeRequest struct {
+	Token string `json:"token"`
+}
+
+type RevokeResponse struct {
+	Revoked bool   `json:"revoked"`
+	Error   string `json:"error,omitempty"`
 }
@@ -0,0 +1,186 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// consumerGroup implements sarama.ConsumerGroup on top of the in-memory broker
+type consumerGroup struct {
+	broker *Broker
+	group  string
+	config *sarama.Config
+	errors chan error
+
+	// ctx is cancelled when the consumer group is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Consume joins the group and runs the handler for every topic until the context is done
+// or the consumer group is closed, mirroring a single session of a sarama consumer group
+func (g *consumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	if g.ctx.Err() != nil {
+		return sarama.ErrClosedConsumerGroup
+	}
+
+	g.broker.join(g.group, topics, g.config.Consumer.Offsets.Initial)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// end the session when the consumer group is closed
+	stop := context.AfterFunc(g.ctx, cancel)
+	defer stop()
+
+	claims := make(map[string][]int32)
+
+	for _, topic := range topics {
+		claims[topic] = []int32{0}
+	}
+
+	session := &session{
+		ctx:    ctx,
+		claims: claims,
+	}
+
+	if err := handler.Setup(session); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+
+	for _, topic := range topics {
+		claim := &claim{
+			topic:         topic,
+			initialOffset: g.broker.offset(g.group, topic),
+			broker:        g.broker,
+			messages:      make(chan *sarama.ConsumerMessage),
+		}
+
+		wg.Add(2)
+
+		// feed the claim with the messages of the topic
+		go func() {
+			defer wg.Done()
+			defer close(claim.messages)
+
+			for {
+				msg, ok := g.broker.next(ctx, g.group, topic)
+
+				if !ok {
+					return
+				}
+
+				select {
+				case claim.messages <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			defer cancel()
+
+			if err := handler.ConsumeClaim(session, claim); err != nil {
+				select {
+				case g.errors <- err:
+				default:
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if err := handler.Cleanup(session); err != nil {
+		return err
+	}
+
+	if g.ctx.Err() != nil {
+		return sarama.ErrClosedConsumerGroup
+	}
+
+	return nil
+}
+
+func (g *consumerGroup) Errors() <-chan error {
+	return g.errors
+}
+
+func (g *consumerGroup) Close() error {
+	g.cancel()
+
+	return nil
+}
+
+func (g *consumerGroup) Pause(partitions map[string][]int32) {}
+
+func (g *consumerGroup) Resume(partitions map[string][]int32) {}
+
+func (g *consumerGroup) PauseAll() {}
+
+func (g *consumerGroup) ResumeAll() {}
+
+// session implements sarama.ConsumerGroupSession, offsets are committed as soon as
+// messages are handed out so marking them is a no-op
+type session struct {
+	ctx    context.Context
+	claims map[string][]int32
+}
+
+func (s *session) Claims() map[string][]int32 {
+	return s.claims
+}
+
+func (s *session) MemberID() string {
+	return "memory"
+}
+
+func (s *session) GenerationID() int32 {
+	return 1
+}
+
+func (s *session) MarkOffset(topic string, partition int32, offset int64, metadata string) {}
+
+func (s *session) Commit() {}
+
+func (s *session) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+
+func (s *session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {}
+
+func (s *session) Context() context.Context {
+	return s.ctx
+}
+
+// claim implements sarama.ConsumerGroupClaim for the single partition of a topic
+type claim struct {
+	topic         string
+	initialOffset int64
+	broker        *Broker
+	messages      chan *sarama.ConsumerMessage
+}
+
+func (c *claim) Topic() string {
+	return c.topic
+}
+
+func (c *claim) Partition() int32 {
+	return 0
+}
+
+func (c *claim) InitialOffset() int64 {
+	return c.initialOffset
+}
+
+func (c *claim) HighWaterMarkOffset() int64 {
+	return c.broker.highWaterMark(c.topic)
+}
+
+func (c *claim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
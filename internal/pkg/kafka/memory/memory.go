@@ -0,0 +1,173 @@
+// Package memory provides an in-memory kafka broker for tests and local development.
+// Every topic has a single partition, consumer groups share an offset per topic so each
+// message is delivered to exactly one member of a group, and every group sees every message.
+package memory
+
+import (
+	"context"
+	"pkg/kafka"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Broker keeps the messages of every topic and the offsets of every consumer group
+type Broker struct {
+	mu        sync.Mutex
+	topics    map[string][]*sarama.ConsumerMessage
+	groups    map[string]map[string]int64 // next offset to consume per group and topic
+	published chan struct{}               // closed and replaced whenever a message is published
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		topics:    make(map[string][]*sarama.ConsumerMessage),
+		groups:    make(map[string]map[string]int64),
+		published: make(chan struct{}),
+	}
+}
+
+// Client returns a kafka service connected to the broker, to be used in place of kafka.New
+func (b *Broker) Client(group string, config *sarama.Config) kafka.Service {
+	return &client{
+		broker: b,
+		group:  group,
+		config: config,
+	}
+}
+
+// Publish appends the message to its topic and wakes up waiting consumers
+func (b *Broker) Publish(msg *sarama.ProducerMessage) error {
+	var key, value []byte
+	var err error
+
+	if msg.Key != nil {
+		if key, err = msg.Key.Encode(); err != nil {
+			return err
+		}
+	}
+
+	if msg.Value != nil {
+		if value, err = msg.Value.Encode(); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msg.Offset = int64(len(b.topics[msg.Topic]))
+	msg.Timestamp = time.Now()
+
+	b.topics[msg.Topic] = append(b.topics[msg.Topic], &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Key:       key,
+		Value:     value,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+	})
+
+	close(b.published)
+	b.published = make(chan struct{})
+
+	return nil
+}
+
+// join registers the group on the topics, starting from the initial offset when the group is new
+func (b *Broker) join(group string, topics []string, initial int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.groups[group] == nil {
+		b.groups[group] = make(map[string]int64)
+	}
+
+	for _, topic := range topics {
+		if _, ok := b.groups[group][topic]; ok {
+			continue
+		}
+
+		if initial == sarama.OffsetNewest {
+			b.groups[group][topic] = int64(len(b.topics[topic]))
+		} else {
+			b.groups[group][topic] = 0
+		}
+	}
+}
+
+// next blocks until the group has an unconsumed message on the topic or the context is done
+func (b *Broker) next(ctx context.Context, group, topic string) (*sarama.ConsumerMessage, bool) {
+	for {
+		b.mu.Lock()
+
+		offset := b.groups[group][topic]
+		messages := b.topics[topic]
+
+		if offset < int64(len(messages)) {
+			b.groups[group][topic] = offset + 1
+			b.mu.Unlock()
+
+			return messages[offset], true
+		}
+
+		published := b.published
+		b.mu.Unlock()
+
+		select {
+		case <-published:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// offset returns the next offset the group will consume from the topic
+func (b *Broker) offset(group, topic string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.groups[group][topic]
+}
+
+// highWaterMark returns the offset the next message of the topic will get
+func (b *Broker) highWaterMark(topic string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return int64(len(b.topics[topic]))
+}
+
+type client struct {
+	broker *Broker
+	group  string
+	config *sarama.Config
+}
+
+func (c *client) SetupConsumer() (kafka.Consumer, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &consumerGroup{
+		broker: c.broker,
+		group:  c.group,
+		config: c.config,
+		errors: make(chan error),
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+func (c *client) SetupProducer() (kafka.Producer, error) {
+	p := &producer{
+		broker:    c.broker,
+		config:    c.config,
+		input:     make(chan *sarama.ProducerMessage, c.config.ChannelBufferSize),
+		successes: make(chan *sarama.ProducerMessage, c.config.ChannelBufferSize),
+		errors:    make(chan *sarama.ProducerError, c.config.ChannelBufferSize),
+		done:      make(chan struct{}),
+	}
+
+	go p.dispatch()
+
+	return p, nil
+}
@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// producer implements sarama.AsyncProducer on top of the in-memory broker
+type producer struct {
+	broker    *Broker
+	config    *sarama.Config
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+	done      chan struct{}
+	closing   sync.Once
+}
+
+// dispatch publishes the messages sent to the input channel until the producer is closed
+func (p *producer) dispatch() {
+	defer close(p.done)
+	defer close(p.successes)
+	defer close(p.errors)
+
+	for msg := range p.input {
+		if err := p.broker.Publish(msg); err != nil {
+			if p.config.Producer.Return.Errors {
+				p.errors <- &sarama.ProducerError{Msg: msg, Err: err}
+			}
+
+			continue
+		}
+
+		if p.config.Producer.Return.Successes {
+			p.successes <- msg
+		}
+	}
+}
+
+func (p *producer) AsyncClose() {
+	p.closing.Do(func() {
+		close(p.input)
+	})
+}
+
+func (p *producer) Close() error {
+	p.AsyncClose()
+
+	<-p.done
+
+	return nil
+}
+
+func (p *producer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *producer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+func (p *producer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+func (p *producer) IsTransactional() bool {
+	return false
+}
+
+func (p *producer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	return sarama.ProducerTxnFlagReady
+}
+
+func (p *producer) BeginTxn() error {
+	return sarama.ErrNonTransactedProducer
+}
+
+func (p *producer) CommitTxn() error {
+	return sarama.ErrNonTransactedProducer
+}
+
+func (p *producer) AbortTxn() error {
+	return sarama.ErrNonTransactedProducer
+}
+
+func (p *producer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error {
+	return sarama.ErrNonTransactedProducer
+}
+
+func (p *producer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string, metadata *string) error {
+	return sarama.ErrNonTransactedProducer
+}
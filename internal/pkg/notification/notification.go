@@ -0,0 +1,83 @@
+package notification
+
+import (
+	"encoding/json"
+	"pkg/auth"
+	"time"
+)
+
+// audience types a notification can be addressed to
+const (
+	AudienceUser     = "user"
+	AudienceOrg      = "org"
+	AudienceRole     = "role"
+	AudienceEveryone = "everyone"
+)
+
+// Audience describes who a notification is intended for
+type Audience struct {
+	Type string `json:"type"`         // one of user, org, role or everyone
+	ID   string `json:"id,omitempty"` // user id, org or role name depending on the type
+}
+
+// Notification is the event published on the notification topic
+type Notification struct {
+	Audience  Audience  `json:"audience"`
+	Title     string    `json:"title,omitempty"`
+	Data      string    `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+	// Frame is delivered as is in place of a notification frame, for the events of the services
+	// such as a renamed conversation
+	Frame json.RawMessage `json:"frame,omitempty"`
+}
+
+// New creates a notification addressed to the audience
+func New(audience Audience, data string) *Notification {
+	return &Notification{
+		Audience:  audience,
+		Data:      data,
+		CreatedAt: time.Now(),
+	}
+}
+
+// NewFrame creates a notification delivering the frame as is to the audience
+func NewFrame(audience Audience, frame []byte) *Notification {
+	notification := New(audience, "")
+	notification.Frame = frame
+
+	return notification
+}
+
+// Parse decodes a notification event. Plain text values which predate the
+// event schema are treated as notifications addressed to everyone.
+func Parse(value []byte) *Notification {
+	notification := &Notification{}
+
+	if err := json.Unmarshal(value, notification); err != nil || notification.Audience.Type == "" {
+		return New(Audience{Type: AudienceEveryone}, string(value))
+	}
+
+	return notification
+}
+
+// Includes reports whether the user identified by the claims belongs to the audience
+func (a Audience) Includes(claims *auth.Claims) bool {
+	if a.Type == AudienceEveryone {
+		return true
+	}
+
+	if claims == nil {
+		return false
+	}
+
+	switch a.Type {
+	case AudienceUser:
+		return claims.UserID == a.ID
+	case AudienceOrg:
+		return claims.Org == a.ID
+	case AudienceRole:
+		return claims.Role == a.ID
+	}
+
+	return false
+}
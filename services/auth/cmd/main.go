@@ -18,6 +18,7 @@ func main() {
 
 	http.HandleFunc("POST /auth/login", handler.Login)
 	http.HandleFunc("POST /auth/verify", handler.Verify)
+	http.HandleFunc("POST /auth/revoke", handler.Revoke)
 
 	log.Println("auth service listening on http://localhost" + PORT)
 
@@ -3,15 +3,19 @@ package handler
 import (
 	"auth/internal/service"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"pkg/auth"
 	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type Handler interface {
 	Login(w http.ResponseWriter, r *http.Request)
 	Verify(w http.ResponseWriter, r *http.Request)
+	Revoke(w http.ResponseWriter, r *http.Request)
 }
 
 type AuthHandler struct {
@@ -85,8 +89,72 @@ func (c *AuthHandler) Verify(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 
 	writer.Encode(auth.VerifyResponse{
-		Valid: true,
+		Valid:  true,
+		Claims: claims(token),
 	})
 
 	slog.Info("token verification successful", "claims", token.Claims)
 }
+
+func (c *AuthHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	slog.Info("token revocation request received")
+
+	// set response header
+	w.Header().Add("Content-Type", "application/json")
+
+	writer := json.NewEncoder(w)
+
+	request := &auth.RevokeRequest{}
+	json.NewDecoder(r.Body).Decode(request)
+
+	if err := c.service.Revoke(request); err != nil {
+		slog.Warn("token revocation failed", "error", err.Error())
+
+		w.WriteHeader(http.StatusBadRequest)
+		writer.Encode(auth.RevokeResponse{
+			Error: "invalid token",
+		})
+		return
+	}
+
+	w.WriteHeader(200)
+
+	writer.Encode(auth.RevokeResponse{
+		Revoked: true,
+	})
+
+	slog.Info("token revocation successful")
+}
+
+// claims extracts the identity from the verified token
+func claims(token *jwt.Token) *auth.Claims {
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+
+	if !ok {
+		return nil
+	}
+
+	claims := &auth.Claims{}
+
+	if userID, ok := mapClaims["user_id"]; ok {
+		claims.UserID = fmt.Sprint(userID)
+	}
+
+	if username, ok := mapClaims["username"].(string); ok {
+		claims.Username = username
+	}
+
+	if org, ok := mapClaims["org"].(string); ok {
+		claims.Org = org
+	}
+
+	if role, ok := mapClaims["role"].(string); ok {
+		claims.Role = role
+	}
+
+	if expiry, err := mapClaims.GetExpirationTime(); err == nil && expiry != nil {
+		claims.ExpiresAt = expiry.Unix()
+	}
+
+	return claims
+}
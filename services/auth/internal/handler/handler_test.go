@@ -127,3 +127,54 @@ func TestVerifyHandler(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 	})
 }
+
+func TestRevokeHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	service := mock_service.NewMockService(ctrl)
+
+	handler := New(service)
+
+	t.Run("revoke error", func(t *testing.T) {
+		request := &auth.RevokeRequest{
+			Token: "token",
+		}
+
+		w := httptest.NewRecorder()
+
+		payload, _ := json.Marshal(request)
+
+		// create test http request
+		r := httptest.NewRequest(http.MethodPost, "/revoke", bytes.NewBuffer(payload))
+
+		service.EXPECT().Revoke(gomock.Any()).Return(errors.New("invalid token"))
+
+		// call revoke handler
+		handler.Revoke(w, r)
+
+		// assert failure
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("revoke success", func(t *testing.T) {
+		request := &auth.RevokeRequest{
+			Token: "token",
+		}
+
+		w := httptest.NewRecorder()
+
+		payload, _ := json.Marshal(request)
+
+		// create test http request
+		r := httptest.NewRequest(http.MethodPost, "/revoke", bytes.NewBuffer(payload))
+
+		service.EXPECT().Revoke(gomock.Any()).Return(nil)
+
+		// call revoke handler
+		handler.Revoke(w, r)
+
+		// assert success
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
@@ -50,6 +50,20 @@ func (mr *MockServiceMockRecorder) Login(request interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockService)(nil).Login), request)
 }
 
+// Revoke mocks base method.
+func (m *MockService) Revoke(request *auth.RevokeRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Revoke", request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Revoke indicates an expected call of Revoke.
+func (mr *MockServiceMockRecorder) Revoke(request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Revoke", reflect.TypeOf((*MockService)(nil).Revoke), request)
+}
+
 // VerifyToken mocks base method.
 func (m *MockService) VerifyToken(request *auth.VerifyRequest) (*jwt.Token, error) {
 	m.ctrl.T.Helper()
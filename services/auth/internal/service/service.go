@@ -3,25 +3,33 @@ package service
 import (
 	"errors"
 	"pkg/auth"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var ErrRevoked = errors.New("token has been revoked")
+
 type Service interface {
 	Login(request *auth.LoginRequest) (string, error)
 	VerifyToken(request *auth.VerifyRequest) (*jwt.Token, error)
+	Revoke(request *auth.RevokeRequest) error
 }
 
 type AuthService struct {
 	expiry int    // expiry in days
 	secret string // secret key
+
+	revoked map[string]time.Time // revoked tokens mapped to their expiry
+	mu      sync.Mutex
 }
 
 func New(secret string, expiry int) Service {
 	return &AuthService{
-		expiry: expiry,
-		secret: secret,
+		expiry:  expiry,
+		secret:  secret,
+		revoked: make(map[string]time.Time),
 	}
 }
 
@@ -30,6 +38,8 @@ func (s *AuthService) Login(request *auth.LoginRequest) (string, error) {
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 			"user_id":  1,
 			"username": "admin",
+			"org":      "default",
+			"role":     "admin",
 			"exp":      time.Now().Add(time.Hour * 24 * time.Duration(s.expiry)).Unix(),
 		})
 
@@ -40,7 +50,58 @@ func (s *AuthService) Login(request *auth.LoginRequest) (string, error) {
 }
 
 func (s *AuthService) VerifyToken(request *auth.VerifyRequest) (*jwt.Token, error) {
-	return jwt.Parse(request.Token, func(token *jwt.Token) (interface{}, error) {
+	token, err := jwt.Parse(request.Token, func(token *jwt.Token) (interface{}, error) {
 		return []byte(s.secret), nil
 	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revoked[request.Token]; ok {
+		return nil, ErrRevoked
+	}
+
+	return token, nil
+}
+
+// Revoke invalidates a valid token until it expires
+func (s *AuthService) Revoke(request *auth.RevokeRequest) error {
+	token, err := s.VerifyToken(&auth.VerifyRequest{
+		Token: request.Token,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	expiry, err := token.Claims.GetExpirationTime()
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// forget revoked tokens which have expired on their own
+	for token, exp := range s.revoked {
+		if exp.Before(now) {
+			delete(s.revoked, token)
+		}
+	}
+
+	if expiry == nil {
+		// keep tokens without expiry revoked as long as the tokens we issue
+		s.revoked[request.Token] = now.Add(time.Hour * 24 * time.Duration(s.expiry))
+	} else {
+		s.revoked[request.Token] = expiry.Time
+	}
+
+	return nil
 }
@@ -61,3 +61,37 @@ func TestVerifyToken(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestRevoke(t *testing.T) {
+	service := New("secret", 1)
+
+	t.Run("revoke invalid token", func(t *testing.T) {
+		err := service.Revoke(&auth.RevokeRequest{
+			Token: "invalid token",
+		})
+
+		assert.Error(t, err)
+	})
+
+	t.Run("revoke success", func(t *testing.T) {
+		token, err := service.Login(&auth.LoginRequest{
+			Username: "admin",
+			Password: "admin",
+		})
+
+		assert.NoError(t, err)
+
+		err = service.Revoke(&auth.RevokeRequest{
+			Token: token,
+		})
+
+		assert.NoError(t, err)
+
+		verifiedToken, err := service.VerifyToken(&auth.VerifyRequest{
+			Token: token,
+		})
+
+		assert.Nil(t, verifiedToken)
+		assert.ErrorIs(t, err, ErrRevoked)
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"log"
 	"log/slog"
 	"os"
@@ -10,6 +11,7 @@ import (
 	"persistence/internal/config"
 	"persistence/internal/service"
 	"pkg/kafka"
+	"pkg/notification"
 	"sync"
 	"syscall"
 	"time"
@@ -85,7 +87,18 @@ func main() {
 		for {
 			select {
 			case <-t.C:
-				msg.Value = sarama.StringEncoder("Notification: " + time.Now().Format("2006-01-02 03:04 PM"))
+				// notify every connected user
+				event, err := json.Marshal(notification.New(
+					notification.Audience{Type: notification.AudienceEveryone},
+					"Notification: "+time.Now().Format("2006-01-02 03:04 PM"),
+				))
+
+				if err != nil {
+					slog.Error("error marshalling notification", "error", err)
+					continue
+				}
+
+				msg.Value = sarama.ByteEncoder(event)
 
 				slog.Info("sending notification message", "topic", config.TopicProducer, "message", msg)
 
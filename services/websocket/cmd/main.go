@@ -2,47 +2,115 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"pkg/ai"
 	"pkg/kafka"
+	"strings"
+	"sync"
+	"syscall"
 	"websocket/internal/config"
+	"websocket/internal/moderation"
 	"websocket/internal/service"
+	"websocket/internal/store"
+	"websocket/internal/tools"
 
 	"github.com/IBM/sarama"
+
+	_ "github.com/lib/pq"
 )
 
 var PORT = ":8003"
 
 func main() {
-	service := bootstrap()
+	config := config.Load()
+	service := bootstrap(config)
 
 	// create cancelable context and defer cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// create channel to receive interrupt signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
 	// start listening to handle the websocket connections regisrations and messages
 	go service.Listen(ctx)
 
+	// create wait group to wait for the consumer to finish
+	var wg sync.WaitGroup
+	wg.Add(1)
+
 	go func() {
-		err := service.Consume()
+		defer wg.Done()
+
+		err := service.Consume(ctx)
 
 		if err != nil {
 			log.Fatal(err)
 		}
 	}()
 
+	// metrics such as dropped outbound frames are served on /debug/vars by expvar
 	http.HandleFunc("GET /ws", service.ServeWS)
+	// server-sent events for the clients behind proxies breaking websockets
+	http.HandleFunc("POST /chat", service.ServeChat)
+	http.HandleFunc("GET /notifications/stream", service.ServeNotifications)
+	http.Handle("/v1/", service.OpenAI())
+	http.Handle("/personas", service.Personas())
+	http.Handle("/personas/", service.Personas())
+	http.Handle("/templates", service.Templates())
+	http.Handle("/templates/", service.Templates())
+	http.Handle("/uploads", service.Uploads())
+	http.Handle("/uploads/", service.Uploads())
+	http.Handle("/documents", service.Documents())
+	http.Handle("/documents/", service.Documents())
+	http.Handle("/usage", service.Usage())
+
+	server := &http.Server{
+		Addr: PORT,
+	}
+
+	go func() {
+		fmt.Printf("websocket listening on http://localhost%s\n", PORT)
+
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// wait for interrupt signal and shutdown gracefully
+	<-quit
 
-	fmt.Printf("websocket listening on http://localhost%s\n", PORT)
+	slog.Info("shutting down websocket service", "drain_timeout", config.DrainTimeout)
 
-	log.Fatal(http.ListenAndServe(PORT, nil))
+	drain, cancelDrain := context.WithTimeout(context.Background(), config.DrainTimeout)
+	defer cancelDrain()
+
+	// drain the established connections first, the new requests are rejected meanwhile. The event
+	// streams are never idle, the http server would wait for them until the deadline otherwise.
+	if err := service.Shutdown(drain); err != nil {
+		slog.Error("error shutting down websocket service", "error", err)
+	}
+
+	if err := server.Shutdown(drain); err != nil {
+		slog.Error("error shutting down http server", "error", err)
+	}
+
+	// stop listening and consuming
+	cancel()
+	wg.Wait()
+
+	slog.Info("websocket service stopped")
 }
 
 // bootstrap the application and return service instance
-func bootstrap() service.WebsocketService {
-	config := config.Load()
-
+func bootstrap(config *config.Config) service.WebsocketService {
 	cfg := sarama.NewConfig()
 
 	// producer and consumer configurations
@@ -52,6 +120,67 @@ func bootstrap() service.WebsocketService {
 	// configure kafka producer and consumer
 	producer, consumer := setupKafka(config.Brokers, config.Group, cfg)
 
+	policy, err := service.ParsePolicy(config.OutboundPolicy)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	limits, err := service.ParseLimits(config.GenerationLimits)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	quotas, err := service.ParseQuotas(config.Quotas)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rates, err := service.ParseRateLimits(config.RateLimits)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pipeline, err := moderation.Parse(config.Moderation)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	registry, err := toolRegistry(config.Tools)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := ai.New(
+		config.OllamaServiceUrl,
+		ai.WithTimeout(config.OllamaTimeout),
+		ai.WithRetry(ai.RetryPolicy{
+			Attempts: config.OllamaRetries,
+			Backoff:  config.OllamaBackoff,
+		}),
+	)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	provider, err := providers(config, client)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := database(config.Dsn)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	service := service.New(
 		consumer,
 		producer,
@@ -61,16 +190,131 @@ func bootstrap() service.WebsocketService {
 		},
 		config.AuthServiceUrl,
 		config.OllamaServiceUrl,
+		service.WithReauthWindow(config.ReauthWindow),
+		service.WithVerifyInterval(config.VerifyInterval),
+		service.WithStreams(config.StreamBuffer, config.StreamRetention),
+		service.WithOutbound(policy, config.OutboundBuffer, config.OutboundTimeout),
+		service.WithPulls(config.PullParallelism, config.PullQueueSize, config.PullUserLimit),
+		service.WithAI(client),
+		service.WithProvider(provider),
+		service.WithAllowedModels(config.AllowedModels...),
+		service.WithModelManagers(config.ModelManagers...),
+		service.WithLimits(limits),
+		service.WithQuotas(quotas),
+		service.WithRateLimits(rates),
+		service.WithAPIKeys(config.APIKeys),
+		service.WithStore(store),
+		service.WithAttachments(config.MaxAttachments, config.AttachmentSize),
+		service.WithUploadSize(config.UploadSize),
+		service.WithTools(registry),
+		service.WithRetrieval(config.EmbeddingModel, config.RetrievalResults),
+		service.WithChunking(config.ChunkSize, config.ChunkOverlap),
+		service.WithSummaries(config.SummaryThreshold, config.SummaryKeep, config.SummaryModel),
+		service.WithTitles(config.TitleModel),
+		service.WithModeration(pipeline, config.AuditTopic),
 	)
 
 	return service
 }
 
+// toolRegistry registers the builtin tools along with the http tools configured in TOOLS
+func toolRegistry(definitions string) (*tools.Registry, error) {
+	registry := tools.NewRegistry()
+
+	configured, err := tools.Parse(definitions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tool := range append([]tools.Tool{tools.Clock()}, configured...) {
+		if err := registry.Register(tool); err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
+// providers routes the models to the providers configured in MODEL_PROVIDERS, such as
+// "gpt-*=openai,test-*=fake", the other models are served by ollama
+func providers(config *config.Config, ollama *ai.AI) (ai.Provider, error) {
+	available := map[string]ai.Provider{
+		"ollama": ollama,
+	}
+
+	if config.FakeProvider {
+		available["fake"] = &ai.Fake{}
+	}
+
+	if config.OpenAIUrl != "" {
+		openai, err := ai.NewOpenAI(
+			config.OpenAIUrl,
+			config.OpenAIKey,
+			ai.WithTimeout(config.OllamaTimeout),
+			ai.WithRetry(ai.RetryPolicy{
+				Attempts: config.OllamaRetries,
+				Backoff:  config.OllamaBackoff,
+			}),
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		available["openai"] = openai
+	}
+
+	router := ai.NewRouter(ollama)
+
+	for _, route := range strings.Split(config.ModelProviders, ",") {
+		if strings.TrimSpace(route) == "" {
+			continue
+		}
+
+		pattern, name, _ := strings.Cut(route, "=")
+		provider, ok := available[strings.TrimSpace(name)]
+
+		if !ok {
+			return nil, fmt.Errorf("unknown or unconfigured provider %q for models %q", name, pattern)
+		}
+
+		if err := router.Route(strings.TrimSpace(pattern), provider); err != nil {
+			return nil, fmt.Errorf("invalid model pattern %q: %w", pattern, err)
+		}
+	}
+
+	return router, nil
+}
+
+// database returns the store of the personas, conversations and uploads, kept in postgres when the dsn is set
+func database(dsn string) (store.Store, error) {
+	if dsn == "" {
+		slog.Warn("no database configured, personas, conversations and uploads are kept in memory")
+
+		return store.NewMemory(), nil
+	}
+
+	db, err := sql.Open("postgres", dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	return store.NewPostgres(db)
+}
+
 // kafka consumer configurations
 func consumer(config *sarama.Config) {
 	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
 	config.Consumer.Return.Errors = true
-	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	// consumer groups are per instance and a new instance only cares about notifications
+	// for the users connected to it from now on, so skip the history of the topic
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
 }
 
 // kafka producer configurations
@@ -6,7 +6,7 @@ require (
 	github.com/IBM/sarama v1.45.0
 	github.com/golang/mock v1.6.0
 	github.com/gorilla/websocket v1.5.3
-	github.com/rifaideen/talkative v0.1.2
+	github.com/lib/pq v1.10.9
 )
 
 require (
@@ -1,28 +1,244 @@
 package config
 
 import (
+	"encoding/json"
+	"log"
+	"os"
+	"pkg/auth"
 	"pkg/utils"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
-	Brokers          []string // kafka brokers
-	Group            string   // kafka group
-	ConsumerTopic    string   // topic to consume from kafka
-	ProducerTopic    string   // topic to produce into kafka
-	AuthServiceUrl   string   // auth service url
-	OllamaServiceUrl string   // ollama service url
+	Brokers          []string                // kafka brokers
+	InstanceID       string                  // unique id of this websocket instance
+	Group            string                  // kafka group, unique per instance
+	ConsumerTopic    string                  // topic to consume from kafka
+	ProducerTopic    string                  // topic to produce into kafka
+	AuthServiceUrl   string                  // auth service url
+	OllamaServiceUrl string                  // ollama service url
+	ReauthWindow     time.Duration           // how long before token expiry clients are asked to re-authenticate
+	VerifyInterval   time.Duration           // how often tokens of connected clients are re-verified
+	StreamBuffer     int                     // number of frames buffered per streamed response for resumption
+	StreamRetention  time.Duration           // how long finished streamed responses can be resumed
+	DrainTimeout     time.Duration           // how long in-flight requests are awaited on shutdown
+	OutboundPolicy   string                  // slow consumer policy: block, drop_oldest, coalesce or disconnect
+	OutboundBuffer   int                     // number of outbound frames queued per connection
+	OutboundTimeout  time.Duration           // how long the block policy waits for room in the queue
+	PullParallelism  int                     // number of models pulled at once
+	PullQueueSize    int                     // number of pulls waiting for their turn
+	PullUserLimit    int                     // number of pulls a user can start at once, unlimited when zero
+	OllamaTimeout    time.Duration           // timeout of the ollama requests which are not streamed
+	OllamaRetries    int                     // number of attempts of a failing ollama request
+	OllamaBackoff    time.Duration           // delay before retrying a failing ollama request, doubled after every retry
+	OpenAIUrl        string                  // url of an openai compatible api including the version, disabled when empty
+	OpenAIKey        string                  // api key of the openai compatible api
+	ModelProviders   string                  // providers of the models as comma separated pattern=provider pairs, ollama by default
+	FakeProvider     bool                    // whether the fake provider can serve models, for tests and local development only
+	AllowedModels    []string                // patterns of the models users can chat with, every model when empty
+	ModelManagers    []string                // roles allowed to pull, delete and copy the local models
+	GenerationLimits string                  // limits of the generation parameters per role as json
+	Quotas           string                  // daily token and request quotas per role as json, unlimited when empty
+	RateLimits       string                  // rates of the frames per role as json, unlimited when empty
+	APIKeys          map[string]*auth.Claims // identities of the api keys accepted by the openai compatible api
+	Dsn              string                  // database dsn, the personas, conversations and uploads are kept in memory when empty
+	MaxAttachments   int                     // number of images attached to a chat at most
+	AttachmentSize   int64                   // size of an image attached to a chat at most, in bytes
+	UploadSize       int64                   // size of an uploaded file at most, in bytes
+	Tools            string                  // http tools the assistant can call as json, the builtin tools are always available
+	EmbeddingModel   string                  // model computing the embeddings of the documents and prompts
+	RetrievalResults int                     // number of chunks of the documents injected into the prompts
+	ChunkSize        int                     // characters per chunk of the documents
+	ChunkOverlap     int                     // characters repeated from the previous chunk
+	SummaryThreshold int                     // estimated tokens of the history of a conversation summarized beyond, disabled when zero
+	SummaryKeep      int                     // number of the last messages of a conversation kept as they are when summarized
+	SummaryModel     string                  // model writing the summaries of the conversations, the model of the conversation when empty
+	TitleModel       string                  // model naming the conversations after their first exchange, the model of the conversation when empty
+	Moderation       string                  // filters of the prompts and replies as json, everything is accepted when empty
+	AuditTopic       string                  // topic the moderation violations are recorded to, not recorded when empty
 }
 
 func Load() *Config {
 	brokers := utils.GetEnv("KAFKA_BROKERS", "localhost:9092")
 
+	// load token lifecycle durations with default values of 5 minutes and 1 minute
+	reauthWindow := duration("REAUTH_WINDOW", "5m")
+	verifyInterval := duration("TOKEN_VERIFY_INTERVAL", "1m")
+
+	// load stream resumption settings with default values of 1024 frames and 5 minutes
+	streamBuffer := integer("STREAM_BUFFER_SIZE", "1024", 1)
+	streamRetention := duration("STREAM_RETENTION", "5m")
+
+	// load shutdown drain timeout with default value of 30 seconds
+	drainTimeout := duration("DRAIN_TIMEOUT", "30s")
+
+	// load slow consumer settings with default values of 256 frames and 5 seconds
+	outboundBuffer := integer("OUTBOUND_BUFFER_SIZE", "256", 1)
+	outboundTimeout := duration("OUTBOUND_BLOCK_TIMEOUT", "5s")
+
+	// load pull queue settings with default values of 2 pulls at once, 16 waiting and 2 per user
+	pullParallelism := integer("PULL_PARALLELISM", "2", 1)
+	pullQueueSize := integer("PULL_QUEUE_SIZE", "16", 0)
+	pullUserLimit := integer("PULL_USER_LIMIT", "2", 0)
+
+	// load ollama client settings with default values of 30 seconds, 3 attempts and 500 milliseconds
+	ollamaTimeout := duration("OLLAMA_TIMEOUT", "30s")
+	ollamaRetries := integer("OLLAMA_RETRY_ATTEMPTS", "3", 0)
+	ollamaBackoff := duration("OLLAMA_RETRY_BACKOFF", "500ms")
+
+	// load upload settings with default values of 4 images of 5 MiB and files of 10 MiB
+	maxAttachments := integer("MAX_ATTACHMENTS", "4", 0)
+	attachmentSize := size("MAX_ATTACHMENT_SIZE", "5242880")
+	uploadSize := size("MAX_UPLOAD_SIZE", "10485760")
+
+	// load retrieval settings with default values of 4 chunks of 1000 characters overlapping by 200
+	retrievalResults := integer("RETRIEVAL_RESULTS", "4", 0)
+	chunkSize := integer("CHUNK_SIZE", "1000", 1)
+	chunkOverlap := integer("CHUNK_OVERLAP", "200", 0)
+
+	// load conversation summary settings with default values of 3000 tokens and the last 6 messages
+	summaryThreshold := integer("SUMMARY_THRESHOLD", "3000", 0)
+	summaryKeep := integer("SUMMARY_KEEP_MESSAGES", "6", 0)
+
+	// the fake provider replies without a model, it must not be routed to by mistake
+	fakeProvider := boolean("FAKE_PROVIDER", "false")
+
+	// load the api keys of the openai compatible api, a json object of the identity of every key
+	// such as {"sk-tools": {"user_id": "tools", "org": "default", "role": "member"}}
+	apiKeys := make(map[string]*auth.Claims)
+
+	if err := json.Unmarshal([]byte(utils.GetEnv("API_KEYS", "{}")), &apiKeys); err != nil {
+		log.Fatalf("invalid API_KEYS: %v", err)
+	}
+
+	var allowedModels []string
+
+	for _, pattern := range strings.Split(utils.GetEnv("ALLOWED_MODELS", ""), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			allowedModels = append(allowedModels, pattern)
+		}
+	}
+
+	var modelManagers []string
+
+	for _, role := range strings.Split(utils.GetEnv("MODEL_MANAGER_ROLES", "admin"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			modelManagers = append(modelManagers, role)
+		}
+	}
+
+	// every replica must receive every notification to reach the users connected to it,
+	// so each instance joins its own consumer group derived from the configured group
+	hostname, _ := os.Hostname()
+	instance := utils.GetEnv("INSTANCE_ID", hostname)
+
 	return &Config{
 		Brokers:          strings.Split(brokers, ","),
-		Group:            utils.GetEnv("KAFKA_GROUP", "websocket-group"),
+		InstanceID:       instance,
+		Group:            utils.GetEnv("KAFKA_GROUP", "websocket-group") + "-" + instance,
 		ConsumerTopic:    utils.GetEnv("KAFKA_TOPIC_CONSUMER", "notification"), // consumes notification topic
 		ProducerTopic:    utils.GetEnv("KAFKA_TOPIC_PRODUCER", "chat"),         // produces chat topic
 		AuthServiceUrl:   utils.GetEnv("AUTH_SERVICE_URL", "http://auth-service:8001"),
 		OllamaServiceUrl: utils.GetEnv("OLLAMA_SERVICE_URL", "http://ollama_service:11434"),
+		ReauthWindow:     reauthWindow,
+		VerifyInterval:   verifyInterval,
+		StreamBuffer:     streamBuffer,
+		StreamRetention:  streamRetention,
+		DrainTimeout:     drainTimeout,
+		OutboundPolicy:   utils.GetEnv("OUTBOUND_POLICY", "block"),
+		OutboundBuffer:   outboundBuffer,
+		OutboundTimeout:  outboundTimeout,
+		PullParallelism:  pullParallelism,
+		PullQueueSize:    pullQueueSize,
+		PullUserLimit:    pullUserLimit,
+		OllamaTimeout:    ollamaTimeout,
+		OllamaRetries:    ollamaRetries,
+		OllamaBackoff:    ollamaBackoff,
+		OpenAIUrl:        utils.GetEnv("OPENAI_URL", ""),
+		OpenAIKey:        utils.GetEnv("OPENAI_API_KEY", ""),
+		ModelProviders:   utils.GetEnv("MODEL_PROVIDERS", ""),
+		FakeProvider:     fakeProvider,
+		AllowedModels:    allowedModels,
+		ModelManagers:    modelManagers,
+		GenerationLimits: utils.GetEnv("GENERATION_LIMITS", ""),
+		Quotas:           utils.GetEnv("QUOTAS", ""),
+		RateLimits:       utils.GetEnv("RATE_LIMITS", ""),
+		APIKeys:          apiKeys,
+		Dsn:              utils.GetEnv("DSN", ""),
+		MaxAttachments:   maxAttachments,
+		AttachmentSize:   attachmentSize,
+		UploadSize:       uploadSize,
+		Tools:            utils.GetEnv("TOOLS", ""),
+		EmbeddingModel:   utils.GetEnv("EMBEDDING_MODEL", "nomic-embed-text"),
+		RetrievalResults: retrievalResults,
+		ChunkSize:        chunkSize,
+		ChunkOverlap:     chunkOverlap,
+		SummaryThreshold: summaryThreshold,
+		SummaryKeep:      summaryKeep,
+		SummaryModel:     utils.GetEnv("SUMMARY_MODEL", ""),
+		TitleModel:       utils.GetEnv("TITLE_MODEL", ""),
+		Moderation:       utils.GetEnv("MODERATION", ""),
+		AuditTopic:       utils.GetEnv("KAFKA_TOPIC_AUDIT", "audit"),
+	}
+}
+
+// duration parses the duration of the environment variable, the service does not start with an invalid
+// or negative one
+func duration(key, fallback string) time.Duration {
+	value, err := time.ParseDuration(utils.GetEnv(key, fallback))
+
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+
+	if value < 0 {
+		log.Fatalf("invalid %s: %s must not be negative", key, value)
+	}
+
+	return value
+}
+
+// integer parses the number of the environment variable, the service does not start with an invalid one
+// or one below the minimum
+func integer(key, fallback string, min int) int {
+	value, err := strconv.Atoi(utils.GetEnv(key, fallback))
+
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+
+	if value < min {
+		log.Fatalf("invalid %s: %d must be at least %d", key, value, min)
 	}
+
+	return value
+}
+
+// size parses the size in bytes of the environment variable, the service does not start with an invalid
+// or negative one
+func size(key, fallback string) int64 {
+	value, err := strconv.ParseInt(utils.GetEnv(key, fallback), 10, 64)
+
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+
+	if value < 0 {
+		log.Fatalf("invalid %s: %d must not be negative", key, value)
+	}
+
+	return value
+}
+
+// boolean parses the flag of the environment variable, the service does not start with an invalid one
+func boolean(key, fallback string) bool {
+	value, err := strconv.ParseBool(utils.GetEnv(key, fallback))
+
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+
+	return value
 }
@@ -1,7 +1,43 @@
 package model
 
+import "encoding/json"
+
 type Message struct {
-	Type  string `json:"type"`
-	Data  string `json:"data"`
-	Model string `json:"model"`
+	Type      string `json:"type"`
+	Data      string `json:"data"`
+	Model     string `json:"model"`
+	RequestID string `json:"request_id,omitempty"` // identifies a streamed request to resume or acknowledge
+	Seq       int64  `json:"seq,omitempty"`        // last sequence number received by the client
+
+	ConversationID string `json:"conversation_id,omitempty"` // continues the conversation with the id, started when unknown
+	Persona        string `json:"persona,omitempty"`         // id of the persona to chat with
+	System         string `json:"system,omitempty"`          // ad-hoc system prompt, preferred over the persona's
+	Retrieval      *bool  `json:"retrieval,omitempty"`       // opts in or out of searching the documents of the user to answer
+
+	Template  string            `json:"template,omitempty"`  // id of the prompt template filled in to make the message
+	Variables map[string]string `json:"variables,omitempty"` // values of the variables of the template
+
+	Options     *Options     `json:"options,omitempty"`     // generation parameters, the defaults of the persona or model apply when unset
+	Attachments []Attachment `json:"attachments,omitempty"` // images for the vision models
+	Tools       []string     `json:"tools,omitempty"`       // names of the tools the assistant can call
+
+	Format json.RawMessage `json:"format,omitempty"` // "json" or a json schema the reply must follow
+	Retry  bool            `json:"retry,omitempty"`  // asks once more when the reply does not follow the format
+}
+
+// Attachment is a file attached to a chat, either inline or a reference to an uploaded file
+type Attachment struct {
+	Data     string `json:"data,omitempty"`      // base64 encoded content
+	UploadID string `json:"upload_id,omitempty"` // id of a file uploaded beforehand
+	Name     string `json:"name,omitempty"`
+}
+
+// Options are the generation parameters a client can set on a chat
+type Options struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	NumCtx      *int     `json:"num_ctx,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	KeepAlive   string   `json:"keep_alive,omitempty"` // how long the model stays loaded, such as "5m"
 }
@@ -0,0 +1,229 @@
+package moderation
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type maxLength struct {
+	max int
+}
+
+// MaxLength rejects the texts longer than max characters
+func MaxLength(max int) Filter {
+	return &maxLength{max}
+}
+
+func (f *maxLength) Name() string {
+	return "max_length"
+}
+
+func (f *maxLength) Check(ctx context.Context, text string) *Violation {
+	return f.exceeded(utf8.RuneCountInString(text))
+}
+
+// exceeded returns the violation of a text of length characters, the streamed replies are checked
+// by their length alone
+func (f *maxLength) exceeded(length int) *Violation {
+	if length <= f.max {
+		return nil
+	}
+
+	return &Violation{
+		Filter: f.Name(),
+		Reason: fmt.Sprintf("longer than %d characters", f.max),
+	}
+}
+
+type blocklist struct {
+	name    string
+	reason  string
+	pattern *regexp.Regexp
+	window  int
+}
+
+func (f *blocklist) Name() string {
+	return f.name
+}
+
+func (f *blocklist) Window() int {
+	return f.window
+}
+
+func (f *blocklist) Check(ctx context.Context, text string) *Violation {
+	if !f.pattern.MatchString(text) {
+		return nil
+	}
+
+	return &Violation{Filter: f.name, Reason: f.reason}
+}
+
+// Keywords rejects the texts containing any of the words or phrases, regardless of their case
+func Keywords(words ...string) (Filter, error) {
+	var alternatives []string
+	window := 0
+
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word == "" {
+			continue
+		}
+
+		window = max(window, utf8.RuneCountInString(word))
+
+		// a keyword only matches whole words, the boundaries are left out next to punctuation
+		// such as in "c++" where \b would never match
+		alternative := regexp.QuoteMeta(word)
+
+		if first, _ := utf8.DecodeRuneInString(word); isWord(first) {
+			alternative = `\b` + alternative
+		}
+
+		if last, _ := utf8.DecodeLastRuneInString(word); isWord(last) {
+			alternative += `\b`
+		}
+
+		alternatives = append(alternatives, alternative)
+	}
+
+	if len(alternatives) == 0 {
+		return nil, errors.New("no keywords to block")
+	}
+
+	return &blocklist{
+		name:    "keywords",
+		reason:  "contains a blocked keyword",
+		pattern: regexp.MustCompile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`),
+		window:  window,
+	}, nil
+}
+
+func isWord(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// DefaultWindow is the number of characters a match of the regular expressions spans at most
+// unless told otherwise
+const DefaultWindow = 256
+
+// Regex rejects the texts matching any of the regular expressions, whose matches span at most
+// window characters of the streamed replies, DefaultWindow when it is not positive
+func Regex(window int, patterns ...string) (Filter, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("no patterns to block")
+	}
+
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	return &blocklist{
+		name:    "regex",
+		reason:  "matches a blocked pattern",
+		pattern: regexp.MustCompile(`(?:` + strings.Join(patterns, `)|(?:`) + `)`),
+		window:  cmp.Or(max(window, 0), DefaultWindow),
+	}, nil
+}
+
+// kinds of personal information detected by the PII filter
+var piiPatterns = map[string]*regexp.Regexp{
+	"email":       regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}\b`),
+	"phone":       regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{2,4}\)|\b\d{2,4})[ .-]?\d{3,4}[ .-]?\d{3,4}\b`),
+	"credit_card": regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+	"ssn":         regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+	"ip_address":  regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`),
+}
+
+// PIIKinds are the kinds of personal information the PII filter detects, the most specific first
+// as a card number looks like a phone number as well
+var PIIKinds = []string{"email", "credit_card", "ssn", "ip_address", "phone"}
+
+// piiWindow is the length of the longest personal information detected, the longest email addresses
+const piiWindow = 254
+
+type pii struct {
+	kinds []string
+}
+
+// PII rejects the texts containing personal information of the kinds, every kind when none is given
+func PII(kinds ...string) (Filter, error) {
+	if len(kinds) == 0 {
+		kinds = PIIKinds
+	}
+
+	for _, kind := range kinds {
+		if !slices.Contains(PIIKinds, kind) {
+			return nil, fmt.Errorf("unknown kind of personal information %q", kind)
+		}
+	}
+
+	// the kinds are checked in the order of PIIKinds whatever the order they are given in
+	var checked []string
+
+	for _, kind := range PIIKinds {
+		if slices.Contains(kinds, kind) {
+			checked = append(checked, kind)
+		}
+	}
+
+	return &pii{checked}, nil
+}
+
+func (f *pii) Name() string {
+	return "pii"
+}
+
+func (f *pii) Window() int {
+	return piiWindow
+}
+
+func (f *pii) Check(ctx context.Context, text string) *Violation {
+	for _, kind := range f.kinds {
+		for _, match := range piiPatterns[kind].FindAllString(text, -1) {
+			// the numbers looking like card numbers are only cards when their checksum is valid
+			if kind == "credit_card" && !luhn(match) {
+				continue
+			}
+
+			return &Violation{
+				Filter: f.Name(),
+				Reason: "contains personal information (" + strings.ReplaceAll(kind, "_", " ") + ")",
+			}
+		}
+	}
+
+	return nil
+}
+
+// luhn reports whether the digits of the number pass the luhn checksum of the card numbers
+func luhn(number string) bool {
+	sum := 0
+	double := false
+
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			continue
+		}
+
+		digit := int(number[i] - '0')
+
+		if double {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+		double = !double
+	}
+
+	return sum%10 == 0
+}
@@ -0,0 +1,230 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+)
+
+// directions of the text checked by the filters
+const (
+	Input  = "input"  // the prompts of the users
+	Output = "output" // the replies of the models
+)
+
+// Violation tells which filter rejected the text and why, the reason is shown to the user so it
+// does not repeat the offending text
+type Violation struct {
+	Filter string `json:"filter"`
+	Reason string `json:"reason"`
+}
+
+func (v *Violation) Error() string {
+	return v.Filter + ": " + v.Reason
+}
+
+// Filter enforces a content policy on the prompts or the replies
+type Filter interface {
+	Name() string
+	// Check returns a violation when the text breaks the policy, nil otherwise
+	Check(ctx context.Context, text string) *Violation
+}
+
+// Func checks the text for a custom filter
+type Func func(ctx context.Context, text string) *Violation
+
+type custom struct {
+	name  string
+	check Func
+}
+
+// New returns a custom filter checking the text with the function, the violations it returns
+// are attributed to the filter when they do not name one
+func New(name string, check Func) Filter {
+	return &custom{name, check}
+}
+
+func (c *custom) Name() string {
+	return c.name
+}
+
+func (c *custom) Check(ctx context.Context, text string) *Violation {
+	violation := c.check(ctx, text)
+
+	if violation != nil && violation.Filter == "" {
+		violation.Filter = c.name
+	}
+
+	return violation
+}
+
+// Pipeline runs the filters of the prompts and the replies in order, a nil pipeline accepts everything
+type Pipeline struct {
+	Input  []Filter
+	Output []Filter
+}
+
+// CheckInput returns the violation of the first input filter rejecting the prompt
+func (p *Pipeline) CheckInput(ctx context.Context, text string) *Violation {
+	if p == nil {
+		return nil
+	}
+
+	return check(ctx, p.Input, text)
+}
+
+// CheckOutput returns the violation of the first output filter rejecting the reply
+func (p *Pipeline) CheckOutput(ctx context.Context, text string) *Violation {
+	if p == nil {
+		return nil
+	}
+
+	return check(ctx, p.Output, text)
+}
+
+// Stream checks a reply as it is generated
+func (p *Pipeline) Stream() *Stream {
+	stream := &Stream{}
+
+	if p == nil {
+		return stream
+	}
+
+	for _, filter := range p.Output {
+		switch filter := filter.(type) {
+		case *maxLength:
+			stream.limits = append(stream.limits, filter)
+		case Windowed:
+			stream.windowed = append(stream.windowed, filter)
+			stream.window = max(stream.window, filter.Window())
+		default:
+			stream.whole = append(stream.whole, filter)
+		}
+	}
+
+	return stream
+}
+
+func check(ctx context.Context, filters []Filter, text string) *Violation {
+	for _, filter := range filters {
+		if violation := filter.Check(ctx, text); violation != nil {
+			return violation
+		}
+	}
+
+	return nil
+}
+
+// Windowed is implemented by the filters whose matches span at most Window characters, they
+// check a streamed reply a window at a time. The output filters which are not windowed need the
+// whole reply, so it is held back until the stream is flushed when there is one of them.
+type Windowed interface {
+	Filter
+	Window() int
+}
+
+// Stream checks the chunks of a streamed reply with the output filters. The last characters of the
+// reply, as many as the longest match of the windowed filters, are held back until the next chunks
+// tell whether they are part of a match, only the held back text and the new chunk are checked.
+type Stream struct {
+	limits    []*maxLength
+	windowed  []Filter
+	whole     []Filter
+	window    int
+	length    int             // characters of the reply so far
+	seen      string          // the last characters released, checked again along with the held text
+	held      string          // the text not released yet
+	reply     strings.Builder // the whole reply when there are filters which are not windowed
+	violation *Violation
+}
+
+// Write adds the chunk to the reply and returns the text which can be released to the user, once
+// the reply breaks the policy every later chunk is rejected as well
+func (s *Stream) Write(ctx context.Context, chunk string) (string, *Violation) {
+	if s.violation != nil {
+		return "", s.violation
+	}
+
+	s.length += utf8.RuneCountInString(chunk)
+
+	for _, limit := range s.limits {
+		if s.violation = limit.exceeded(s.length); s.violation != nil {
+			return "", s.violation
+		}
+	}
+
+	s.held += chunk
+
+	if len(s.whole) > 0 {
+		s.reply.WriteString(chunk)
+	}
+
+	// a match ending with the last character may go on in the next chunk, like a keyword followed
+	// by more letters, it is only checked once the next chunk or the end of the reply comes
+	text := s.seen + s.held
+	_, size := utf8.DecodeLastRuneInString(text)
+
+	if s.violation = check(ctx, s.windowed, text[:len(text)-size]); s.violation != nil {
+		return "", s.violation
+	}
+
+	if len(s.whole) > 0 {
+		return "", nil
+	}
+
+	return s.release(utf8.RuneCountInString(s.held) - s.window), nil
+}
+
+// Flush checks the held back text as the end of the reply and releases it, it is called when the
+// reply is complete or before the frames which must not overtake the text
+func (s *Stream) Flush(ctx context.Context) (string, *Violation) {
+	if s.violation != nil {
+		return "", s.violation
+	}
+
+	if s.violation = check(ctx, s.windowed, s.seen+s.held); s.violation != nil {
+		return "", s.violation
+	}
+
+	if len(s.whole) > 0 {
+		if s.violation = check(ctx, s.whole, s.reply.String()); s.violation != nil {
+			return "", s.violation
+		}
+	}
+
+	return s.release(utf8.RuneCountInString(s.held)), nil
+}
+
+// release returns the first n characters of the held text, the windowed filters keep seeing the
+// last of them so the matches starting in the released text are still caught
+func (s *Stream) release(n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	end := offset(s.held, n)
+	released := s.held[:end]
+	s.held = s.held[end:]
+
+	seen := s.seen + released
+	s.seen = seen[offset(seen, utf8.RuneCountInString(seen)-s.window):]
+
+	return released
+}
+
+// offset returns the byte offset of the nth character of the text
+func offset(text string, n int) int {
+	if n <= 0 {
+		return 0
+	}
+
+	for i := range text {
+		if n == 0 {
+			return i
+		}
+
+		n--
+	}
+
+	return len(text)
+}
@@ -0,0 +1,181 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilters(t *testing.T) {
+	ctx := context.Background()
+
+	length := MaxLength(5)
+	assert.Nil(t, length.Check(ctx, "héllo"))
+	assert.Equal(t, &Violation{Filter: "max_length", Reason: "longer than 5 characters"}, length.Check(ctx, "hello!"))
+
+	keywords, err := Keywords("secret", "c++", "  ")
+	assert.NoError(t, err)
+	assert.NotNil(t, keywords.Check(ctx, "the SECRET plan"))
+	assert.NotNil(t, keywords.Check(ctx, "written in c++."))
+	assert.Nil(t, keywords.Check(ctx, "secretary"))
+
+	_, err = Keywords()
+	assert.Error(t, err)
+
+	regex, err := Regex(0, `(?i)drop\s+table`, `rm -rf`)
+	assert.NoError(t, err)
+	assert.Equal(t, "matches a blocked pattern", regex.Check(ctx, "please DROP  TABLE users").Reason)
+	assert.NotNil(t, regex.Check(ctx, "rm -rf /"))
+	assert.Nil(t, regex.Check(ctx, "select 1"))
+
+	_, err = Regex(0, `(`)
+	assert.Error(t, err)
+
+	pii, err := PII()
+	assert.NoError(t, err)
+
+	for text, reason := range map[string]string{
+		"mail me at jane.doe@example.com":  "contains personal information (email)",
+		"call +1 555 123 4567 tonight":     "contains personal information (phone)",
+		"my card is 4111 1111 1111 1111":   "contains personal information (credit card)",
+		"ssn 078-05-1120":                  "contains personal information (ssn)",
+		"the server is at 192.168.1.20 ok": "contains personal information (ip address)",
+	} {
+		violation := pii.Check(ctx, text)
+
+		if assert.NotNil(t, violation, text) {
+			assert.Equal(t, reason, violation.Reason, text)
+		}
+	}
+
+	assert.Nil(t, pii.Check(ctx, "the answer is 42, see version 1.2.3"))
+
+	cards, err := PII("credit_card")
+	assert.NoError(t, err)
+	assert.Nil(t, cards.Check(ctx, "order 4111 1111 1111 1112"))
+	assert.Nil(t, cards.Check(ctx, "jane.doe@example.com"))
+
+	_, err = PII("shoe_size")
+	assert.Error(t, err)
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+
+	pipeline, err := Parse(`{"input": [{"type": "max_length", "max": 25}, {"type": "pii"}], "output": [{"type": "keywords", "words": ["forbidden fruit"]}]}`)
+	assert.NoError(t, err)
+
+	pipeline.Input = append(pipeline.Input, New("no_shouting", func(ctx context.Context, text string) *Violation {
+		if text == strings.ToUpper(text) {
+			return &Violation{Reason: "shouting"}
+		}
+
+		return nil
+	}))
+
+	assert.Nil(t, pipeline.CheckInput(ctx, "hello there"))
+	assert.Equal(t, "max_length", pipeline.CheckInput(ctx, "please mail jane@example.com").Filter)
+	assert.Equal(t, "pii", pipeline.CheckInput(ctx, "mail jane@example.com").Filter)
+	assert.Equal(t, &Violation{Filter: "no_shouting", Reason: "shouting"}, pipeline.CheckInput(ctx, "HELLO"))
+
+	// the keyword spans several chunks, it is caught before any of it is released
+	stream := pipeline.Stream()
+	var reply strings.Builder
+
+	for _, chunk := range []string{"the forbid", "den ", "fruit"} {
+		released, violation := stream.Write(ctx, chunk)
+		assert.Nil(t, violation)
+		reply.WriteString(released)
+	}
+
+	assert.Equal(t, "the ", reply.String())
+
+	_, violation := stream.Write(ctx, " is sweet")
+	assert.Equal(t, "keywords", violation.Filter)
+	_, violation = stream.Write(ctx, "really")
+	assert.NotNil(t, violation)
+
+	var none *Pipeline
+
+	assert.Nil(t, none.CheckInput(ctx, "anything"))
+	released, violation := none.Stream().Write(ctx, "anything")
+	assert.Equal(t, "anything", released)
+	assert.Nil(t, violation)
+
+	for _, invalid := range []string{
+		`{"input": [{"type": "sentiment"}]}`,
+		`{"output": [{"type": "max_length"}]}`,
+		`{"input": [{"type": "regex", "patterns": ["["]}]}`,
+		`[]`,
+	} {
+		_, err := Parse(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	pipeline, err = Parse("")
+	assert.NoError(t, err)
+	assert.Empty(t, pipeline.Input)
+}
+
+func TestStream(t *testing.T) {
+	ctx := context.Background()
+
+	keywords, err := Keywords("secret")
+	assert.NoError(t, err)
+
+	// the text is released once it is further from the end than the longest keyword
+	stream := (&Pipeline{Output: []Filter{keywords, MaxLength(40)}}).Stream()
+	var reply strings.Builder
+
+	for _, chunk := range []string{"the secret", "ary is ", "safe"} {
+		released, violation := stream.Write(ctx, chunk)
+		assert.Nil(t, violation, chunk)
+		reply.WriteString(released)
+	}
+
+	assert.Equal(t, "the secretary i", reply.String())
+
+	released, violation := stream.Write(ctx, "")
+	assert.Empty(t, released)
+	assert.Nil(t, violation)
+
+	released, violation = stream.Flush(ctx)
+	assert.Nil(t, violation)
+	assert.Equal(t, "the secretary is safe", reply.String()+released)
+
+	// the length counts the whole reply whatever the chunks
+	_, violation = stream.Write(ctx, strings.Repeat("a", 20))
+	assert.Equal(t, "max_length", violation.Filter)
+
+	// the keyword ending the reply is only caught once the reply is complete
+	stream = (&Pipeline{Output: []Filter{keywords}}).Stream()
+
+	released, violation = stream.Write(ctx, "keep it secret")
+	assert.Equal(t, "keep it ", released)
+	assert.Nil(t, violation)
+
+	_, violation = stream.Flush(ctx)
+	assert.Equal(t, "keywords", violation.Filter)
+
+	// the filters which are not windowed see the whole reply, it is held back until then
+	checked := 0
+	stream = (&Pipeline{Output: []Filter{keywords, New("whole", func(ctx context.Context, text string) *Violation {
+		checked++
+
+		if text != "hello world" {
+			return &Violation{Reason: "not a greeting"}
+		}
+
+		return nil
+	})}}).Stream()
+
+	released, violation = stream.Write(ctx, "hello wonderful ")
+	assert.Empty(t, released)
+	assert.Nil(t, violation)
+
+	_, violation = stream.Flush(ctx)
+	assert.Equal(t, &Violation{Filter: "whole", Reason: "not a greeting"}, violation)
+	assert.Equal(t, 1, checked)
+}
@@ -0,0 +1,77 @@
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Definition configures a builtin filter
+type Definition struct {
+	Type     string   `json:"type"`     // max_length, keywords, regex or pii
+	Max      int      `json:"max"`      // characters of max_length
+	Words    []string `json:"words"`    // words and phrases of keywords
+	Patterns []string `json:"patterns"` // regular expressions of regex
+	Window   int      `json:"window"`   // characters a match of regex spans at most, DefaultWindow when zero
+	Kinds    []string `json:"kinds"`    // kinds of personal information of pii, every kind when empty
+}
+
+// Parse returns the pipeline of the json filters of the prompts and the replies, such as
+// {"input": [{"type": "max_length", "max": 8000}, {"type": "pii"}], "output": [{"type": "keywords", "words": ["secret"]}]}
+func Parse(data string) (*Pipeline, error) {
+	pipeline := &Pipeline{}
+
+	if data == "" {
+		return pipeline, nil
+	}
+
+	var definitions struct {
+		Input  []Definition `json:"input"`
+		Output []Definition `json:"output"`
+	}
+
+	if err := json.Unmarshal([]byte(data), &definitions); err != nil {
+		return nil, fmt.Errorf("invalid moderation filters: %w", err)
+	}
+
+	for _, direction := range []struct {
+		name        string
+		definitions []Definition
+		filters     *[]Filter
+	}{
+		{Input, definitions.Input, &pipeline.Input},
+		{Output, definitions.Output, &pipeline.Output},
+	} {
+		for i, definition := range direction.definitions {
+			filter, err := Build(definition)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s filter %d: %w", direction.name, i+1, err)
+			}
+
+			*direction.filters = append(*direction.filters, filter)
+		}
+	}
+
+	return pipeline, nil
+}
+
+// Build returns the builtin filter of the definition
+func Build(definition Definition) (Filter, error) {
+	switch definition.Type {
+	case "max_length":
+		if definition.Max <= 0 {
+			return nil, errors.New("max must be positive")
+		}
+
+		return MaxLength(definition.Max), nil
+	case "keywords":
+		return Keywords(definition.Words...)
+	case "regex":
+		return Regex(definition.Window, definition.Patterns...)
+	case "pii":
+		return PII(definition.Kinds...)
+	default:
+		return nil, fmt.Errorf("unknown filter type %q", definition.Type)
+	}
+}
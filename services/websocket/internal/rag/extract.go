@@ -0,0 +1,46 @@
+package rag
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrEmpty = errors.New("no text found in the document")
+
+// Extract returns the text of the document along with its content type. Plain text, markdown and
+// pdf documents are supported.
+func Extract(name string, data []byte) (string, string, error) {
+	contentType := http.DetectContentType(data)
+
+	var text string
+
+	switch {
+	case contentType == "application/pdf":
+		var err error
+
+		if text, err = pdfText(data); err != nil {
+			return "", "", err
+		}
+	case strings.HasPrefix(contentType, "text/plain") && utf8.Valid(data):
+		text = string(data)
+
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".md", ".markdown":
+			contentType = "text/markdown"
+		default:
+			contentType = "text/plain"
+		}
+	default:
+		return "", "", fmt.Errorf("unsupported document type %q", contentType)
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return "", "", ErrEmpty
+	}
+
+	return text, contentType, nil
+}
@@ -0,0 +1,191 @@
+package rag
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// streams of a pdf, holding the page contents among others
+var pdfStream = regexp.MustCompile(`(?s)stream\r?\n(.*?)\r?\nendstream`)
+
+// pdfText extracts the text shown by the content streams of the pdf. Only the text of the fonts
+// using a standard encoding is readable, scanned pages and embedded font encodings are not.
+func pdfText(data []byte) (string, error) {
+	var text strings.Builder
+
+	for _, match := range pdfStream.FindAllSubmatch(data, -1) {
+		content := match[1]
+
+		// most streams are compressed with the flate filter
+		if reader, err := zlib.NewReader(bytes.NewReader(content)); err == nil {
+			if inflated, err := io.ReadAll(reader); err == nil {
+				content = inflated
+			}
+		}
+
+		if shown := pdfContent(content); shown != "" {
+			text.WriteString(shown)
+			text.WriteString("\n\n")
+		}
+	}
+
+	return text.String(), nil
+}
+
+// pdfContent interprets the text operators of a content stream
+func pdfContent(content []byte) string {
+	var text, pending strings.Builder
+
+	newline := func() {
+		if text.Len() > 0 && !strings.HasSuffix(text.String(), "\n") {
+			text.WriteString("\n")
+		}
+	}
+
+	for i := 0; i < len(content); {
+		c := content[i]
+
+		switch {
+		case c == '(':
+			s, next := pdfLiteral(content, i)
+			pending.WriteString(s)
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+
+			if end < 0 {
+				return text.String()
+			}
+
+			digits := strings.Map(func(r rune) rune {
+				if strings.ContainsRune(" \t\r\n", r) {
+					return -1
+				}
+
+				return r
+			}, string(content[i+1:i+end]))
+
+			if len(digits)%2 == 1 {
+				digits += "0"
+			}
+
+			if decoded, err := hex.DecodeString(digits); err == nil {
+				pending.Write(decoded)
+			}
+
+			i += end + 1
+		case c == '-' || c == '.' || c >= '0' && c <= '9':
+			start := i
+
+			for i < len(content) && (content[i] == '-' || content[i] == '.' || content[i] >= '0' && content[i] <= '9') {
+				i++
+			}
+
+			// large negative offsets in TJ arrays separate words
+			if n, err := strconv.ParseFloat(string(content[start:i]), 64); err == nil && n < -200 && pending.Len() > 0 {
+				pending.WriteString(" ")
+			}
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '\'' || c == '"' || c == '*':
+			start := i
+
+			for i < len(content) && (content[i] >= 'a' && content[i] <= 'z' || content[i] >= 'A' && content[i] <= 'Z' || content[i] == '\'' || content[i] == '"' || content[i] == '*') {
+				i++
+			}
+
+			switch string(content[start:i]) {
+			case "Tj", "TJ":
+				text.WriteString(latin1(pending.String()))
+			case "'", "\"":
+				newline()
+				text.WriteString(latin1(pending.String()))
+			case "Td", "TD", "T*", "ET":
+				newline()
+			}
+
+			pending.Reset()
+		default:
+			i++
+		}
+	}
+
+	return strings.TrimSpace(text.String())
+}
+
+// pdfLiteral decodes the literal string starting at the opening parenthesis, returning the string
+// and the position following it
+func pdfLiteral(content []byte, start int) (string, int) {
+	var s strings.Builder
+
+	depth := 0
+
+	for i := start; i < len(content); i++ {
+		c := content[i]
+
+		switch c {
+		case '\\':
+			i++
+
+			if i >= len(content) {
+				return s.String(), i
+			}
+
+			switch e := content[i]; e {
+			case 'n':
+				s.WriteByte('\n')
+			case 'r', 't', 'b', 'f':
+				s.WriteByte(' ')
+			case '\r', '\n':
+				// line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					end := i
+
+					for end < len(content) && end < i+3 && content[end] >= '0' && content[end] <= '7' {
+						end++
+					}
+
+					n, _ := strconv.ParseUint(string(content[i:end]), 8, 8)
+					s.WriteByte(byte(n))
+					i = end - 1
+				} else {
+					s.WriteByte(e)
+				}
+			}
+		case '(':
+			if depth > 0 {
+				s.WriteByte(c)
+			}
+
+			depth++
+		case ')':
+			depth--
+
+			if depth == 0 {
+				return s.String(), i + 1
+			}
+
+			s.WriteByte(c)
+		default:
+			s.WriteByte(c)
+		}
+	}
+
+	return s.String(), len(content)
+}
+
+// latin1 decodes the bytes of a string shown with a standard encoding, which match latin-1 for the
+// most part
+func latin1(s string) string {
+	runes := make([]rune, len(s))
+
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+
+	return string(runes)
+}
@@ -0,0 +1,82 @@
+package rag
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pdf returns a pdf document with a page per content stream, the second stream is compressed
+func pdf(contents ...string) []byte {
+	var document bytes.Buffer
+
+	document.WriteString("%PDF-1.4\n")
+
+	for i, content := range contents {
+		data := []byte(content)
+
+		if i%2 == 1 {
+			var compressed bytes.Buffer
+
+			writer := zlib.NewWriter(&compressed)
+			writer.Write(data)
+			writer.Close()
+
+			data = compressed.Bytes()
+		}
+
+		fmt.Fprintf(&document, "%d 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream\nendobj\n", i+1, len(data), data)
+	}
+
+	document.WriteString("%%EOF\n")
+
+	return document.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	text, contentType, err := Extract("runbook.md", []byte("# Restart\n\nRun the script."))
+	assert.NoError(t, err)
+	assert.Equal(t, "text/markdown", contentType)
+	assert.Equal(t, "# Restart\n\nRun the script.", text)
+
+	_, contentType, err = Extract("notes.txt", []byte("notes"))
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", contentType)
+
+	text, contentType, err = Extract("guide.pdf", pdf(
+		"BT /F1 12 Tf 72 712 Td (Restart the \\(primary\\) database) Tj T* [(before) -250 (noon)] TJ ET",
+		"BT /F1 12 Tf 72 712 Td <436166e9> Tj ET",
+	))
+	assert.NoError(t, err)
+	assert.Equal(t, "application/pdf", contentType)
+	assert.Equal(t, "Restart the (primary) database\nbefore noon\n\nCafé\n\n", text)
+
+	_, _, err = Extract("scan.pdf", pdf("q 100 0 0 100 0 0 cm /Im1 Do Q"))
+	assert.ErrorIs(t, err, ErrEmpty)
+
+	_, _, err = Extract("logo.png", []byte("\x89PNG\r\n\x1a\n"))
+	assert.EqualError(t, err, `unsupported document type "image/png"`)
+}
+
+func TestSplit(t *testing.T) {
+	assert.Equal(t, []string{"first paragraph\n\nsecond paragraph"}, Split("first paragraph\n\n\nsecond paragraph\n", 100, 10))
+
+	chunks := Split("one two three four five\n\nsix seven eight nine ten", 24, 10)
+
+	assert.Equal(t, []string{"one two three four five", "four five six seven eight nine ten"}, chunks)
+
+	// a single long word is cut
+	chunks = Split(strings.Repeat("é", 25), 10, 0)
+
+	assert.Len(t, chunks, 3)
+
+	for _, chunk := range chunks {
+		assert.True(t, utf8.ValidString(chunk))
+		assert.LessOrEqual(t, utf8.RuneCountInString(chunk), 10)
+	}
+}
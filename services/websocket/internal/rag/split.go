@@ -0,0 +1,129 @@
+package rag
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// paragraphs are separated by blank lines
+var paragraph = regexp.MustCompile(`\n\s*\n`)
+
+// Split cuts the text into chunks of about size characters, keeping the paragraphs together when
+// they fit. Every chunk starts with the last overlap characters of the previous one, so a passage
+// cut in two can still be found.
+func Split(text string, size, overlap int) []string {
+	if overlap >= size {
+		overlap = 0
+	}
+
+	var pieces []string
+
+	for _, p := range paragraph.Split(strings.ReplaceAll(text, "\r\n", "\n"), -1) {
+		if p = strings.TrimSpace(p); p != "" {
+			pieces = append(pieces, fit(p, size)...)
+		}
+	}
+
+	var chunks []string
+	var current string
+
+	for _, piece := range pieces {
+		if current == "" {
+			current = piece
+			continue
+		}
+
+		if length(current)+2+length(piece) <= size {
+			current += "\n\n" + piece
+			continue
+		}
+
+		chunks = append(chunks, current)
+		current = piece
+
+		if tail := tail(chunks[len(chunks)-1], overlap); tail != "" && length(tail)+1+length(piece) <= size+overlap {
+			current = tail + " " + piece
+		}
+	}
+
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
+
+// fit cuts the paragraph between words into pieces of at most size characters, the words longer
+// than size are cut as well
+func fit(text string, size int) []string {
+	if length(text) <= size {
+		return []string{text}
+	}
+
+	var pieces []string
+	var current string
+
+	for _, word := range strings.Fields(text) {
+		for length(word) > size {
+			if current != "" {
+				pieces = append(pieces, current)
+				current = ""
+			}
+
+			cut := prefix(word, size)
+			pieces = append(pieces, cut)
+			word = word[len(cut):]
+		}
+
+		switch {
+		case current == "":
+			current = word
+		case length(current)+1+length(word) <= size:
+			current += " " + word
+		default:
+			pieces = append(pieces, current)
+			current = word
+		}
+	}
+
+	if current != "" {
+		pieces = append(pieces, current)
+	}
+
+	return pieces
+}
+
+// tail returns the last words of the text within n characters
+func tail(text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	words := strings.Fields(text)
+	start, total := len(words), 0
+
+	for start > 0 && total+length(words[start-1])+1 <= n+1 {
+		total += length(words[start-1]) + 1
+		start--
+	}
+
+	return strings.Join(words[start:], " ")
+}
+
+// prefix returns the first n characters of the text
+func prefix(text string, n int) string {
+	i := 0
+
+	for n > 0 && i < len(text) {
+		_, width := utf8.DecodeRuneInString(text[i:])
+		i += width
+		n--
+	}
+
+	return text[:i]
+}
+
+func length(text string) int {
+	return utf8.RuneCountInString(text)
+}
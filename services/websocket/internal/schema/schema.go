@@ -0,0 +1,399 @@
+// Package schema validates json documents against the subset of json schema the models are asked
+// to follow: types, properties, items, enumerations, bounds, patterns and combinations.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error lists the reasons a document does not follow the schema
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// types of the values
+var types = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// Schema is a compiled json schema
+type Schema struct {
+	never bool // the false schema, no value is valid
+
+	types                []string
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema // every additional property is valid when nil
+	items                *Schema
+	enum                 []interface{}
+	constant             *interface{}
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	minLength            *int
+	maxLength            *int
+	minItems             *int
+	maxItems             *int
+	pattern              *regexp.Regexp
+	allOf                []*Schema
+	anyOf                []*Schema
+	oneOf                []*Schema
+}
+
+// definition is a schema as it is written
+type definition struct {
+	Ref                  string                     `json:"$ref"`
+	Type                 json.RawMessage            `json:"type"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Items                json.RawMessage            `json:"items"`
+	Enum                 []interface{}              `json:"enum"`
+	Const                json.RawMessage            `json:"const"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	ExclusiveMinimum     *float64                   `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64                   `json:"exclusiveMaximum"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	Pattern              *string                    `json:"pattern"`
+	AllOf                []json.RawMessage          `json:"allOf"`
+	AnyOf                []json.RawMessage          `json:"anyOf"`
+	OneOf                []json.RawMessage          `json:"oneOf"`
+}
+
+// Compile checks the schema and prepares it for validation. References are not supported.
+func Compile(raw json.RawMessage) (*Schema, error) {
+	raw = bytes.TrimSpace(raw)
+
+	switch string(raw) {
+	case "true":
+		return &Schema{}, nil
+	case "false":
+		return &Schema{never: true}, nil
+	}
+
+	var d definition
+
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	if d.Ref != "" {
+		return nil, fmt.Errorf("unsupported schema reference %q", d.Ref)
+	}
+
+	s := &Schema{
+		required:         d.Required,
+		enum:             d.Enum,
+		minimum:          d.Minimum,
+		maximum:          d.Maximum,
+		exclusiveMinimum: d.ExclusiveMinimum,
+		exclusiveMaximum: d.ExclusiveMaximum,
+		minLength:        d.MinLength,
+		maxLength:        d.MaxLength,
+		minItems:         d.MinItems,
+		maxItems:         d.MaxItems,
+	}
+
+	if len(d.Type) > 0 {
+		if err := json.Unmarshal(d.Type, &s.types); err != nil {
+			var single string
+
+			if err := json.Unmarshal(d.Type, &single); err != nil {
+				return nil, fmt.Errorf("invalid schema type %s", d.Type)
+			}
+
+			s.types = []string{single}
+		}
+
+		for _, t := range s.types {
+			if !slices.Contains(types, t) {
+				return nil, fmt.Errorf("unknown schema type %q", t)
+			}
+		}
+	}
+
+	if len(d.Const) > 0 {
+		var constant interface{}
+
+		if err := json.Unmarshal(d.Const, &constant); err != nil {
+			return nil, fmt.Errorf("invalid schema const: %w", err)
+		}
+
+		s.constant = &constant
+	}
+
+	if d.Pattern != nil {
+		pattern, err := regexp.Compile(*d.Pattern)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema pattern: %w", err)
+		}
+
+		s.pattern = pattern
+	}
+
+	var err error
+
+	if len(d.Properties) > 0 {
+		s.properties = make(map[string]*Schema, len(d.Properties))
+
+		for name, property := range d.Properties {
+			if s.properties[name], err = Compile(property); err != nil {
+				return nil, fmt.Errorf("property %q: %w", name, err)
+			}
+		}
+	}
+
+	if len(d.AdditionalProperties) > 0 {
+		if s.additionalProperties, err = Compile(d.AdditionalProperties); err != nil {
+			return nil, fmt.Errorf("additionalProperties: %w", err)
+		}
+	}
+
+	if len(d.Items) > 0 {
+		if s.items, err = Compile(d.Items); err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+	}
+
+	for _, combination := range []struct {
+		name    string
+		raw     []json.RawMessage
+		schemas *[]*Schema
+	}{
+		{"allOf", d.AllOf, &s.allOf},
+		{"anyOf", d.AnyOf, &s.anyOf},
+		{"oneOf", d.OneOf, &s.oneOf},
+	} {
+		for _, raw := range combination.raw {
+			schema, err := Compile(raw)
+
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", combination.name, err)
+			}
+
+			*combination.schemas = append(*combination.schemas, schema)
+		}
+	}
+
+	return s, nil
+}
+
+// Validate checks the json document follows the schema, returning an *Error listing the problems
+// otherwise
+func (s *Schema) Validate(data []byte) error {
+	var value interface{}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return &Error{Problems: []string{"invalid json: " + err.Error()}}
+	}
+
+	if problems := s.check("$", value); len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+
+	return nil
+}
+
+// check returns the problems of the value found at the path
+func (s *Schema) check(path string, value interface{}) []string {
+	if s.never {
+		return []string{path + ": no value is allowed"}
+	}
+
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(t string) bool { return is(t, value) }) {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(s.types, " or "), kind(value))}
+	}
+
+	var problems []string
+
+	if len(s.enum) > 0 && !slices.ContainsFunc(s.enum, func(allowed interface{}) bool { return reflect.DeepEqual(allowed, value) }) {
+		problems = append(problems, fmt.Sprintf("%s: must be one of %s", path, encode(s.enum)))
+	}
+
+	if s.constant != nil && !reflect.DeepEqual(*s.constant, value) {
+		problems = append(problems, fmt.Sprintf("%s: must be %s", path, encode(*s.constant)))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		problems = append(problems, s.object(path, v)...)
+	case []interface{}:
+		problems = append(problems, s.array(path, v)...)
+	case string:
+		problems = append(problems, s.string(path, v)...)
+	case float64:
+		problems = append(problems, s.number(path, v)...)
+	}
+
+	for _, schema := range s.allOf {
+		problems = append(problems, schema.check(path, value)...)
+	}
+
+	if len(s.anyOf) > 0 && !slices.ContainsFunc(s.anyOf, func(schema *Schema) bool { return len(schema.check(path, value)) == 0 }) {
+		problems = append(problems, path+": must match at least one of the anyOf schemas")
+	}
+
+	if len(s.oneOf) > 0 {
+		matched := 0
+
+		for _, schema := range s.oneOf {
+			if len(schema.check(path, value)) == 0 {
+				matched++
+			}
+		}
+
+		if matched != 1 {
+			problems = append(problems, fmt.Sprintf("%s: must match exactly one of the oneOf schemas, matched %d", path, matched))
+		}
+	}
+
+	return problems
+}
+
+func (s *Schema) object(path string, object map[string]interface{}) []string {
+	var problems []string
+
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: missing required property %q", path, name))
+		}
+	}
+
+	// sorted to report the problems in a stable order
+	names := make([]string, 0, len(object))
+
+	for name := range object {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := s.properties[name]
+
+		if !ok {
+			property = s.additionalProperties
+		}
+
+		if property != nil {
+			problems = append(problems, property.check(path+"."+name, object[name])...)
+		}
+	}
+
+	return problems
+}
+
+func (s *Schema) array(path string, array []interface{}) []string {
+	var problems []string
+
+	if s.minItems != nil && len(array) < *s.minItems {
+		problems = append(problems, fmt.Sprintf("%s: must have at least %d items", path, *s.minItems))
+	}
+
+	if s.maxItems != nil && len(array) > *s.maxItems {
+		problems = append(problems, fmt.Sprintf("%s: must have at most %d items", path, *s.maxItems))
+	}
+
+	if s.items != nil {
+		for i, item := range array {
+			problems = append(problems, s.items.check(fmt.Sprintf("%s[%d]", path, i), item)...)
+		}
+	}
+
+	return problems
+}
+
+func (s *Schema) string(path string, value string) []string {
+	var problems []string
+
+	length := utf8.RuneCountInString(value)
+
+	if s.minLength != nil && length < *s.minLength {
+		problems = append(problems, fmt.Sprintf("%s: must be at least %d characters long", path, *s.minLength))
+	}
+
+	if s.maxLength != nil && length > *s.maxLength {
+		problems = append(problems, fmt.Sprintf("%s: must be at most %d characters long", path, *s.maxLength))
+	}
+
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		problems = append(problems, fmt.Sprintf("%s: must match %q", path, s.pattern))
+	}
+
+	return problems
+}
+
+func (s *Schema) number(path string, value float64) []string {
+	var problems []string
+
+	if s.minimum != nil && value < *s.minimum {
+		problems = append(problems, fmt.Sprintf("%s: must be at least %v", path, *s.minimum))
+	}
+
+	if s.maximum != nil && value > *s.maximum {
+		problems = append(problems, fmt.Sprintf("%s: must be at most %v", path, *s.maximum))
+	}
+
+	if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+		problems = append(problems, fmt.Sprintf("%s: must be greater than %v", path, *s.exclusiveMinimum))
+	}
+
+	if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+		problems = append(problems, fmt.Sprintf("%s: must be less than %v", path, *s.exclusiveMaximum))
+	}
+
+	return problems
+}
+
+// is reports whether the value is of the type
+func is(t string, value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return t == "object"
+	case []interface{}:
+		return t == "array"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || t == "integer" && v == math.Trunc(v)
+	case bool:
+		return t == "boolean"
+	case nil:
+		return t == "null"
+	}
+
+	return false
+}
+
+// kind names the type of the value
+func kind(value interface{}) string {
+	for _, t := range []string{"object", "array", "string", "integer", "number", "boolean", "null"} {
+		if is(t, value) {
+			return t
+		}
+	}
+
+	return "unknown"
+}
+
+func encode(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -0,0 +1,87 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const incident = `{
+	"type": "object",
+	"properties": {
+		"title": {"type": "string", "minLength": 3, "maxLength": 40},
+		"severity": {"enum": ["low", "high"]},
+		"impact": {"type": "integer", "minimum": 0, "exclusiveMaximum": 100},
+		"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}, "maxItems": 2},
+		"owner": {"anyOf": [{"type": "string"}, {"type": "null"}]},
+		"version": {"const": 1}
+	},
+	"required": ["title", "severity"],
+	"additionalProperties": false
+}`
+
+func TestValidate(t *testing.T) {
+	schema, err := Compile(json.RawMessage(incident))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		document string
+		problems []string
+	}{
+		{name: "valid", document: `{"title": "outage", "severity": "high", "impact": 42, "tags": ["db"], "owner": null, "version": 1}`},
+		{name: "invalid json", document: `{"title":`, problems: []string{"invalid json: unexpected end of JSON input"}},
+		{name: "type", document: `["outage"]`, problems: []string{"$: expected object, got array"}},
+		{name: "required", document: `{"title": "outage"}`, problems: []string{`$: missing required property "severity"`}},
+		{name: "additional", document: `{"title": "outage", "severity": "low", "extra": true}`, problems: []string{"$.extra: no value is allowed"}},
+		{name: "enum", document: `{"title": "outage", "severity": "medium"}`, problems: []string{`$.severity: must be one of ["low","high"]`}},
+		{name: "string", document: `{"title": "up", "severity": "low"}`, problems: []string{"$.title: must be at least 3 characters long"}},
+		{name: "integer", document: `{"title": "outage", "severity": "low", "impact": 4.5}`, problems: []string{"$.impact: expected integer, got number"}},
+		{name: "bounds", document: `{"title": "outage", "severity": "low", "impact": 100}`, problems: []string{"$.impact: must be less than 100"}},
+		{
+			name:     "items",
+			document: `{"title": "outage", "severity": "low", "tags": ["db", "Net", "x"]}`,
+			problems: []string{"$.tags: must have at most 2 items", `$.tags[1]: must match "^[a-z]+$"`},
+		},
+		{name: "anyOf", document: `{"title": "outage", "severity": "low", "owner": 7}`, problems: []string{"$.owner: must match at least one of the anyOf schemas"}},
+		{name: "const", document: `{"title": "outage", "severity": "low", "version": 2}`, problems: []string{"$.version: must be 1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := schema.Validate([]byte(test.document))
+
+			if test.problems == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var invalid *Error
+
+			assert.ErrorAs(t, err, &invalid)
+			assert.Equal(t, test.problems, invalid.Problems)
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	for _, invalid := range []string{
+		`{"type": "text"}`,
+		`{"type": 1}`,
+		`{"pattern": "("}`,
+		`{"$ref": "#/definitions/item"}`,
+		`{"properties": {"name": {"type": "word"}}}`,
+		`[]`,
+	} {
+		_, err := Compile(json.RawMessage(invalid))
+		assert.Error(t, err, invalid)
+	}
+
+	schema, err := Compile(json.RawMessage(`{"oneOf": [{"type": "integer"}, {"type": "number"}]}`))
+	assert.NoError(t, err)
+
+	// an integer is a number as well
+	assert.Error(t, schema.Validate([]byte(`1`)))
+	assert.NoError(t, schema.Validate([]byte(`1.5`)))
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"pkg/auth"
+	"websocket/internal/model"
+	"websocket/internal/store"
+)
+
+var (
+	errUploadNotFound = errors.New("upload not found")
+	errUploadTooLarge = errors.New("files must not exceed")
+	errFileRequired   = errors.New("the file field is required")
+	errUnreadableFile = errors.New("unable to read the file")
+)
+
+// imageTypes are the types of the images the vision models accept
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+}
+
+// attach validates the images attached to the message of the user and returns their content
+// encoded in base64. The metadata of the attachments is kept with the message of the turn, the
+// inline ones are uploaded when the turn is remembered by its conversation.
+func (m *Service) attach(ctx context.Context, user string, turn *turn, attachments []model.Attachment) ([]string, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	if len(attachments) > m.maxAttachments {
+		return nil, fmt.Errorf("at most %d attachments are allowed", m.maxAttachments)
+	}
+
+	images := make([]string, 0, len(attachments))
+
+	for _, attachment := range attachments {
+		var upload *store.Upload
+
+		if attachment.UploadID != "" {
+			var err error
+
+			if upload, err = m.store.Upload(ctx, user, attachment.UploadID); err != nil {
+				return nil, errUploadNotFound
+			}
+		} else {
+			data, err := base64.StdEncoding.DecodeString(attachment.Data)
+
+			if err != nil || len(data) == 0 {
+				return nil, errors.New("attachments must be encoded in base64")
+			}
+
+			upload = &store.Upload{
+				ID:          newID(),
+				Owner:       user,
+				Name:        attachment.Name,
+				ContentType: http.DetectContentType(data),
+				Size:        int64(len(data)),
+				Data:        data,
+			}
+
+			turn.uploads = append(turn.uploads, upload)
+		}
+
+		if upload.Size > m.attachmentSize {
+			return nil, fmt.Errorf("attachments must not exceed %d bytes", m.attachmentSize)
+		}
+
+		if !imageTypes[upload.ContentType] {
+			return nil, fmt.Errorf("unsupported attachment type %q", upload.ContentType)
+		}
+
+		turn.message.Attachments = append(turn.message.Attachments, store.Attachment{
+			Upload:      upload.ID,
+			Name:        upload.Name,
+			ContentType: upload.ContentType,
+			Size:        upload.Size,
+		})
+
+		images = append(images, base64.StdEncoding.EncodeToString(upload.Data))
+	}
+
+	return images, nil
+}
+
+// readUpload reads the file posted in the file field of a multipart form, up to the upload size
+func (m *Service) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, *multipart.FileHeader, error) {
+	// leave room for the rest of the form
+	r.Body = http.MaxBytesReader(w, r.Body, m.uploadSize+64*1024)
+
+	file, header, err := r.FormFile("file")
+
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+
+		if errors.As(err, &tooLarge) {
+			return nil, nil, fmt.Errorf("%w %d bytes", errUploadTooLarge, m.uploadSize)
+		}
+
+		return nil, nil, errFileRequired
+	}
+
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, m.uploadSize+1))
+
+	if err != nil {
+		return nil, nil, errUnreadableFile
+	}
+
+	if int64(len(data)) > m.uploadSize {
+		return nil, nil, fmt.Errorf("%w %d bytes", errUploadTooLarge, m.uploadSize)
+	}
+
+	return data, header, nil
+}
+
+// uploadStatus is the status of the response when the file could not be read
+func uploadStatus(err error) int {
+	if errors.Is(err, errUploadTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+// Uploads returns the handler of the file uploads, serving /uploads. The uploaded files can be
+// attached to chats by their id.
+func (m *Service) Uploads() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /uploads", m.authorized(apiError, m.upload))
+	mux.HandleFunc("GET /uploads/{id}", m.authorized(apiError, m.download))
+	mux.HandleFunc("DELETE /uploads/{id}", m.authorized(apiError, m.deleteUpload))
+
+	return mux
+}
+
+// upload keeps the file posted in the file field of a multipart form
+func (m *Service) upload(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	data, header, err := m.readUpload(w, r)
+
+	if err != nil {
+		apiError(w, uploadStatus(err), err.Error())
+		return
+	}
+
+	upload := &store.Upload{
+		ID:          newID(),
+		Owner:       claims.UserID,
+		Name:        header.Filename,
+		ContentType: http.DetectContentType(data),
+		Size:        int64(len(data)),
+		Data:        data,
+	}
+
+	if err := m.store.SaveUpload(r.Context(), upload); err != nil {
+		slog.Error("unable to save upload", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to save the file")
+
+		return
+	}
+
+	respond(w, http.StatusCreated, upload)
+}
+
+func (m *Service) download(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	upload, err := m.store.Upload(r.Context(), claims.UserID, r.PathValue("id"))
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		apiError(w, http.StatusNotFound, errUploadNotFound.Error())
+	case err != nil:
+		slog.Error("unable to load upload", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to load the file")
+	default:
+		w.Header().Set("Content-Type", upload.ContentType)
+		w.Write(upload.Data)
+	}
+}
+
+func (m *Service) deleteUpload(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	err := m.store.DeleteUpload(r.Context(), claims.UserID, r.PathValue("id"))
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		apiError(w, http.StatusNotFound, errUploadNotFound.Error())
+	case err != nil:
+		slog.Error("unable to delete upload", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to delete the file")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
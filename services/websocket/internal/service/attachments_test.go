@@ -0,0 +1,148 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	mock_kafka "pkg/kafka/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// pixel is a png image of a single pixel
+const pixel = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR4nGNgYPj/HwADAgH/eL9GtQAAAABJRU5ErkJggg=="
+
+func TestAttachments(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	provider := newRecorder("A single pixel")
+
+	service, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_kafka.NewMockProducer(ctrl), authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(provider),
+		WithAttachments(2, 128),
+		WithUploadSize(256),
+	)
+
+	server := httptest.NewServer(service.Uploads())
+	t.Cleanup(server.Close)
+
+	upload := func(name string, data []byte) (int, map[string]interface{}) {
+		body := &bytes.Buffer{}
+		form := multipart.NewWriter(body)
+
+		file, _ := form.CreateFormFile("file", name)
+		file.Write(data)
+		form.Close()
+
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/uploads", body)
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("Content-Type", form.FormDataContentType())
+
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		response := map[string]interface{}{}
+		json.NewDecoder(res.Body).Decode(&response)
+
+		return res.StatusCode, response
+	}
+
+	image, _ := base64.StdEncoding.DecodeString(pixel)
+
+	status, uploaded := upload("pixel.png", image)
+
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, "image/png", uploaded["content_type"])
+	assert.Equal(t, float64(len(image)), uploaded["size"])
+
+	status, _ = upload("large.bin", make([]byte, 512))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	conn := dial(t, url, "token")
+
+	// expectError sends the chat and expects it to be rejected with the reason
+	expectError := func(t *testing.T, reason string, attachments ...map[string]string) {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "what is this?", "request_id": reason, "attachments": attachments})
+
+		for {
+			frame, err := readFrame(t, conn)
+			assert.NoError(t, err)
+
+			if frame["request_id"] == reason {
+				assert.Equal(t, reason, frame["error"])
+				return
+			}
+		}
+	}
+
+	t.Run("validation", func(t *testing.T) {
+		expectError(t, "at most 2 attachments are allowed", map[string]string{"data": pixel}, map[string]string{"data": pixel}, map[string]string{"data": pixel})
+		expectError(t, "attachments must be encoded in base64", map[string]string{"data": "not base64!"})
+		expectError(t, `unsupported attachment type "text/plain; charset=utf-8"`, map[string]string{"data": base64.StdEncoding.EncodeToString([]byte("hello"))})
+		expectError(t, "attachments must not exceed 128 bytes", map[string]string{"data": base64.StdEncoding.EncodeToString(append(image, make([]byte, 128)...))})
+		expectError(t, "upload not found", map[string]string{"upload_id": "unknown"})
+	})
+
+	t.Run("conversation", func(t *testing.T) {
+		conn.WriteJSON(map[string]interface{}{
+			"type":            "chat",
+			"model":           "llava",
+			"data":            "what are these?",
+			"conversation_id": "pixels",
+			"attachments": []map[string]string{
+				{"upload_id": uploaded["id"].(string)},
+				{"data": pixel, "name": "inline.png"},
+			},
+		})
+
+		chat := provider.request(t)
+
+		assert.Equal(t, []string{pixel, pixel}, chat.Messages[0].Images)
+
+		// the metadata of the attachments is kept with the conversation, the inline ones as uploads
+		var stored []map[string]interface{}
+
+		assert.Eventually(t, func() bool {
+			conversation, err := service.store.Conversation(context.Background(), "1", "pixels")
+
+			if err != nil || len(conversation.Messages) != 2 {
+				return false
+			}
+
+			data, _ := json.Marshal(conversation.Messages[0].Attachments)
+			json.Unmarshal(data, &stored)
+
+			return true
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Len(t, stored, 2)
+		assert.Equal(t, uploaded["id"], stored[0]["upload"])
+		assert.Equal(t, "inline.png", stored[1]["name"])
+		assert.Equal(t, "image/png", stored[1]["content_type"])
+
+		inline, err := service.store.Upload(context.Background(), "1", stored[1]["upload"].(string))
+		assert.NoError(t, err)
+		assert.Equal(t, image, inline.Data)
+
+		// the images stay part of the conversation
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "model": "llava", "data": "which one is brighter?", "conversation_id": "pixels"})
+
+		chat = provider.request(t)
+
+		assert.Len(t, chat.Messages, 3)
+		assert.Equal(t, []string{pixel, pixel}, chat.Messages[0].Images)
+		assert.Empty(t, chat.Messages[2].Images)
+	})
+}
@@ -1,50 +1,53 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
 	"pkg/ai"
+	"sync"
+	"time"
 	"websocket/internal/model"
+	"websocket/internal/moderation"
+	"websocket/internal/schema"
 
 	"github.com/IBM/sarama"
 	"github.com/gorilla/websocket"
-	"github.com/rifaideen/talkative"
 )
 
 // Client represents a single WebSocket connection with a send channel for messages
 type Client struct {
 	// conn holds the WebSocket connection instance
 	conn *websocket.Conn
-	// send is a channel for buffering outbound messages
-	send chan []byte
+	// send is a queue for buffering outbound messages
+	send *outbound
+	// session holds the credentials the connection is authenticated with
+	session *session
+	// renewed signals the session watcher that the credentials have been renewed
+	renewed chan struct{}
+	// done is closed once the connection stops reading
+	done chan struct{}
+
+	// flushed is closed once the write loop stops
+	flushed chan struct{}
+
+	mu       sync.Mutex
+	farewell []byte // close message written once the queued messages are flushed
 }
 
 // read handles incoming messages from the WebSocket connection
 func (c *Client) read(manager *Service) {
 	defer func() {
+		close(c.done)
 		manager.unregister <- c
 		c.conn.Close()
 	}()
 
-	go func() {
-		for {
-			select {
-			case _, ok := <-manager.producer.Successes():
-				if !ok {
-					slog.Info("producer channel closed")
-
-					return
-				}
-
-				slog.Info("message sent successfully")
-			case err := <-manager.producer.Errors():
-				slog.Error("error sending message", "error", err)
-
-				return
-			}
-		}
-	}()
+	// buckets of the rate limits of the connection
+	buckets := make(map[string]*bucket)
 
 	for {
 		message := &model.Message{}
@@ -55,104 +58,373 @@ func (c *Client) read(manager *Service) {
 			break
 		}
 
+		if kind, wait := manager.throttle(c.session.userID(), c.session.role(), buckets, message.Type); wait > 0 {
+			c.rateLimited(message.RequestID, kind, wait)
+			continue
+		}
+
+		switch message.Type {
+		case "auth":
+			// renew the credentials of the connection with a fresh token
+			c.reauthenticate(manager, message.Data)
+			continue
+		case "resume":
+			// continue a streamed response from the last frame the client received
+			c.resume(manager, message)
+			continue
+		case "ack":
+			// release the frames the client has received
+			c.ack(manager, message)
+			continue
+		case "pulls":
+			// report the model pulls the user is following
+			c.pulling(manager)
+			continue
+		case "cancel":
+			// stop following a model pull
+			c.cancel(manager, message)
+			continue
+		}
+
+		if manager.draining.Load() {
+			// the service is shutting down, the client is expected to reconnect elsewhere
+			c.error(message.RequestID, "service is shutting down, please reconnect")
+			continue
+		}
+
+		switch message.Type {
+		case "models", "show", "delete", "copy", "ps":
+			// manage the local AI models, these requests are not forwarded to kafka
+			manager.track(func() {
+				c.manage(manager, message)
+			})
+			continue
+		}
+
+		if message.Template != "" && message.Type != "pull" {
+			// fill in the template before the message is forwarded, so the prompt is kept as sent to the model
+			if err := manager.instantiate(manager.ctx, c.session.identity(), message); err != nil {
+				c.error(message.RequestID, err.Error())
+				continue
+			}
+		}
+
 		slog.Info("received message from client", "chat", message.Data)
 
 		// forward the message to the producer topic in kafka and then initiate a chat
-		manager.producer.Input() <- &sarama.ProducerMessage{
-			Topic: manager.topicProducer,
-			Value: sarama.StringEncoder(message.Data),
-		}
+		manager.publish(message.Data)
 
 		if message.Type == "pull" {
-			// pull the AI model
+			// pull the AI model, joining the pull in progress when another user already requested it
 			c.pull(manager, message.Model)
 		} else {
-			// chat with the AI
-			c.chat(manager, message.Model, message.Data)
+			// chat with the AI, the generation continues even if the connection drops
+			manager.track(func() {
+				c.chat(manager, message)
+			})
 		}
 	}
 }
 
+// deliver queues the message for writing to the connection, applying the slow consumer policy.
+// It returns false when the message could not be queued because the connection is closed or
+// has been disconnected for not keeping up.
+func (c *Client) deliver(data []byte) bool {
+	return c.queued(c.send.push(data))
+}
+
+// notify queues the message without waiting for room in the queue, the message is dropped when
+// the queue is full under the block policy. The hub notifies the clients, it must never block.
+func (c *Client) notify(data []byte) bool {
+	return c.queued(c.send.offer(data))
+}
+
+// queued applies the outcome of queueing a message, disconnecting the client when it is too slow
+func (c *Client) queued(err error) bool {
+	if errors.Is(err, errSlowConsumer) {
+		slowDisconnects.Add(1)
+		slog.Warn("disconnecting slow client", "policy", c.send.policy)
+
+		if c.conn == nil {
+			// the event stream ends once the queued messages are written
+			c.close()
+
+			return false
+		}
+
+		message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "client is too slow")
+
+		c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		c.conn.Close()
+	}
+
+	return err == nil
+}
+
+// abort closes the connection right away, without writing the queued messages
+func (c *Client) abort() {
+	if c.conn == nil {
+		c.close()
+		return
+	}
+
+	c.conn.Close()
+}
+
+// closeWith sets the close message the connection is closed with once the queued messages are written
+func (c *Client) closeWith(message []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.farewell = message
+}
+
+// close the send queue, which stops the write loop once the queued messages are written
+func (c *Client) close() {
+	c.send.close()
+}
+
 // write continuously listens on the send channel and writes messages to the WebSocket connection
 // It handles the outbound message flow until an error occurs or the connection closes
 func (c *Client) write() {
+	defer close(c.flushed)
 	defer c.conn.Close()
 
-	for message := range c.send {
+	for {
+		message, ok := c.send.pop(nil)
+
+		if !ok {
+			break
+		}
+
 		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 			slog.Error("error writing message", "error", err, "message", string(message))
-			break
+			return
 		}
 	}
+
+	c.mu.Lock()
+	farewell := c.farewell
+	c.mu.Unlock()
+
+	if farewell != nil {
+		c.conn.WriteControl(websocket.CloseMessage, farewell, time.Now().Add(time.Second))
+	}
 }
 
-func (c *Client) chat(manager *Service, model, prompt string) {
-	// Callback function to handle the response
-	callback := func(cr *talkative.ChatResponse, err error) {
-		if err != nil {
-			slog.Error("unable to process chat response", "error", err)
+func (c *Client) chat(manager *Service, message *model.Message) {
+	stream, err := manager.streams.open(c, message.RequestID)
+
+	if err != nil {
+		c.error(message.RequestID, err.Error())
+		return
+	}
+
+	defer stream.finish()
+
+	if err := manager.quota(manager.ctx, c.session.userID(), c.session.role()); err != nil {
+		stream.send(map[string]interface{}{
+			"type":  "chat",
+			"error": err.Error(),
+			"done":  true,
+		})
+
+		return
+	}
+
+	if rejected := manager.moderation.CheckInput(manager.ctx, message.Data); rejected != nil {
+		manager.audit(c.session.identity(), message.RequestID, message.Model, moderation.Input, rejected)
+
+		stream.send(map[string]interface{}{
+			"type":   "chat",
+			"error":  moderationError(moderation.Input, rejected),
+			"filter": rejected.Filter,
+			"done":   true,
+		})
+
+		return
+	}
+
+	turn, err := manager.prompt(manager.ctx, c.session.userID(), message)
+
+	if err != nil {
+		stream.send(map[string]interface{}{
+			"type":  "chat",
+			"error": err.Error(),
+			"done":  true,
+		})
+
+		return
+	}
+
+	request := turn.request
+
+	if !manager.allowed(request.Model) {
+		stream.send(map[string]interface{}{
+			"type":  "chat",
+			"error": "model not allowed",
+			"done":  true,
+		})
+
+		return
+	}
+
+	if err := manager.generation(c.session.role(), request); err != nil {
+		stream.send(map[string]interface{}{
+			"type":  "chat",
+			"error": err.Error(),
+			"done":  true,
+		})
+
+		return
+	}
+
+	if len(message.Tools) > 0 {
+		if request.Tools, err = manager.tools.Definitions(c.session.role(), message.Tools); err != nil {
+			stream.send(map[string]interface{}{
+				"type":  "chat",
+				"error": err.Error(),
+				"done":  true,
+			})
+
 			return
 		}
+	}
 
-		data, err := json.Marshal(map[string]interface{}{
-			"type": "chat",
-			"data": cr.Message.Content,
-			"done": cr.Done,
-		})
+	send := stream.send
+
+	var output *schema.Schema
+
+	if len(message.Format) > 0 {
+		if output, err = format(message.Format); err != nil {
+			stream.send(map[string]interface{}{
+				"type":  "chat",
+				"error": err.Error(),
+				"done":  true,
+			})
 
-		if err != nil {
-			slog.Error("unable to marshal json respose", "error", err)
 			return
 		}
 
-		c.send <- data
+		request.Format = message.Format
+
+		// the result frame ends the response once the reply has been validated
+		send = func(frame map[string]interface{}) {
+			if frame["type"] == "chat" {
+				frame["done"] = false
+			}
+
+			stream.send(frame)
+		}
 	}
-	// Additional parameters to include. (Optional)
-	var params *talkative.ChatParams = nil
-	// The chat message to send
-	message := talkative.ChatMessage{
-		Role:    talkative.USER, // Initiate the chat as a user
-		Content: prompt,
+
+	if len(turn.citations) > 0 {
+		stream.send(map[string]interface{}{
+			"type":      "citations",
+			"citations": turn.citations,
+		})
+	}
+
+	spent := metered(c.session.identity(), request.Model)
+	defer manager.record(spent)
+
+	// the chunks of the reply are checked by the output filters before they are sent
+	guard, ctx := manager.guard(manager.ctx, send)
+	defer guard.stop()
+
+	reply, err := manager.generate(ctx, c.session.identity(), request, spent, guard.forward)
+
+	var result map[string]interface{}
+
+	if err == nil && output != nil && guard.violation == nil {
+		reply, result, err = manager.conform(ctx, c.session.identity(), request, reply, output, message.Retry, spent, guard.forward)
 	}
 
-	done, err := manager.ollama.Chat(model, callback, params, message)
+	if guard.violation != nil {
+		manager.audit(c.session.identity(), message.RequestID, request.Model, moderation.Output, guard.violation)
+
+		stream.send(map[string]interface{}{
+			"type":   "chat",
+			"error":  moderationError(moderation.Output, guard.violation),
+			"filter": guard.violation.Filter,
+			"done":   true,
+		})
+
+		return
+	}
 
 	if err != nil {
-		panic(err)
+		slog.Error("unable to chat", "model", request.Model, "error", err)
+
+		stream.send(map[string]interface{}{
+			"type":  "chat",
+			"error": chatError(err),
+			"done":  true,
+		})
+
+		return
+	}
+
+	if result != nil {
+		stream.send(result)
 	}
 
-	<-done // wait for the chat to complete
+	manager.remember(turn, reply)
+	manager.entitle(c.session.identity(), turn, request.Model, reply)
+	manager.condense(c.session.identity(), turn.conversation, request.Model)
 }
 
-func (c *Client) pull(manager *Service, model string) {
-	// Callback function to handle the response
-	callback := func(cr *ai.PullResponse, err error) {
-		if err != nil {
-			slog.Error("unable to process pull response", "error", err)
-			return
-		}
+// chatError describes the reason the chat failed to the client
+func chatError(err error) string {
+	var apiErr *ai.APIError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "chat canceled"
+	case errors.As(err, &apiErr) && apiErr.NotFound():
+		return "model not found, please pull it first"
+	case errors.Is(err, errToolRounds):
+		return "the assistant called too many tools"
+	default:
+		return "unable to chat"
+	}
+}
 
-		data, err := json.Marshal(map[string]interface{}{
-			"type": "pull",
-			"data": cr,
-			"done": cr.Status == "success" || cr.Status == "writing manifest",
-		})
+// resume attaches the connection to a streamed response and replays the frames the client missed
+func (c *Client) resume(manager *Service, message *model.Message) {
+	stream := manager.streams.get(c.session.userID(), message.RequestID)
 
-		if err != nil {
-			slog.Error("unable to marshal json respose", "error", err)
-			return
-		}
+	if stream == nil {
+		c.error(message.RequestID, "unknown or expired request")
+		return
+	}
 
-		c.send <- data
+	if lost := stream.attach(c, message.Seq); lost > 0 {
+		c.error(message.RequestID, fmt.Sprintf("frames from seq %d are no longer available", lost))
 	}
+}
+
+// ack releases the buffered frames of a streamed response up to the acknowledged sequence number
+func (c *Client) ack(manager *Service, message *model.Message) {
+	if stream := manager.streams.get(c.session.userID(), message.RequestID); stream != nil {
+		stream.ack(message.Seq)
+	}
+}
 
-	done, err := manager.ai.Pull(model, callback)
+// error sends an error frame related to the request to the client
+func (c *Client) error(requestID, reason string) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":       "error",
+		"request_id": requestID,
+		"error":      reason,
+	})
 
 	if err != nil {
-		panic(err)
+		slog.Error("unable to marshal json response", "error", err)
+		return
 	}
 
-	<-done // wait for the chat to complete
+	c.deliver(data)
 }
 
 // Setup implements the ConsumerGroupHandler interface
@@ -183,7 +455,7 @@ func (c *Client) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.
 
 			log.Printf("Message received: topic=%s partition=%d offset=%d value=%s",
 				msg.Topic, msg.Partition, msg.Offset, string(msg.Value))
-			c.send <- msg.Value
+			c.deliver(msg.Value)
 			session.MarkMessage(msg, "")
 		case <-session.Context().Done():
 			return nil
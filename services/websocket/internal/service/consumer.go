@@ -1,13 +1,13 @@
 package service
 
 import (
-	"encoding/json"
 	"log/slog"
+	"pkg/notification"
 
 	"github.com/IBM/sarama"
 )
 
-// Consumer handles Kafka message consumption and broadcasts to websocket clients
+// Consumer handles Kafka message consumption and delivers notifications to websocket clients
 type Consumer struct {
 	manager *Service // Reference to websocket connection manager service
 }
@@ -33,23 +33,11 @@ func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim saram
 				return nil
 			}
 
-			message := string(msg.Value)
-
 			// Log received message details
-			slog.Info("message received from kafka", "topic", msg.Topic, "message", message)
-
-			data, err := json.Marshal(map[string]interface{}{
-				"type": "notification",
-				"data": message,
-			})
-
-			if err != nil {
-				slog.Error("error marshalling message", "error", err)
-				continue
-			}
+			slog.Info("message received from kafka", "topic", msg.Topic, "message", string(msg.Value))
 
-			// Broadcast message to all connected websocket clients
-			c.manager.broadcast <- data
+			// Deliver the notification to the connected websocket clients of its audience
+			c.manager.notifications <- notification.Parse(msg.Value)
 
 			// Mark message as processed
 			session.MarkMessage(msg, "")
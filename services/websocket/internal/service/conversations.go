@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"maps"
+	"pkg/ai"
+	"websocket/internal/model"
+	"websocket/internal/store"
+)
+
+var (
+	errPersonaNotFound = errors.New("persona not found")
+	errConversation    = errors.New("unable to load conversation")
+)
+
+// turn is the chat request of a message sent by the user
+type turn struct {
+	request      *ai.ChatRequest
+	conversation *store.Conversation // nil when the message is not part of a conversation
+	message      store.Message       // the message of the user as it is kept in the conversation
+	uploads      []*store.Upload     // inline attachments uploaded once the conversation is saved
+	citations    []citation          // chunks of the documents injected into the prompt
+}
+
+// prompt builds the chat request of the message sent by the user. The system prompt of the persona
+// or the ad-hoc one is prepended, followed by the earlier messages when continuing a conversation
+// and the excerpts of the documents of the user when retrieval is enabled.
+func (m *Service) prompt(ctx context.Context, user string, message *model.Message) (*turn, error) {
+	persona, system := message.Persona, message.System
+	retrieval := message.Retrieval != nil && *message.Retrieval
+
+	turn := &turn{
+		request: &ai.ChatRequest{
+			Model: message.Model,
+		},
+	}
+
+	request := turn.request
+
+	if message.ConversationID != "" {
+		conversation, err := m.conversation(ctx, user, message)
+
+		if err != nil {
+			return nil, err
+		}
+
+		turn.conversation = conversation
+		persona, system = conversation.Persona, conversation.SystemPrompt
+		retrieval = conversation.Retrieval
+	}
+
+	if persona != "" {
+		p, err := m.store.Persona(ctx, user, persona)
+
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errPersonaNotFound
+		}
+
+		if err != nil {
+			slog.Error("unable to load persona", "persona", persona, "error", err)
+			return nil, errPersonaNotFound
+		}
+
+		if system == "" {
+			system = p.SystemPrompt
+		}
+
+		if request.Model == "" {
+			request.Model = p.Model
+		}
+
+		request.Options = maps.Clone(p.Options)
+	}
+
+	// the parameters of the client take precedence over the defaults of the persona
+	options(request, message.Options)
+
+	if system != "" {
+		request.Messages = append(request.Messages, ai.Message{Role: ai.SYSTEM, Content: system})
+	}
+
+	if turn.conversation != nil {
+		request.Messages = append(request.Messages, m.history(ctx, user, turn.conversation)...)
+	}
+
+	if retrieval {
+		excerpts, citations, err := m.retrieve(ctx, user, message.Data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if excerpts != nil {
+			request.Messages = append(request.Messages, *excerpts)
+			turn.citations = citations
+		}
+	}
+
+	turn.message.Message = ai.Message{
+		Role:    ai.USER, // Initiate the chat as a user
+		Content: message.Data,
+	}
+
+	images, err := m.attach(ctx, user, turn, message.Attachments)
+
+	if err != nil {
+		return nil, err
+	}
+
+	prompt := turn.message.Message
+	prompt.Images = images
+
+	request.Messages = append(request.Messages, prompt)
+
+	return turn, nil
+}
+
+// history returns the earlier messages of the conversation along with the images attached to them,
+// the messages covered by the summary are replaced with it
+func (m *Service) history(ctx context.Context, user string, conversation *store.Conversation) []ai.Message {
+	messages := make([]ai.Message, 0, len(conversation.Messages)+1)
+	earlier := conversation.Messages
+
+	if conversation.Summary != "" {
+		messages = append(messages, ai.Message{
+			Role:    ai.SYSTEM,
+			Content: "Summary of the earlier conversation:\n" + conversation.Summary,
+		})
+
+		earlier = earlier[min(conversation.Summarized, len(earlier)):]
+	}
+
+	for _, message := range earlier {
+		for _, attachment := range message.Attachments {
+			upload, err := m.store.Upload(ctx, user, attachment.Upload)
+
+			if err != nil {
+				// the file may have been deleted since, the rest of the conversation is still relevant
+				slog.Warn("unable to load attachment", "conversation", conversation.ID, "upload", attachment.Upload, "error", err)
+				continue
+			}
+
+			message.Images = append(message.Images, base64.StdEncoding.EncodeToString(upload.Data))
+		}
+
+		messages = append(messages, message.Message)
+	}
+
+	return messages
+}
+
+// conversation loads the conversation the message continues, starting it when unknown. The persona,
+// system prompt and retrieval setting of the message replace the ones the conversation has been
+// held with so far.
+func (m *Service) conversation(ctx context.Context, user string, message *model.Message) (*store.Conversation, error) {
+	conversation, err := m.store.Conversation(ctx, user, message.ConversationID)
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		conversation = &store.Conversation{
+			ID:    message.ConversationID,
+			Owner: user,
+		}
+	case err != nil:
+		slog.Error("unable to load conversation", "conversation", message.ConversationID, "error", err)
+		return nil, errConversation
+	case message.Persona == "" && message.System == "" && (message.Retrieval == nil || *message.Retrieval == conversation.Retrieval):
+		// nothing to change
+		return conversation, nil
+	}
+
+	if message.Persona != "" {
+		if _, err := m.store.Persona(ctx, user, message.Persona); err != nil {
+			return nil, errPersonaNotFound
+		}
+
+		// switching persona drops the ad-hoc prompt of the previous one
+		conversation.Persona = message.Persona
+		conversation.SystemPrompt = message.System
+	} else if message.System != "" {
+		conversation.SystemPrompt = message.System
+	}
+
+	if message.Retrieval != nil {
+		conversation.Retrieval = *message.Retrieval
+	}
+
+	if err := m.store.SaveConversation(ctx, conversation); err != nil {
+		slog.Error("unable to save conversation", "conversation", conversation.ID, "error", err)
+		return nil, errConversation
+	}
+
+	return conversation, nil
+}
+
+// remember appends the message of the user and the reply to the conversation, so the next
+// message continues from them
+func (m *Service) remember(turn *turn, reply string) {
+	conversation := turn.conversation
+
+	if conversation == nil {
+		return
+	}
+
+	for _, upload := range turn.uploads {
+		if err := m.store.SaveUpload(m.ctx, upload); err != nil {
+			slog.Error("unable to save attachment", "conversation", conversation.ID, "error", err)
+		}
+	}
+
+	err := m.store.AppendMessages(m.ctx, conversation.Owner, conversation.ID,
+		turn.message,
+		store.Message{Message: ai.Message{Role: ai.ASSISTANT, Content: reply}},
+	)
+
+	if err != nil {
+		slog.Error("unable to save conversation messages", "conversation", conversation.ID, "error", err)
+	}
+}
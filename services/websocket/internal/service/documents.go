@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pkg/ai"
+	"pkg/auth"
+	"strings"
+	"websocket/internal/rag"
+	"websocket/internal/store"
+)
+
+// embedBatch is how many chunks are embedded per request
+const embedBatch = 32
+
+var (
+	errDocumentNotFound = errors.New("document not found")
+	errRetrieval        = errors.New("unable to search the documents")
+)
+
+// citation is a chunk of a document injected into the prompt, cited by its index
+type citation struct {
+	Index int `json:"index"`
+	store.Match
+}
+
+// Documents returns the handler of the documents searched by the conversations opting in to
+// retrieval, serving /documents
+func (m *Service) Documents() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /documents", m.authorized(apiError, m.listDocuments))
+	mux.HandleFunc("POST /documents", m.authorized(apiError, m.createDocument))
+	mux.HandleFunc("GET /documents/{id}", m.authorized(apiError, m.getDocument))
+	mux.HandleFunc("DELETE /documents/{id}", m.authorized(apiError, m.deleteDocument))
+
+	return mux
+}
+
+func (m *Service) listDocuments(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	documents, err := m.store.Documents(r.Context(), claims.UserID)
+
+	if err != nil {
+		slog.Error("unable to list documents", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to list documents")
+
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]interface{}{
+		"data": documents,
+	})
+}
+
+// createDocument indexes the document posted in the file field of a multipart form
+func (m *Service) createDocument(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	data, header, err := m.readUpload(w, r)
+
+	if err != nil {
+		apiError(w, uploadStatus(err), err.Error())
+		return
+	}
+
+	text, contentType, err := rag.Extract(header.Filename, data)
+
+	if err != nil {
+		apiError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
+	chunks, err := m.embed(r.Context(), rag.Split(text, m.chunkSize, m.chunkOverlap))
+
+	if err != nil {
+		slog.Error("unable to embed document", "model", m.embeddingModel, "error", err)
+		apiError(w, http.StatusBadGateway, "unable to index the document")
+
+		return
+	}
+
+	document := &store.Document{
+		ID:          newID(),
+		Owner:       claims.UserID,
+		Name:        header.Filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+
+	if err := m.store.SaveDocument(r.Context(), document, chunks); err != nil {
+		slog.Error("unable to save document", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to save the document")
+
+		return
+	}
+
+	respond(w, http.StatusCreated, document)
+}
+
+func (m *Service) getDocument(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	document, err := m.store.Document(r.Context(), claims.UserID, r.PathValue("id"))
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		apiError(w, http.StatusNotFound, errDocumentNotFound.Error())
+	case err != nil:
+		slog.Error("unable to load document", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to load the document")
+	default:
+		respond(w, http.StatusOK, document)
+	}
+}
+
+func (m *Service) deleteDocument(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	err := m.store.DeleteDocument(r.Context(), claims.UserID, r.PathValue("id"))
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		apiError(w, http.StatusNotFound, errDocumentNotFound.Error())
+	case err != nil:
+		slog.Error("unable to delete document", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to delete the document")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// embed computes the embeddings of the passages with the embedding model
+func (m *Service) embed(ctx context.Context, passages []string) ([]store.Chunk, error) {
+	chunks := make([]store.Chunk, 0, len(passages))
+
+	for start := 0; start < len(passages); start += embedBatch {
+		batch := passages[start:min(start+embedBatch, len(passages))]
+
+		embeddings, err := m.provider.Embed(ctx, m.embeddingModel, batch)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(embeddings) != len(batch) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(batch), len(embeddings))
+		}
+
+		for i, passage := range batch {
+			chunks = append(chunks, store.Chunk{
+				Index:     start + i,
+				Content:   passage,
+				Embedding: embeddings[i],
+			})
+		}
+	}
+
+	return chunks, nil
+}
+
+// retrieve searches the documents of the user for the passages closest to the prompt, returning
+// the system message presenting them to the model along with their citations. The message is
+// empty when the user has no documents.
+func (m *Service) retrieve(ctx context.Context, user, prompt string) (*ai.Message, []citation, error) {
+	embeddings, err := m.provider.Embed(ctx, m.embeddingModel, []string{prompt})
+
+	if err == nil && len(embeddings) != 1 {
+		err = fmt.Errorf("expected 1 embedding, got %d", len(embeddings))
+	}
+
+	if err != nil {
+		slog.Error("unable to embed prompt", "model", m.embeddingModel, "error", err)
+		return nil, nil, errRetrieval
+	}
+
+	matches, err := m.store.Search(ctx, user, embeddings[0], m.retrievalResults)
+
+	if err != nil {
+		slog.Error("unable to search documents", "error", err)
+		return nil, nil, errRetrieval
+	}
+
+	if len(matches) == 0 {
+		return nil, nil, nil
+	}
+
+	var excerpts strings.Builder
+
+	excerpts.WriteString("Answer with the help of the following excerpts of the documents of the user when they are relevant, ")
+	excerpts.WriteString("citing the excerpts you use by their number such as [1].")
+
+	citations := make([]citation, len(matches))
+
+	for i, match := range matches {
+		citations[i] = citation{Index: i + 1, Match: match}
+
+		fmt.Fprintf(&excerpts, "\n\n[%d] %s, part %d\n%s", i+1, match.Name, match.Index+1, match.Content)
+	}
+
+	return &ai.Message{Role: ai.SYSTEM, Content: excerpts.String()}, citations, nil
+}
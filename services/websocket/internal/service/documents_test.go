@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"pkg/ai"
+	mock_kafka "pkg/kafka/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocuments(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	provider := newRecorder("Run the restart script [1]")
+
+	service, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_kafka.NewMockProducer(ctrl), authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(provider),
+		WithRetrieval("embed", 1),
+		WithChunking(60, 0),
+	)
+
+	server := httptest.NewServer(service.Documents())
+	t.Cleanup(server.Close)
+
+	request := func(method, path string, body *bytes.Buffer, contentType string) (int, map[string]interface{}) {
+		if body == nil {
+			body = &bytes.Buffer{}
+		}
+
+		req, _ := http.NewRequest(method, server.URL+path, body)
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("Content-Type", contentType)
+
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		response := map[string]interface{}{}
+		json.NewDecoder(res.Body).Decode(&response)
+
+		return res.StatusCode, response
+	}
+
+	upload := func(name, content string) (int, map[string]interface{}) {
+		body := &bytes.Buffer{}
+		form := multipart.NewWriter(body)
+
+		file, _ := form.CreateFormFile("file", name)
+		file.Write([]byte(content))
+		form.Close()
+
+		return request(http.MethodPost, "/documents", body, form.FormDataContentType())
+	}
+
+	status, document := upload("runbook.md", "To restart the database run the restart script.\n\nCertificates are rotated every month by the platform team.")
+
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, "text/markdown", document["content_type"])
+	assert.Equal(t, float64(2), document["chunks"])
+
+	status, _ = upload("logo.png", "\x89PNG\r\n\x1a\n")
+	assert.Equal(t, http.StatusUnsupportedMediaType, status)
+
+	status, listed := request(http.MethodGet, "/documents", nil, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, listed["data"], 1)
+
+	conn := dial(t, url, "token")
+
+	t.Run("retrieval", func(t *testing.T) {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "how do I restart the database?", "conversation_id": "ops", "retrieval": true, "request_id": "restart"})
+
+		frame, err := readFrame(t, conn)
+		assert.NoError(t, err)
+		assert.Equal(t, "citations", frame["type"])
+
+		citations := frame["citations"].([]interface{})
+		assert.Len(t, citations, 1)
+		assert.Equal(t, float64(1), citations[0].(map[string]interface{})["index"])
+		assert.Equal(t, document["id"], citations[0].(map[string]interface{})["document"])
+		assert.Equal(t, float64(0), citations[0].(map[string]interface{})["chunk"])
+
+		chat := provider.request(t)
+
+		assert.Len(t, chat.Messages, 2)
+		assert.Equal(t, ai.SYSTEM, chat.Messages[0].Role)
+		assert.Contains(t, chat.Messages[0].Content, "[1] runbook.md, part 1\nTo restart the database run the restart script.")
+		assert.NotContains(t, chat.Messages[0].Content, "Certificates")
+
+		// the conversation keeps searching the documents, the excerpts are not remembered
+		assert.Eventually(t, func() bool {
+			conversation, err := service.store.Conversation(context.Background(), "1", "ops")
+			return err == nil && len(conversation.Messages) == 2
+		}, time.Second, 10*time.Millisecond)
+
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "who rotates the certificates?", "conversation_id": "ops"})
+
+		chat = provider.request(t)
+
+		assert.Len(t, chat.Messages, 4)
+		assert.Equal(t, ai.USER, chat.Messages[0].Role)
+		assert.Contains(t, chat.Messages[2].Content, "Certificates are rotated")
+	})
+
+	t.Run("without retrieval", func(t *testing.T) {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "how do I restart the database?"})
+
+		chat := provider.request(t)
+
+		assert.Len(t, chat.Messages, 1)
+	})
+
+	status, _ = request(http.MethodDelete, "/documents/"+document["id"].(string), nil, "")
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, _ = request(http.MethodGet, "/documents/"+document["id"].(string), nil, "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"pkg/ai"
+	"strings"
+	"time"
+	"websocket/internal/model"
+)
+
+// Limit bounds a generation parameter, a nil bound is not enforced. The bounds of stop apply to
+// the number of sequences and the bounds of keep_alive to the number of seconds.
+type Limit struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// Limits bounds the generation parameters by name
+type Limits map[string]Limit
+
+// anyRole holds the limits applying to the roles without limits of their own
+const anyRole = "*"
+
+// parameters are the generation options clients can set, along with the limits applying
+// when no limit is configured for the parameter
+var parameters = Limits{
+	"temperature": {Min: bound(0), Max: bound(2)},
+	"top_p":       {Min: bound(0), Max: bound(1)},
+	"seed":        {},
+	"num_ctx":     {Min: bound(1), Max: bound(8192)},
+	"num_predict": {Min: bound(-1)},
+	"stop":        {Max: bound(4)},
+	"keep_alive":  {Min: bound(0), Max: bound(3600)},
+}
+
+func bound(value float64) *float64 {
+	return &value
+}
+
+// ParseLimits parses the limits of the generation parameters per role from json, such as
+// {"*": {"num_ctx": {"max": 4096}}, "admin": {"num_ctx": {"max": 32768}}}
+func ParseLimits(data string) (map[string]Limits, error) {
+	limits := make(map[string]Limits)
+
+	if strings.TrimSpace(data) == "" {
+		return limits, nil
+	}
+
+	if err := json.Unmarshal([]byte(data), &limits); err != nil {
+		return nil, fmt.Errorf("invalid generation limits: %w", err)
+	}
+
+	for role, bounds := range limits {
+		for name := range bounds {
+			if _, ok := parameters[name]; !ok {
+				return nil, fmt.Errorf("unknown generation parameter %q in the limits of %q", name, role)
+			}
+		}
+	}
+
+	return limits, nil
+}
+
+// limit returns the limit of the parameter for the role
+func (m *Service) limit(role, name string) Limit {
+	if limit, ok := m.limits[role][name]; ok {
+		return limit
+	}
+
+	if limit, ok := m.limits[anyRole][name]; ok {
+		return limit
+	}
+
+	return parameters[name]
+}
+
+// generation validates the generation parameters of the request against the limits of the role
+func (m *Service) generation(role string, request *ai.ChatRequest) error {
+	for name, value := range request.Options {
+		if _, ok := parameters[name]; !ok || name == "keep_alive" {
+			return fmt.Errorf("unsupported option %q", name)
+		}
+
+		var number float64
+
+		if name == "stop" {
+			sequences, ok := stopSequences(value)
+
+			if !ok {
+				return fmt.Errorf("stop must be a list of strings")
+			}
+
+			number = float64(sequences)
+		} else {
+			var ok bool
+
+			if number, ok = numeric(value); !ok {
+				return fmt.Errorf("%s must be a number", name)
+			}
+		}
+
+		if err := m.limit(role, name).check(name, number); err != nil {
+			return err
+		}
+	}
+
+	if request.KeepAlive == "" {
+		return nil
+	}
+
+	seconds, err := keepAlive(request.KeepAlive)
+
+	if err != nil {
+		return err
+	}
+
+	return m.limit(role, "keep_alive").check("keep_alive", seconds)
+}
+
+// check reports the value outside of the bounds
+func (l Limit) check(name string, value float64) error {
+	if l.Min != nil && value < *l.Min {
+		return fmt.Errorf("%s must be at least %v", name, *l.Min)
+	}
+
+	if l.Max != nil && value > *l.Max {
+		return fmt.Errorf("%s must be at most %v", name, *l.Max)
+	}
+
+	return nil
+}
+
+// numeric returns the value of the number decoded from json or set by the service
+func numeric(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case json.Number:
+		number, err := v.Float64()
+		return number, err == nil
+	}
+
+	return 0, false
+}
+
+// stopSequences returns the number of stop sequences
+func stopSequences(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case []string:
+		return len(v), true
+	case []interface{}:
+		for _, sequence := range v {
+			if _, ok := sequence.(string); !ok {
+				return 0, false
+			}
+		}
+
+		return len(v), true
+	}
+
+	return 0, false
+}
+
+// keepAlive returns how many seconds the model is kept loaded, a negative duration keeps the
+// model loaded indefinitely
+func keepAlive(value string) (float64, error) {
+	duration, err := time.ParseDuration(value)
+
+	if err != nil {
+		return 0, fmt.Errorf("keep_alive must be a duration such as 5m")
+	}
+
+	if duration < 0 {
+		return math.Inf(1), nil
+	}
+
+	return duration.Seconds(), nil
+}
+
+// options applies the generation parameters set by the client over the defaults of the request
+func options(request *ai.ChatRequest, options *model.Options) {
+	if options == nil {
+		return
+	}
+
+	request.Options = maps.Clone(request.Options)
+
+	if request.Options == nil {
+		request.Options = make(map[string]interface{})
+	}
+
+	if options.Temperature != nil {
+		request.Options["temperature"] = *options.Temperature
+	}
+
+	if options.TopP != nil {
+		request.Options["top_p"] = *options.TopP
+	}
+
+	if options.Seed != nil {
+		request.Options["seed"] = *options.Seed
+	}
+
+	if options.NumCtx != nil {
+		request.Options["num_ctx"] = *options.NumCtx
+	}
+
+	if options.Stop != nil {
+		request.Options["stop"] = options.Stop
+	}
+
+	if options.KeepAlive != "" {
+		request.KeepAlive = options.KeepAlive
+	}
+}
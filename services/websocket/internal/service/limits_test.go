@@ -0,0 +1,118 @@
+package service
+
+import (
+	"pkg/ai"
+	mock_kafka "pkg/kafka/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits(`{"*": {"num_ctx": {"max": 4096}}, "admin": {"num_ctx": {"min": 1, "max": 32768}}}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, float64(4096), *limits["*"]["num_ctx"].Max)
+	assert.Equal(t, float64(32768), *limits["admin"]["num_ctx"].Max)
+
+	_, err = ParseLimits(`{"*": {"top_k": {"max": 40}}}`)
+	assert.EqualError(t, err, `unknown generation parameter "top_k" in the limits of "*"`)
+
+	_, err = ParseLimits(`[]`)
+	assert.Error(t, err)
+}
+
+func TestGeneration(t *testing.T) {
+	limits, _ := ParseLimits(`{"*": {"num_ctx": {"max": 4096}}, "admin": {"num_ctx": {"max": 32768}, "keep_alive": {}}}`)
+	service := &Service{limits: limits}
+
+	tests := []struct {
+		name      string
+		role      string
+		options   map[string]interface{}
+		keepAlive string
+		err       string
+	}{
+		{name: "within limits", role: "member", options: map[string]interface{}{"temperature": 0.7, "top_p": 0.9, "seed": 42, "stop": []string{"\n"}}},
+		{name: "default limit", role: "member", options: map[string]interface{}{"temperature": 2.5}, err: "temperature must be at most 2"},
+		{name: "limit of every role", role: "member", options: map[string]interface{}{"num_ctx": 8192}, err: "num_ctx must be at most 4096"},
+		{name: "limit of the role", role: "admin", options: map[string]interface{}{"num_ctx": float64(8192)}},
+		{name: "minimum", role: "member", options: map[string]interface{}{"top_p": -0.1}, err: "top_p must be at least 0"},
+		{name: "too many stop sequences", role: "member", options: map[string]interface{}{"stop": []interface{}{"a", "b", "c", "d", "e"}}, err: "stop must be at most 4"},
+		{name: "invalid stop sequences", role: "member", options: map[string]interface{}{"stop": "a"}, err: "stop must be a list of strings"},
+		{name: "not a number", role: "member", options: map[string]interface{}{"seed": "42"}, err: "seed must be a number"},
+		{name: "unsupported option", role: "member", options: map[string]interface{}{"num_gpu": 1}, err: `unsupported option "num_gpu"`},
+		{name: "keep alive", role: "member", keepAlive: "10m"},
+		{name: "keep alive too long", role: "member", keepAlive: "2h", err: "keep_alive must be at most 3600"},
+		{name: "keep alive forever", role: "member", keepAlive: "-1m", err: "keep_alive must be at most 3600"},
+		{name: "keep alive unbounded for the role", role: "admin", keepAlive: "-1m"},
+		{name: "invalid keep alive", role: "member", keepAlive: "soon", err: "keep_alive must be a duration such as 5m"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := service.generation(test.role, &ai.ChatRequest{Options: test.options, KeepAlive: test.keepAlive})
+
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestChatOptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	provider := newRecorder("Hello")
+
+	_, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_kafka.NewMockProducer(ctrl), authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(provider),
+		WithLimits(map[string]Limits{"admin": {"num_ctx": {}}}),
+	)
+
+	conn := dial(t, url, "token")
+
+	conn.WriteJSON(map[string]interface{}{
+		"type":  "chat",
+		"model": "phi",
+		"data":  "hello",
+		"options": map[string]interface{}{
+			"temperature": 0.2,
+			"seed":        7,
+			"stop":        []string{"END"},
+			"keep_alive":  "10m",
+		},
+	})
+
+	chat := provider.request(t)
+
+	assert.Equal(t, map[string]interface{}{"temperature": 0.2, "seed": 7, "stop": []string{"END"}}, chat.Options)
+	assert.Equal(t, "10m", chat.KeepAlive)
+
+	// the limits of the admins do not apply to the other roles
+	conn.WriteJSON(map[string]interface{}{
+		"type":       "chat",
+		"model":      "phi",
+		"data":       "hello",
+		"request_id": "large",
+		"options":    map[string]interface{}{"num_ctx": 65536},
+	})
+
+	for {
+		frame, err := readFrame(t, conn)
+		assert.NoError(t, err)
+
+		if frame["request_id"] == "large" {
+			assert.Equal(t, "num_ctx must be at most 8192", frame["error"])
+			break
+		}
+	}
+}
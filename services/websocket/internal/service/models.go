@@ -0,0 +1,109 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path"
+	"pkg/ai"
+	"slices"
+	"websocket/internal/model"
+)
+
+// errNotManager is returned when a user without a model manager role pulls, deletes or copies a model
+var errNotManager = errors.New("only model managers can pull, delete and copy models")
+
+// manage handles the model management requests of the client. The reply carries the type of the
+// request with the result in data, or the reason in error when the operation failed.
+func (c *Client) manage(manager *Service, message *model.Message) {
+	var (
+		result interface{}
+		err    error
+	)
+
+	switch message.Type {
+	case "models":
+		var models []ai.Model
+
+		models, err = manager.provider.List(manager.ctx)
+		result = manager.allowedOnly(models)
+	case "show":
+		result, err = manager.ai.Show(manager.ctx, message.Model)
+	case "delete":
+		if !manager.manages(c.session.role()) {
+			err = errNotManager
+			break
+		}
+
+		err = manager.ai.Delete(manager.ctx, message.Model)
+		result = message.Model
+	case "copy":
+		if !manager.manages(c.session.role()) {
+			err = errNotManager
+			break
+		}
+
+		// the model is copied to the name given in data
+		err = manager.ai.Copy(manager.ctx, message.Model, message.Data)
+		result = message.Data
+	case "ps":
+		result, err = manager.ai.Running(manager.ctx)
+	}
+
+	payload := map[string]interface{}{
+		"type": message.Type,
+	}
+
+	switch {
+	case errors.Is(err, errNotManager):
+		payload["error"] = err.Error()
+	case err != nil:
+		slog.Error("unable to manage model", "operation", message.Type, "model", message.Model, "error", err)
+
+		payload["error"] = "unable to " + message.Type + " model"
+	default:
+		payload["data"] = result
+	}
+
+	data, err := json.Marshal(payload)
+
+	if err != nil {
+		slog.Error("unable to marshal json response", "error", err)
+		return
+	}
+
+	c.deliver(data)
+}
+
+// manages reports whether the users of the role can pull, delete and copy the local models
+func (m *Service) manages(role string) bool {
+	return slices.Contains(m.managers, role)
+}
+
+// allowed reports whether users can chat with the model
+func (m *Service) allowed(model string) bool {
+	if len(m.allowedModels) == 0 {
+		return true
+	}
+
+	for _, pattern := range m.allowedModels {
+		if matched, _ := path.Match(pattern, model); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowedOnly filters out the models users cannot chat with
+func (m *Service) allowedOnly(models []ai.Model) []ai.Model {
+	allowed := []ai.Model{}
+
+	for _, model := range models {
+		if m.allowed(model.Name) {
+			allowed = append(allowed, model)
+		}
+	}
+
+	return allowed
+}
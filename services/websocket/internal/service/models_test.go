@@ -0,0 +1,235 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"pkg/ai"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ollamaModels simulates the model management api of ollama with the given local models
+func ollamaModels(names ...string) http.Handler {
+	var mu sync.Mutex
+
+	models := make(map[string]bool)
+
+	for _, name := range names {
+		models[name] = true
+	}
+
+	list := func() []map[string]interface{} {
+		var list []map[string]interface{}
+
+		for _, name := range names {
+			if models[name] {
+				list = append(list, map[string]interface{}{"name": name, "model": name})
+			}
+		}
+
+		return list
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		request := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		switch r.URL.Path {
+		case "/api/tags", "/api/ps":
+			json.NewEncoder(w).Encode(map[string]interface{}{"models": list()})
+		case "/api/show":
+			if !models[request["model"]] {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"modelfile": "FROM " + request["model"],
+				"details":   map[string]string{"family": "llama"},
+			})
+		case "/api/delete":
+			if !models[request["model"]] {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			delete(models, request["model"])
+		case "/api/copy":
+			if !models[request["source"]] {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			models[request["destination"]] = true
+			names = append(names, request["destination"])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestManageModels(t *testing.T) {
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+	authServer.grant("token", "admin")
+
+	url := serve(t, authServer, ollamaModels("phi", "llama3.2"), WithVerifyInterval(0))
+	conn := dial(t, url, "token")
+
+	request := func(frame map[string]string) map[string]interface{} {
+		err := conn.WriteJSON(frame)
+		assert.NoError(t, err)
+
+		reply, err := readFrame(t, conn)
+		assert.NoError(t, err)
+		assert.Equal(t, frame["type"], reply["type"])
+
+		return reply
+	}
+
+	names := func(reply map[string]interface{}) []string {
+		var names []string
+
+		models, _ := reply["data"].([]interface{})
+
+		for _, model := range models {
+			names = append(names, model.(map[string]interface{})["name"].(string))
+		}
+
+		return names
+	}
+
+	t.Run("list models", func(t *testing.T) {
+		reply := request(map[string]string{"type": "models"})
+
+		assert.Equal(t, []string{"phi", "llama3.2"}, names(reply))
+	})
+
+	t.Run("list running models", func(t *testing.T) {
+		reply := request(map[string]string{"type": "ps"})
+
+		assert.Equal(t, []string{"phi", "llama3.2"}, names(reply))
+	})
+
+	t.Run("show model", func(t *testing.T) {
+		reply := request(map[string]string{"type": "show", "model": "phi"})
+
+		data := reply["data"].(map[string]interface{})
+
+		assert.Equal(t, "FROM phi", data["modelfile"])
+		assert.Equal(t, "llama", data["details"].(map[string]interface{})["family"])
+	})
+
+	t.Run("show unknown model", func(t *testing.T) {
+		reply := request(map[string]string{"type": "show", "model": "gemma"})
+
+		assert.Equal(t, "unable to show model", reply["error"])
+		assert.Nil(t, reply["data"])
+	})
+
+	t.Run("copy model", func(t *testing.T) {
+		reply := request(map[string]string{"type": "copy", "model": "phi", "data": "phi-copy"})
+
+		assert.Equal(t, "phi-copy", reply["data"])
+		assert.Equal(t, []string{"phi", "llama3.2", "phi-copy"}, names(request(map[string]string{"type": "models"})))
+	})
+
+	t.Run("delete model", func(t *testing.T) {
+		reply := request(map[string]string{"type": "delete", "model": "phi"})
+
+		assert.Equal(t, "phi", reply["data"])
+		assert.Equal(t, []string{"llama3.2", "phi-copy"}, names(request(map[string]string{"type": "models"})))
+	})
+
+	t.Run("delete unknown model", func(t *testing.T) {
+		reply := request(map[string]string{"type": "delete", "model": "phi"})
+
+		assert.Equal(t, "unable to delete model", reply["error"])
+	})
+}
+
+func TestManageModelsDenied(t *testing.T) {
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+	authServer.grant("token", "member")
+
+	var requests atomic.Int32
+
+	ollama := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	url := serve(t, authServer, ollama, WithVerifyInterval(0))
+	conn := dial(t, url, "token")
+
+	for _, frame := range []map[string]string{
+		{"type": "delete", "model": "phi"},
+		{"type": "copy", "model": "phi", "data": "phi-copy"},
+		{"type": "pull", "model": "phi"},
+	} {
+		t.Run(frame["type"], func(t *testing.T) {
+			conn.WriteJSON(frame)
+
+			reply, err := readFrame(t, conn)
+
+			assert.NoError(t, err)
+			assert.Equal(t, frame["type"], reply["type"])
+			assert.Equal(t, "only model managers can pull, delete and copy models", reply["error"])
+		})
+	}
+
+	assert.Zero(t, requests.Load())
+}
+
+func TestProviders(t *testing.T) {
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	local, err := ai.New("http://localhost:1")
+	assert.NoError(t, err)
+
+	router := ai.NewRouter(local)
+	router.Route("fake-*", &ai.Fake{Models: []string{"fake-echo"}})
+
+	url := serve(t, authServer, nil, WithVerifyInterval(0), WithProvider(router))
+	conn := dial(t, url, "token")
+
+	t.Run("chat is served by the provider of the model", func(t *testing.T) {
+		conn.WriteJSON(map[string]string{"type": "chat", "model": "fake-echo", "data": "hello there"})
+
+		var content string
+
+		for {
+			frame, err := readFrame(t, conn)
+
+			assert.NoError(t, err)
+			assert.Nil(t, frame["error"])
+
+			content += frame["data"].(string)
+
+			if frame["done"].(bool) {
+				break
+			}
+		}
+
+		assert.Equal(t, "hello there", content)
+	})
+
+	t.Run("chat with an unavailable provider", func(t *testing.T) {
+		conn.WriteJSON(map[string]string{"type": "chat", "model": "phi", "data": "hello"})
+
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "unable to chat", frame["error"])
+		assert.True(t, frame["done"].(bool))
+	})
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"pkg/auth"
+	"time"
+	"websocket/internal/moderation"
+
+	"github.com/IBM/sarama"
+)
+
+// violation is the record of a prompt or reply rejected by the moderation, published to the audit
+// topic. The offending text is left out so the audit does not spread it any further.
+type violation struct {
+	Type      string    `json:"type"`
+	Direction string    `json:"direction"` // input or output
+	Filter    string    `json:"filter"`
+	Reason    string    `json:"reason"`
+	UserID    string    `json:"user_id"`
+	Org       string    `json:"org,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// audit records the violation of the moderation to the audit topic
+func (m *Service) audit(claims *auth.Claims, requestID, model, direction string, rejected *moderation.Violation) {
+	slog.Warn("moderation violation", "user_id", claims.UserID, "direction", direction, "filter", rejected.Filter, "reason", rejected.Reason)
+
+	if m.topicAudit == "" {
+		return
+	}
+
+	data, err := json.Marshal(violation{
+		Type:      "moderation",
+		Direction: direction,
+		Filter:    rejected.Filter,
+		Reason:    rejected.Reason,
+		UserID:    claims.UserID,
+		Org:       claims.Org,
+		Role:      claims.Role,
+		RequestID: requestID,
+		Model:     model,
+		Time:      time.Now().UTC(),
+	})
+
+	if err != nil {
+		slog.Error("unable to marshal moderation violation", "error", err)
+		return
+	}
+
+	m.producer.Input() <- &sarama.ProducerMessage{
+		Topic: m.topicAudit,
+		Key:   sarama.StringEncoder(claims.UserID),
+		Value: sarama.ByteEncoder(data),
+	}
+}
+
+// guard checks the chunks of a reply with the output filters before they are sent, the end of the
+// text is held back until the next chunks tell whether it is part of a match. Once the reply breaks
+// the policy nothing more of it is sent and the generation is stopped.
+type guard struct {
+	ctx       context.Context
+	stream    *moderation.Stream
+	send      func(map[string]interface{})
+	stop      context.CancelFunc
+	violation *moderation.Violation
+}
+
+// guard returns the guard of the reply sent with send, the generation is stopped by cancelling ctx
+func (m *Service) guard(ctx context.Context, send func(map[string]interface{})) (*guard, context.Context) {
+	ctx, stop := context.WithCancel(ctx)
+
+	return &guard{
+		ctx:    ctx,
+		stream: m.moderation.Stream(),
+		send:   send,
+		stop:   stop,
+	}, ctx
+}
+
+// pass checks the chunk of the reply and returns the text which can be sent, the text held back is
+// returned as well once the reply is done. It reports false when the reply breaks the policy.
+func (g *guard) pass(chunk string, done bool) (string, bool) {
+	if g.violation != nil {
+		return "", false
+	}
+
+	released, rejected := g.stream.Write(g.ctx, chunk)
+
+	if rejected == nil && done {
+		var rest string
+
+		rest, rejected = g.stream.Flush(g.ctx)
+		released += rest
+	}
+
+	if rejected != nil {
+		g.violation = rejected
+		g.stop()
+
+		return "", false
+	}
+
+	return released, true
+}
+
+// forward sends the frame unless the reply has broken the policy, the chunks are checked first.
+// The frames other than the chunks still end the reply once it has broken the policy, so the
+// terminal ones such as the errors are sent regardless.
+func (g *guard) forward(frame map[string]interface{}) {
+	chunk, ok := frame["data"].(string)
+
+	if !ok || frame["type"] != "chat" {
+		// the text held back is sent before the frames following it, such as the tool calls
+		released, ok := g.pass("", true)
+
+		if !ok {
+			if terminal(frame) {
+				g.send(frame)
+			}
+
+			return
+		}
+
+		if released != "" {
+			g.send(map[string]interface{}{
+				"type": "chat",
+				"data": released,
+				"done": false,
+			})
+		}
+
+		g.send(frame)
+
+		return
+	}
+
+	done, _ := frame["done"].(bool)
+	released, ok := g.pass(chunk, done)
+
+	if !ok || (released == "" && !done) {
+		return
+	}
+
+	frame["data"] = released
+	g.send(frame)
+}
+
+// terminal reports whether the frame ends the reply, either with an error or as its last frame
+func terminal(frame map[string]interface{}) bool {
+	done, _ := frame["done"].(bool)
+	_, failed := frame["error"]
+
+	return done || failed
+}
+
+// moderationError describes the violation to the client
+func moderationError(direction string, rejected *moderation.Violation) string {
+	if direction == moderation.Input {
+		return "message blocked by moderation: " + rejected.Reason
+	}
+
+	return "response blocked by moderation: " + rejected.Reason
+}
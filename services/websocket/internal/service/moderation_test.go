@@ -0,0 +1,205 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pkg/ai"
+	"pkg/auth"
+	mock_kafka "pkg/kafka/mocks"
+	"strings"
+	"testing"
+	"time"
+	"websocket/internal/moderation"
+
+	"github.com/IBM/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModeration(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mock_producer := mock_kafka.NewMockProducer(ctrl)
+
+	published := make(chan *sarama.ProducerMessage, 16)
+	mock_producer.EXPECT().Input().Return(published).AnyTimes()
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	pipeline, err := moderation.Parse(`{"input": [{"type": "pii", "kinds": ["email"]}], "output": [{"type": "keywords", "words": ["secret plan"]}]}`)
+	assert.NoError(t, err)
+
+	service, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_producer, authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(&ai.Fake{}),
+		WithModeration(pipeline, "audit"),
+		WithAPIKeys(map[string]*auth.Claims{
+			"sk-tools": {UserID: "tools", Role: "member"},
+		}),
+	)
+
+	conn := dial(t, url, "token")
+
+	// chat sends the message, echoed by the model, and returns the frames of the response
+	chat := func(requestID, data string) []map[string]interface{} {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "model": "phi", "data": data, "request_id": requestID})
+
+		var frames []map[string]interface{}
+
+		for {
+			frame, err := readFrame(t, conn)
+
+			if !assert.NoError(t, err) || frame["request_id"] != requestID {
+				return frames
+			}
+
+			if frames = append(frames, frame); frame["done"] == true {
+				return frames
+			}
+		}
+	}
+
+	// audited returns the next violation published to the audit topic
+	audited := func() map[string]interface{} {
+		for {
+			select {
+			case message := <-published:
+				if message.Topic != "audit" {
+					continue
+				}
+
+				data, _ := message.Value.Encode()
+				record := map[string]interface{}{}
+				json.Unmarshal(data, &record)
+
+				return record
+			case <-time.After(time.Second):
+				t.Fatal("no violation recorded")
+				return nil
+			}
+		}
+	}
+
+	t.Run("input", func(t *testing.T) {
+		frames := chat("1", "write to jane@example.com")
+
+		assert.Len(t, frames, 1)
+		assert.Equal(t, "message blocked by moderation: contains personal information (email)", frames[0]["error"])
+		assert.Equal(t, "pii", frames[0]["filter"])
+
+		record := audited()
+
+		assert.Equal(t, "input", record["direction"])
+		assert.Equal(t, "pii", record["filter"])
+		assert.Equal(t, "1", record["user_id"])
+		assert.Equal(t, "1", record["request_id"])
+	})
+
+	t.Run("output", func(t *testing.T) {
+		frames := chat("2", "the secret plan is ready")
+
+		var reply strings.Builder
+
+		for _, frame := range frames[:len(frames)-1] {
+			reply.WriteString(frame["data"].(string))
+		}
+
+		// the text as long as the keyword is held back, so none of the reply was sent
+		assert.Empty(t, reply.String())
+
+		last := frames[len(frames)-1]
+
+		assert.Equal(t, "response blocked by moderation: contains a blocked keyword", last["error"])
+		assert.Equal(t, "keywords", last["filter"])
+
+		record := audited()
+
+		assert.Equal(t, "output", record["direction"])
+		assert.Equal(t, "phi", record["model"])
+	})
+
+	t.Run("accepted", func(t *testing.T) {
+		frames := chat("3", "hello")
+
+		assert.Empty(t, frames[len(frames)-1]["error"])
+	})
+
+	t.Run("openai", func(t *testing.T) {
+		server := httptest.NewServer(service.OpenAI())
+		defer server.Close()
+
+		complete := func(content string, stream bool) (int, string) {
+			data, _ := json.Marshal(map[string]interface{}{
+				"model":    "phi",
+				"stream":   stream,
+				"messages": []map[string]string{{"role": "user", "content": content}},
+			})
+
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions", bytes.NewReader(data))
+			req.Header.Set("Authorization", "Bearer sk-tools")
+
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			body := map[string]map[string]string{}
+			json.NewDecoder(res.Body).Decode(&body)
+
+			return res.StatusCode, body["error"]["message"]
+		}
+
+		status, message := complete("write to jane@example.com", false)
+
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, "message blocked by moderation: contains personal information (email)", message)
+
+		record := audited()
+
+		assert.Equal(t, "input", record["direction"])
+		assert.Equal(t, "tools", record["user_id"])
+		assert.True(t, strings.HasPrefix(record["request_id"].(string), "chatcmpl-"))
+
+		for _, stream := range []bool{false, true} {
+			status, message = complete("the secret plan is ready", stream)
+
+			assert.Equal(t, http.StatusBadRequest, status)
+			assert.Equal(t, "response blocked by moderation: contains a blocked keyword", message)
+			assert.Equal(t, "output", audited()["direction"])
+		}
+
+		status, _ = complete("hello", true)
+		assert.Equal(t, http.StatusOK, status)
+	})
+}
+
+func TestGuard(t *testing.T) {
+	pipeline, err := moderation.Parse(`{"output": [{"type": "keywords", "words": ["secret plan"]}]}`)
+	assert.NoError(t, err)
+
+	service := &Service{moderation: pipeline}
+
+	var sent []map[string]interface{}
+
+	guard, _ := service.guard(context.Background(), func(frame map[string]interface{}) {
+		sent = append(sent, frame)
+	})
+	defer guard.stop()
+
+	guard.forward(map[string]interface{}{"type": "chat", "data": "the secret plan is ready", "done": false})
+
+	assert.NotNil(t, guard.violation)
+
+	// nothing more of the reply is sent, except for the frames ending it
+	guard.forward(map[string]interface{}{"type": "tool_call", "id": "call_1"})
+	guard.forward(map[string]interface{}{"type": "tool_result", "id": "call_1", "error": "unknown tool"})
+	guard.forward(map[string]interface{}{"type": "result", "valid": false, "done": true})
+
+	assert.Equal(t, []map[string]interface{}{
+		{"type": "tool_result", "id": "call_1", "error": "unknown tool"},
+		{"type": "result", "valid": false, "done": true},
+	}, sent)
+}
@@ -0,0 +1,613 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pkg/ai"
+	"pkg/auth"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"websocket/internal/moderation"
+	"websocket/internal/store"
+)
+
+// OpenAI returns the handler of the openai compatible api, serving /v1/chat/completions,
+// /v1/models and /v1/embeddings to the tools speaking the openai api
+func (m *Service) OpenAI() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v1/chat/completions", m.authorized(openAIError, m.chatCompletions))
+	mux.HandleFunc("GET /v1/models", m.authorized(openAIError, m.listModels))
+	mux.HandleFunc("POST /v1/embeddings", m.authorized(openAIError, m.embeddings))
+
+	return mux
+}
+
+// authenticate resolves the identity of the request from the bearer token, which is either
+// an api key or a token issued by the auth service
+func (m *Service) authenticate(r *http.Request) (*auth.Claims, error) {
+	token := credentials(r)
+
+	if claims, ok := m.apiKeys[token]; ok && token != "" {
+		return claims, nil
+	}
+
+	return m.identify(token)
+}
+
+// authorized rejects the requests without a valid identity, and new requests while shutting down,
+// responding with the errors in the format written by fail
+func (m *Service) authorized(fail func(http.ResponseWriter, int, string), handler func(http.ResponseWriter, *http.Request, *auth.Claims)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.draining.Load() {
+			fail(w, http.StatusServiceUnavailable, "service is shutting down, please retry")
+			return
+		}
+
+		claims, err := m.authenticate(r)
+
+		if err != nil {
+			slog.Error("unable to authenticate api request", "error", err)
+
+			fail(w, http.StatusUnauthorized, errUnauthorized.Error())
+
+			return
+		}
+
+		m.inflight.Add(1)
+		defer m.inflight.Done()
+
+		handler(w, r, claims)
+	}
+}
+
+// openAIError responds with an error in the format of the openai api
+func openAIError(w http.ResponseWriter, status int, message string) {
+	kind := "invalid_request_error"
+
+	switch {
+	case status == http.StatusUnauthorized:
+		kind = "authentication_error"
+	case status == http.StatusNotFound:
+		kind = "not_found_error"
+	case status >= http.StatusInternalServerError:
+		kind = "server_error"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    kind,
+		},
+	})
+}
+
+// completionRequest is a chat completion request of the openai api
+type completionRequest struct {
+	Model         string              `json:"model"`
+	Messages      []completionMessage `json:"messages"`
+	Stream        bool                `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Temperature    *float64        `json:"temperature"`
+	TopP           *float64        `json:"top_p"`
+	MaxTokens      *int            `json:"max_tokens"`
+	Seed           *int            `json:"seed"`
+	Stop           json.RawMessage `json:"stop"`
+	ResponseFormat *struct {
+		Type       string `json:"type"`
+		JSONSchema *struct {
+			Schema json.RawMessage `json:"schema"`
+		} `json:"json_schema"`
+	} `json:"response_format"`
+}
+
+// completionMessage is a message of the openai api, whose content is either a string or a list of parts
+type completionMessage struct {
+	ai.Message
+	Content content `json:"content"`
+}
+
+// content is the text of a message, given as a string or as a list of parts such as
+// [{"type": "text", "text": "hi"}] of which the text parts are kept
+type content string
+
+func (c *content) UnmarshalJSON(data []byte) error {
+	var text string
+
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = content(text)
+		return nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return errors.New("content must be a string or a list of parts")
+	}
+
+	var texts []string
+
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+
+	*c = content(strings.Join(texts, "\n"))
+
+	return nil
+}
+
+// chatRequest translates the completion request to a chat request of the providers
+func (c *completionRequest) chatRequest() *ai.ChatRequest {
+	request := &ai.ChatRequest{
+		Model:   c.Model,
+		Options: make(map[string]interface{}),
+	}
+
+	for _, message := range c.Messages {
+		message.Message.Content = string(message.Content)
+		request.Messages = append(request.Messages, message.Message)
+	}
+
+	if c.Temperature != nil {
+		request.Options["temperature"] = *c.Temperature
+	}
+
+	if c.TopP != nil {
+		request.Options["top_p"] = *c.TopP
+	}
+
+	if c.MaxTokens != nil {
+		request.Options["num_predict"] = *c.MaxTokens
+	}
+
+	if c.Seed != nil {
+		request.Options["seed"] = *c.Seed
+	}
+
+	if len(c.Stop) > 0 {
+		var stop []string
+
+		// stop is either a single sequence or a list of them
+		if json.Unmarshal(c.Stop, &stop) != nil {
+			var sequence string
+
+			if json.Unmarshal(c.Stop, &sequence) == nil {
+				stop = []string{sequence}
+			}
+		}
+
+		request.Options["stop"] = stop
+	}
+
+	if c.ResponseFormat != nil {
+		switch {
+		case c.ResponseFormat.Type == "json_object":
+			request.Format = json.RawMessage(`"json"`)
+		case c.ResponseFormat.Type == "json_schema" && c.ResponseFormat.JSONSchema != nil:
+			request.Format = c.ResponseFormat.JSONSchema.Schema
+		}
+	}
+
+	return request
+}
+
+// lastUserMessage returns the content of the latest message of the user
+func (c *completionRequest) lastUserMessage() string {
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		if c.Messages[i].Role == ai.USER {
+			return string(c.Messages[i].Content)
+		}
+	}
+
+	return ""
+}
+
+// usage is the token usage of a completion
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func newUsage(cr *ai.ChatResponse) *usage {
+	return &usage{
+		PromptTokens:     cr.PromptEvalCount,
+		CompletionTokens: cr.EvalCount,
+		TotalTokens:      cr.PromptEvalCount + cr.EvalCount,
+	}
+}
+
+func (m *Service) chatCompletions(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	if m.throttled(w, claims, openAIError) {
+		return
+	}
+
+	request := &completionRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		openAIError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if request.Model == "" || len(request.Messages) == 0 {
+		openAIError(w, http.StatusBadRequest, "model and messages are required")
+		return
+	}
+
+	if !m.allowed(request.Model) {
+		openAIError(w, http.StatusNotFound, fmt.Sprintf("model %q is not allowed", request.Model))
+		return
+	}
+
+	chat := request.chatRequest()
+
+	if err := m.generation(claims.Role, chat); err != nil {
+		openAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := m.quota(r.Context(), claims.UserID, claims.Role); err != nil {
+		openAIError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+
+	id := "chatcmpl-" + newID()
+	created := time.Now().Unix()
+
+	// the whole history comes with every request, so every prompt of the user is checked
+	for _, message := range chat.Messages {
+		if message.Role != ai.USER {
+			continue
+		}
+
+		if rejected := m.moderation.CheckInput(r.Context(), message.Content); rejected != nil {
+			m.audit(claims, id, request.Model, moderation.Input, rejected)
+
+			openAIError(w, http.StatusBadRequest, moderationError(moderation.Input, rejected))
+
+			return
+		}
+	}
+
+	slog.Info("received completion request", "user", claims.UserID, "model", request.Model)
+
+	spent := metered(claims, request.Model)
+	defer m.record(spent)
+
+	// keep the history of the chats along with the websocket ones
+	m.publish(request.lastUserMessage())
+
+	// the reply is checked by the output filters as it is generated
+	guard, ctx := m.guard(r.Context(), nil)
+	defer guard.stop()
+
+	if request.Stream {
+		m.streamCompletion(ctx, w, claims, request, chat, spent, guard, id, created)
+		return
+	}
+
+	var content strings.Builder
+	var last *ai.ChatResponse
+
+	err := m.provider.Chat(ctx, chat, func(cr *ai.ChatResponse) {
+		meter(spent, cr)
+
+		if released, ok := guard.pass(cr.Message.Content, cr.Done); ok {
+			content.WriteString(released)
+		}
+
+		last = cr
+	})
+
+	if guard.violation != nil {
+		m.audit(claims, id, request.Model, moderation.Output, guard.violation)
+
+		openAIError(w, http.StatusBadRequest, moderationError(moderation.Output, guard.violation))
+
+		return
+	}
+
+	if err != nil {
+		slog.Error("unable to complete chat", "model", request.Model, "error", err)
+
+		openAIError(w, completionStatus(err), chatError(err))
+
+		return
+	}
+
+	finishReason := "stop"
+
+	if last != nil && last.DoneReason != "" {
+		finishReason = last.DoneReason
+	}
+
+	response := map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   request.Model,
+		"choices": []map[string]interface{}{
+			{
+				"index": 0,
+				"message": ai.Message{
+					Role:    ai.ASSISTANT,
+					Content: content.String(),
+				},
+				"finish_reason": finishReason,
+			},
+		},
+	}
+
+	if last != nil {
+		response["usage"] = newUsage(last)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// streamCompletion streams the completion as server-sent events, the chunks passed by the guard
+func (m *Service) streamCompletion(ctx context.Context, w http.ResponseWriter, claims *auth.Claims, request *completionRequest, chat *ai.ChatRequest, spent *store.Usage, guard *guard, id string, created int64) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		openAIError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// event writes a chunk of the completion, the headers are only sent along with the first one
+	// so an error before the generation starts can still be reported with a status
+	started := false
+
+	event := func(choice map[string]interface{}, usage *usage) {
+		chunk := map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   request.Model,
+			"choices": []map[string]interface{}{},
+		}
+
+		if choice != nil {
+			choice["index"] = 0
+			chunk["choices"] = []map[string]interface{}{choice}
+		}
+
+		if usage != nil {
+			chunk["usage"] = usage
+		}
+
+		data, err := json.Marshal(chunk)
+
+		if err != nil {
+			slog.Error("unable to marshal json response", "error", err)
+			return
+		}
+
+		started = true
+
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	err := m.provider.Chat(ctx, chat, func(cr *ai.ChatResponse) {
+		meter(spent, cr)
+
+		content, ok := guard.pass(cr.Message.Content, cr.Done)
+
+		// the role is sent with the first text passing the guard, so a reply rejected before
+		// any of it is released is still reported with a status
+		if !ok || (content == "" && !cr.Done) {
+			return
+		}
+
+		if !started {
+			event(map[string]interface{}{
+				"delta":         map[string]string{"role": ai.ASSISTANT},
+				"finish_reason": nil,
+			}, nil)
+		}
+
+		if content != "" {
+			event(map[string]interface{}{
+				"delta":         map[string]string{"content": content},
+				"finish_reason": nil,
+			}, nil)
+		}
+
+		if !cr.Done {
+			return
+		}
+
+		finishReason := cr.DoneReason
+
+		if finishReason == "" {
+			finishReason = "stop"
+		}
+
+		event(map[string]interface{}{
+			"delta":         map[string]string{},
+			"finish_reason": finishReason,
+		}, nil)
+
+		if request.StreamOptions != nil && request.StreamOptions.IncludeUsage {
+			event(nil, newUsage(cr))
+		}
+	})
+
+	var status int
+	var message, kind string
+
+	switch {
+	case guard.violation != nil:
+		m.audit(claims, id, request.Model, moderation.Output, guard.violation)
+
+		status, message, kind = http.StatusBadRequest, moderationError(moderation.Output, guard.violation), "invalid_request_error"
+	case err != nil:
+		slog.Error("unable to complete chat", "model", request.Model, "error", err)
+
+		status, message, kind = completionStatus(err), chatError(err), "server_error"
+	}
+
+	if message != "" {
+		if !started {
+			openAIError(w, status, message)
+			return
+		}
+
+		data, _ := json.Marshal(map[string]interface{}{
+			"error": map[string]string{
+				"message": message,
+				"type":    kind,
+			},
+		})
+
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// completionStatus is the status of the response when the chat failed
+func completionStatus(err error) int {
+	var apiErr *ai.APIError
+
+	if errors.As(err, &apiErr) && apiErr.NotFound() {
+		return http.StatusNotFound
+	}
+
+	return http.StatusBadGateway
+}
+
+func (m *Service) listModels(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	models, err := m.provider.List(r.Context())
+
+	if err != nil {
+		slog.Error("unable to list models", "error", err)
+
+		openAIError(w, http.StatusBadGateway, "unable to list models")
+
+		return
+	}
+
+	data := []map[string]interface{}{}
+
+	for _, model := range m.allowedOnly(models) {
+		data = append(data, map[string]interface{}{
+			"id":       model.Name,
+			"object":   "model",
+			"created":  model.ModifiedAt.Unix(),
+			"owned_by": "chatty-chat",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+func (m *Service) embeddings(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	if m.throttled(w, claims, openAIError) {
+		return
+	}
+
+	request := struct {
+		Model string          `json:"model"`
+		Input json.RawMessage `json:"input"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		openAIError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	// the input is either a single text or a list of them
+	var input []string
+
+	if json.Unmarshal(request.Input, &input) != nil {
+		var text string
+
+		if err := json.Unmarshal(request.Input, &text); err != nil {
+			openAIError(w, http.StatusBadRequest, "input must be a string or an array of strings")
+			return
+		}
+
+		input = []string{text}
+	}
+
+	if request.Model == "" || len(input) == 0 {
+		openAIError(w, http.StatusBadRequest, "model and input are required")
+		return
+	}
+
+	if !m.allowed(request.Model) {
+		openAIError(w, http.StatusNotFound, fmt.Sprintf("model %q is not allowed", request.Model))
+		return
+	}
+
+	if err := m.quota(r.Context(), claims.UserID, claims.Role); err != nil {
+		openAIError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+
+	spent := metered(claims, request.Model)
+	defer m.record(spent)
+
+	// the providers do not report the tokens of the embeddings, about four characters per token
+	for _, text := range input {
+		spent.PromptTokens += int64((utf8.RuneCountInString(text) + 3) / 4)
+	}
+
+	embeddings, err := m.provider.Embed(r.Context(), request.Model, input)
+
+	if err != nil {
+		slog.Error("unable to compute embeddings", "model", request.Model, "error", err)
+
+		openAIError(w, completionStatus(err), "unable to compute embeddings")
+
+		return
+	}
+
+	data := make([]map[string]interface{}, 0, len(embeddings))
+
+	for i, embedding := range embeddings {
+		data = append(data, map[string]interface{}{
+			"object":    "embedding",
+			"index":     i,
+			"embedding": embedding,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  request.Model,
+		"usage": &usage{
+			PromptTokens: int(spent.PromptTokens),
+			TotalTokens:  int(spent.PromptTokens),
+		},
+	})
+}
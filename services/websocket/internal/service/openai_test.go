@@ -0,0 +1,240 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pkg/ai"
+	"pkg/auth"
+	mock_kafka "pkg/kafka/mocks"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAI(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mock_producer := mock_kafka.NewMockProducer(ctrl)
+	mock_consumer := mock_kafka.NewMockConsumer(ctrl)
+
+	published := make(chan *sarama.ProducerMessage, 16)
+	mock_producer.EXPECT().Input().Return(published).AnyTimes()
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	provider := &ai.Fake{Models: []string{"phi", "gpt-4o"}, Reply: "Hello there friend"}
+
+	service, _ := serveWith(t, mock_consumer, mock_producer, authServer, nil,
+		WithProvider(provider),
+		WithAllowedModels("phi"),
+		WithAPIKeys(map[string]*auth.Claims{
+			"sk-tools": {UserID: "tools", Role: "member"},
+		}),
+	)
+
+	server := httptest.NewServer(service.OpenAI())
+	t.Cleanup(server.Close)
+
+	request := func(method, path, token string, body interface{}) *http.Response {
+		data, _ := json.Marshal(body)
+
+		req, _ := http.NewRequest(method, server.URL+path, bytes.NewReader(data))
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+
+		t.Cleanup(func() {
+			res.Body.Close()
+		})
+
+		return res
+	}
+
+	decode := func(res *http.Response) map[string]interface{} {
+		body := map[string]interface{}{}
+		json.NewDecoder(res.Body).Decode(&body)
+
+		return body
+	}
+
+	chat := map[string]interface{}{
+		"model": "phi",
+		"messages": []map[string]string{
+			{"role": "system", "content": "be nice"},
+			{"role": "user", "content": "hi"},
+		},
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, token := range []string{"", "unknown"} {
+			res := request(http.MethodPost, "/v1/chat/completions", token, chat)
+
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+			assert.Equal(t, "authentication_error", decode(res)["error"].(map[string]interface{})["type"])
+		}
+	})
+
+	t.Run("completion with an api key", func(t *testing.T) {
+		res := request(http.MethodPost, "/v1/chat/completions", "sk-tools", chat)
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		body := decode(res)
+		choice := body["choices"].([]interface{})[0].(map[string]interface{})
+
+		assert.Equal(t, "chat.completion", body["object"])
+		assert.Equal(t, "Hello there friend", choice["message"].(map[string]interface{})["content"])
+		assert.Equal(t, "stop", choice["finish_reason"])
+		assert.Equal(t, float64(3), body["usage"].(map[string]interface{})["completion_tokens"])
+
+		// the message of the user is kept in the history like websocket chats
+		select {
+		case message := <-published:
+			value, _ := message.Value.Encode()
+			assert.Equal(t, "hi", string(value))
+		case <-time.After(time.Second):
+			t.Error("expected the message to be published")
+		}
+	})
+
+	t.Run("streamed completion with a token", func(t *testing.T) {
+		stream := map[string]interface{}{
+			"model":          "phi",
+			"messages":       chat["messages"],
+			"stream":         true,
+			"stream_options": map[string]bool{"include_usage": true},
+		}
+
+		res := request(http.MethodPost, "/v1/chat/completions", "token", stream)
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+		var content strings.Builder
+		var finishReason interface{}
+		var usage map[string]interface{}
+		var done bool
+
+		scanner := bufio.NewScanner(res.Body)
+
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+
+			if !ok {
+				continue
+			}
+
+			if data == "[DONE]" {
+				done = true
+				break
+			}
+
+			chunk := map[string]interface{}{}
+			assert.NoError(t, json.Unmarshal([]byte(data), &chunk))
+			assert.Equal(t, "chat.completion.chunk", chunk["object"])
+
+			if chunk["usage"] != nil {
+				usage = chunk["usage"].(map[string]interface{})
+			}
+
+			for _, choice := range chunk["choices"].([]interface{}) {
+				choice := choice.(map[string]interface{})
+				delta := choice["delta"].(map[string]interface{})
+
+				if text, ok := delta["content"].(string); ok {
+					content.WriteString(text)
+				}
+
+				if choice["finish_reason"] != nil {
+					finishReason = choice["finish_reason"]
+				}
+			}
+		}
+
+		assert.True(t, done)
+		assert.Equal(t, "Hello there friend", content.String())
+		assert.Equal(t, "stop", finishReason)
+		assert.Equal(t, float64(3), usage["completion_tokens"])
+
+		<-published
+	})
+
+	t.Run("content parts", func(t *testing.T) {
+		res := request(http.MethodPost, "/v1/chat/completions", "sk-tools", map[string]interface{}{
+			"model": "phi",
+			"messages": []map[string]interface{}{
+				{"role": "user", "content": []map[string]interface{}{
+					{"type": "text", "text": "what is"},
+					{"type": "image_url", "image_url": map[string]string{"url": "https://example.com/cat.png"}},
+					{"type": "text", "text": "in the picture?"},
+				}},
+			},
+		})
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		// the text parts make the content of the message
+		select {
+		case message := <-published:
+			value, _ := message.Value.Encode()
+			assert.Equal(t, "what is\nin the picture?", string(value))
+		case <-time.After(time.Second):
+			t.Error("expected the message to be published")
+		}
+
+		res = request(http.MethodPost, "/v1/chat/completions", "sk-tools", map[string]interface{}{
+			"model":    "phi",
+			"messages": []map[string]interface{}{{"role": "user", "content": 42}},
+		})
+
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("model not allowed", func(t *testing.T) {
+		disallowed := map[string]interface{}{
+			"model":    "gpt-4o",
+			"messages": chat["messages"],
+		}
+
+		res := request(http.MethodPost, "/v1/chat/completions", "sk-tools", disallowed)
+
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+
+	t.Run("models", func(t *testing.T) {
+		res := request(http.MethodGet, "/v1/models", "sk-tools", nil)
+
+		body := decode(res)
+		models := body["data"].([]interface{})
+
+		assert.Equal(t, "list", body["object"])
+		assert.Len(t, models, 1)
+		assert.Equal(t, "phi", models[0].(map[string]interface{})["id"])
+	})
+
+	t.Run("embeddings", func(t *testing.T) {
+		res := request(http.MethodPost, "/v1/embeddings", "sk-tools", map[string]interface{}{
+			"model": "phi",
+			"input": "the quick brown fox",
+		})
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		data := decode(res)["data"].([]interface{})
+
+		assert.Len(t, data, 1)
+		assert.Len(t, data[0].(map[string]interface{})["embedding"], ai.FakeDimensions)
+	})
+}
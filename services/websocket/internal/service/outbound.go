@@ -0,0 +1,319 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Policy decides what happens to an outbound frame when the queue of a slow client is full
+type Policy string
+
+const (
+	PolicyBlock      Policy = "block"       // wait for room up to a timeout, then disconnect the client
+	PolicyDropOldest Policy = "drop_oldest" // discard the oldest queued frame to make room
+	PolicyCoalesce   Policy = "coalesce"    // merge queued stream chunks of the same request, disconnect when nothing can be merged
+	PolicyDisconnect Policy = "disconnect"  // disconnect the client right away
+)
+
+// ParsePolicy validates the name of an outbound policy
+func ParsePolicy(name string) (Policy, error) {
+	switch policy := Policy(name); policy {
+	case PolicyBlock, PolicyDropOldest, PolicyCoalesce, PolicyDisconnect:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown outbound policy %q", name)
+}
+
+var (
+	errQueueClosed  = errors.New("outbound queue closed")
+	errQueueFull    = errors.New("outbound queue full")
+	errSlowConsumer = errors.New("client is not keeping up with outbound frames")
+)
+
+// metrics of the outbound queues, exposed on /debug/vars
+var (
+	droppedFrames   = expvar.NewInt("outbound_dropped_frames")
+	coalescedFrames = expvar.NewInt("outbound_coalesced_frames")
+	slowDisconnects = expvar.NewInt("outbound_slow_consumer_disconnects")
+)
+
+// outbound is the queue of frames waiting to be written to a connection
+type outbound struct {
+	policy   Policy
+	capacity int
+	timeout  time.Duration // how long the block policy waits for room
+
+	mu     sync.Mutex
+	frames [][]byte
+	closed bool
+	pushed chan struct{} // closed and replaced whenever a frame is queued or the queue is closed
+	popped chan struct{} // closed and replaced whenever a frame is dequeued or the queue is closed
+}
+
+func newOutbound(policy Policy, capacity int, timeout time.Duration) *outbound {
+	return &outbound{
+		policy:   policy,
+		capacity: capacity,
+		timeout:  timeout,
+		pushed:   make(chan struct{}),
+		popped:   make(chan struct{}),
+	}
+}
+
+// push queues the frame, applying the policy when the queue is full. It returns
+// errSlowConsumer when the client should be disconnected and errQueueClosed once closed.
+func (q *outbound) push(data []byte) error {
+	return q.queue(data, true)
+}
+
+// offer queues the frame like push without ever waiting for room, the block policy drops the frame
+// when the queue is full instead. Frames fanned out to many clients are offered, so a client which
+// is not keeping up cannot hold up the others.
+func (q *outbound) offer(data []byte) error {
+	return q.queue(data, false)
+}
+
+// queue the frame, waiting for room under the block policy when wait is set
+func (q *outbound) queue(data []byte, wait bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	if len(q.frames) >= q.capacity {
+		switch q.policy {
+		case PolicyBlock:
+			if !wait {
+				droppedFrames.Add(1)
+
+				return errQueueFull
+			}
+
+			deadline := time.NewTimer(q.timeout)
+			defer deadline.Stop()
+
+			for len(q.frames) >= q.capacity && !q.closed {
+				popped := q.popped
+				q.mu.Unlock()
+
+				select {
+				case <-popped:
+				case <-deadline.C:
+					q.mu.Lock()
+
+					return errSlowConsumer
+				}
+
+				q.mu.Lock()
+			}
+
+			if q.closed {
+				return errQueueClosed
+			}
+		case PolicyDropOldest:
+			q.frames = q.frames[1:]
+			droppedFrames.Add(1)
+		case PolicyCoalesce:
+			if q.coalesce(data) {
+				return nil
+			}
+
+			if len(q.frames) >= q.capacity {
+				return errSlowConsumer
+			}
+		default:
+			return errSlowConsumer
+		}
+	}
+
+	q.frames = append(q.frames, data)
+	q.signal(&q.pushed)
+
+	return nil
+}
+
+// pop returns the next frame, waiting for one until the timeout channel fires.
+// It returns false once the queue is closed and drained, or when the wait times out.
+func (q *outbound) pop(timeout <-chan time.Time) ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.frames) == 0 {
+		if q.closed {
+			return nil, false
+		}
+
+		pushed := q.pushed
+		q.mu.Unlock()
+
+		select {
+		case <-pushed:
+		case <-timeout:
+			q.mu.Lock()
+
+			return nil, false
+		}
+
+		q.mu.Lock()
+	}
+
+	data := q.frames[0]
+	q.frames = q.frames[1:]
+	q.signal(&q.popped)
+
+	return data, true
+}
+
+// close the queue, the frames already queued can still be popped
+func (q *outbound) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	q.closed = true
+	q.signal(&q.pushed)
+	q.signal(&q.popped)
+}
+
+// len returns the number of queued frames
+func (q *outbound) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.frames)
+}
+
+// drained reports whether the queue is closed and every frame has been popped
+func (q *outbound) drained() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.closed && len(q.frames) == 0
+}
+
+// signal wakes up the goroutines waiting on the channel. The caller must hold the lock.
+func (q *outbound) signal(ch *chan struct{}) {
+	close(*ch)
+	*ch = make(chan struct{})
+}
+
+// chunk holds the fields telling whether a frame is a streamed chat chunk which can be merged
+type chunk struct {
+	Type      string `json:"type"`
+	Data      string `json:"data"`
+	RequestID string `json:"request_id"`
+	Error     string `json:"error,omitempty"`
+}
+
+// mergeable decodes the frame when it is a streamed chat chunk
+func mergeable(data []byte) (*chunk, bool) {
+	c := &chunk{}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, false
+	}
+
+	return c, c.Type == "chat" && c.RequestID != "" && c.Error == ""
+}
+
+// coalesce makes room by merging stream chunks. The frame is merged into the last queued chunk of
+// the same request when possible, otherwise the queued chunks of every request are merged together.
+// It returns true when the frame itself has been merged. The caller must hold the lock.
+func (q *outbound) coalesce(data []byte) bool {
+	incoming, ok := mergeable(data)
+
+	if ok {
+		for i := len(q.frames) - 1; i >= 0; i-- {
+			queued, ok := mergeable(q.frames[i])
+
+			if queued == nil || queued.RequestID != incoming.RequestID {
+				continue
+			}
+
+			// other frames of the request, such as tool calls, keep their place between the chunks
+			if !ok {
+				break
+			}
+
+			if merged, err := merge(q.frames[i], data); err == nil {
+				q.frames[i] = merged
+				coalescedFrames.Add(1)
+
+				return true
+			}
+
+			break
+		}
+	}
+
+	// merge the queued chunks of each request into the first queued chunk of the request
+	first := make(map[string]int)
+	frames := q.frames[:0]
+
+	for _, frame := range q.frames {
+		queued, ok := mergeable(frame)
+
+		if !ok {
+			if queued != nil {
+				delete(first, queued.RequestID)
+			}
+
+			frames = append(frames, frame)
+
+			continue
+		}
+
+		i, seen := first[queued.RequestID]
+
+		if !seen {
+			first[queued.RequestID] = len(frames)
+			frames = append(frames, frame)
+			continue
+		}
+
+		merged, err := merge(frames[i], frame)
+
+		if err != nil {
+			frames = append(frames, frame)
+			continue
+		}
+
+		frames[i] = merged
+		coalescedFrames.Add(1)
+	}
+
+	q.frames = frames
+
+	return false
+}
+
+// merge appends the content of the later chunk to the earlier one, the merged chunk is the later
+// one otherwise so it keeps the latest sequence number and every other field of the frame
+func merge(earlier, later []byte) ([]byte, error) {
+	var previous, merged map[string]interface{}
+
+	if err := json.Unmarshal(earlier, &previous); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(later, &merged); err != nil {
+		return nil, err
+	}
+
+	data, _ := previous["data"].(string)
+	more, _ := merged["data"].(string)
+
+	merged["data"] = data + more
+
+	return json.Marshal(merged)
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chatChunk encodes a streamed chat frame of the request
+func chatChunk(requestID string, seq int64, data string) []byte {
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":       "chat",
+		"data":       data,
+		"done":       false,
+		"request_id": requestID,
+		"seq":        seq,
+	})
+
+	return frame
+}
+
+// drain pops every queued frame
+func drain(q *outbound) []string {
+	var frames []string
+
+	for {
+		data, ok := q.pop(time.After(10 * time.Millisecond))
+
+		if !ok {
+			return frames
+		}
+
+		frames = append(frames, string(data))
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("coalesce")
+
+	assert.NoError(t, err)
+	assert.Equal(t, PolicyCoalesce, policy)
+
+	_, err = ParsePolicy("ignore")
+
+	assert.Error(t, err)
+}
+
+func TestOutbound(t *testing.T) {
+	t.Run("block until room", func(t *testing.T) {
+		q := newOutbound(PolicyBlock, 1, time.Second)
+
+		assert.NoError(t, q.push([]byte("first")))
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			q.pop(nil)
+		}()
+
+		assert.NoError(t, q.push([]byte("second")))
+		assert.Equal(t, []string{"second"}, drain(q))
+	})
+
+	t.Run("block with timeout", func(t *testing.T) {
+		q := newOutbound(PolicyBlock, 1, 50*time.Millisecond)
+
+		assert.NoError(t, q.push([]byte("first")))
+		assert.ErrorIs(t, q.push([]byte("second")), errSlowConsumer)
+	})
+
+	t.Run("block until closed", func(t *testing.T) {
+		q := newOutbound(PolicyBlock, 1, time.Second)
+
+		assert.NoError(t, q.push([]byte("first")))
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			q.close()
+		}()
+
+		assert.ErrorIs(t, q.push([]byte("second")), errQueueClosed)
+	})
+
+	t.Run("offer without waiting for room", func(t *testing.T) {
+		dropped := droppedFrames.Value()
+		q := newOutbound(PolicyBlock, 1, time.Second)
+
+		assert.NoError(t, q.offer([]byte("first")))
+		assert.ErrorIs(t, q.offer([]byte("second")), errQueueFull)
+		assert.Equal(t, []string{"first"}, drain(q))
+		assert.Equal(t, dropped+1, droppedFrames.Value())
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		dropped := droppedFrames.Value()
+		q := newOutbound(PolicyDropOldest, 2, time.Second)
+
+		for _, frame := range []string{"first", "second", "third"} {
+			assert.NoError(t, q.push([]byte(frame)))
+		}
+
+		assert.Equal(t, []string{"second", "third"}, drain(q))
+		assert.Equal(t, dropped+1, droppedFrames.Value())
+	})
+
+	t.Run("coalesce into the last chunk of the request", func(t *testing.T) {
+		q := newOutbound(PolicyCoalesce, 2, time.Second)
+
+		assert.NoError(t, q.push(chatChunk("request", 1, "Hello")))
+		assert.NoError(t, q.push([]byte(`{"type":"notification","data":"news"}`)))
+		assert.NoError(t, q.push(chatChunk("request", 2, ", world")))
+
+		frames := drain(q)
+
+		assert.Len(t, frames, 2)
+		assert.JSONEq(t, string(chatChunk("request", 2, "Hello, world")), frames[0])
+	})
+
+	t.Run("coalesce queued chunks to make room", func(t *testing.T) {
+		q := newOutbound(PolicyCoalesce, 2, time.Second)
+
+		assert.NoError(t, q.push(chatChunk("request", 1, "Hello")))
+		assert.NoError(t, q.push(chatChunk("request", 2, ", world")))
+		assert.NoError(t, q.push([]byte(`{"type":"notification","data":"news"}`)))
+
+		frames := drain(q)
+
+		assert.Len(t, frames, 2)
+		assert.JSONEq(t, string(chatChunk("request", 2, "Hello, world")), frames[0])
+		assert.JSONEq(t, `{"type":"notification","data":"news"}`, frames[1])
+	})
+
+	t.Run("coalesce around other frames of the request", func(t *testing.T) {
+		q := newOutbound(PolicyCoalesce, 3, time.Second)
+		call := `{"type":"tool_call","name":"current_time","request_id":"request","seq":2}`
+
+		assert.NoError(t, q.push(chatChunk("request", 1, "Let me check")))
+		assert.NoError(t, q.push([]byte(call)))
+		assert.NoError(t, q.push(chatChunk("request", 3, "It is")))
+		assert.NoError(t, q.push(chatChunk("request", 4, " noon")))
+
+		frames := drain(q)
+
+		assert.Len(t, frames, 3)
+		assert.JSONEq(t, string(chatChunk("request", 1, "Let me check")), frames[0])
+		assert.JSONEq(t, call, frames[1])
+		assert.JSONEq(t, string(chatChunk("request", 4, "It is noon")), frames[2])
+	})
+
+	t.Run("coalesce keeps the fields of the later chunk", func(t *testing.T) {
+		q := newOutbound(PolicyCoalesce, 2, time.Second)
+
+		assert.NoError(t, q.push([]byte(`{"type":"chat","data":"Hello","done":false,"request_id":"request","seq":1,"model":"phi"}`)))
+		assert.NoError(t, q.push([]byte(`{"type":"notification","data":"news"}`)))
+		assert.NoError(t, q.push([]byte(`{"type":"chat","data":", world","done":true,"request_id":"request","seq":2,"model":"phi","usage":{"tokens":3}}`)))
+
+		frames := drain(q)
+
+		assert.Len(t, frames, 2)
+		assert.JSONEq(t, `{"type":"chat","data":"Hello, world","done":true,"request_id":"request","seq":2,"model":"phi","usage":{"tokens":3}}`, frames[0])
+	})
+
+	t.Run("coalesce without chunks to merge", func(t *testing.T) {
+		q := newOutbound(PolicyCoalesce, 1, time.Second)
+
+		assert.NoError(t, q.push([]byte(`{"type":"notification","data":"news"}`)))
+		assert.ErrorIs(t, q.push([]byte(`{"type":"notification","data":"more news"}`)), errSlowConsumer)
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		q := newOutbound(PolicyDisconnect, 1, time.Second)
+
+		assert.NoError(t, q.push([]byte("first")))
+		assert.ErrorIs(t, q.push([]byte("second")), errSlowConsumer)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		q := newOutbound(PolicyBlock, 1, time.Second)
+
+		assert.NoError(t, q.push([]byte("first")))
+
+		q.close()
+
+		assert.ErrorIs(t, q.push([]byte("second")), errQueueClosed)
+		assert.Equal(t, []string{"first"}, drain(q))
+	})
+}
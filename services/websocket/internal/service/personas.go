@@ -0,0 +1,174 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"pkg/ai"
+	"pkg/auth"
+	"strings"
+	"websocket/internal/store"
+)
+
+// Personas returns the handler of the persona management api, serving /personas
+func (m *Service) Personas() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /personas", m.authorized(apiError, m.listPersonas))
+	mux.HandleFunc("POST /personas", m.authorized(apiError, m.createPersona))
+	mux.HandleFunc("GET /personas/{id}", m.authorized(apiError, m.getPersona))
+	mux.HandleFunc("PUT /personas/{id}", m.authorized(apiError, m.updatePersona))
+	mux.HandleFunc("DELETE /personas/{id}", m.authorized(apiError, m.deletePersona))
+
+	return mux
+}
+
+// apiError responds with an error of the management api
+func apiError(w http.ResponseWriter, status int, message string) {
+	respond(w, status, map[string]string{
+		"error": message,
+	})
+}
+
+// respond writes the body as json with the status
+func respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("unable to write json response", "error", err)
+	}
+}
+
+// personaRequest is the body of the requests creating or updating a persona
+type personaRequest struct {
+	Name         string                 `json:"name"`
+	SystemPrompt string                 `json:"system_prompt"`
+	Model        string                 `json:"model"`
+	Options      map[string]interface{} `json:"options"`
+}
+
+// persona decodes and validates the persona in the body of the request, its options must be
+// within the limits of the role of the user
+func (m *Service) persona(r *http.Request, claims *auth.Claims) (*store.Persona, error) {
+	request := &personaRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		return nil, errors.New("invalid persona")
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+
+	switch {
+	case request.Name == "":
+		return nil, errors.New("name is required")
+	case strings.TrimSpace(request.SystemPrompt) == "":
+		return nil, errors.New("system_prompt is required")
+	case request.Model != "" && !m.allowed(request.Model):
+		return nil, errors.New("model not allowed")
+	}
+
+	if err := m.generation(claims.Role, &ai.ChatRequest{Options: request.Options}); err != nil {
+		return nil, err
+	}
+
+	return &store.Persona{
+		Name:         request.Name,
+		SystemPrompt: request.SystemPrompt,
+		Model:        request.Model,
+		Options:      request.Options,
+	}, nil
+}
+
+func (m *Service) listPersonas(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	personas, err := m.store.Personas(r.Context(), claims.UserID)
+
+	if err != nil {
+		slog.Error("unable to list personas", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to list personas")
+
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]interface{}{
+		"data": personas,
+	})
+}
+
+func (m *Service) createPersona(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	persona, err := m.persona(r, claims)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	persona.ID = newID()
+	persona.Owner = claims.UserID
+
+	if err := m.store.SavePersona(r.Context(), persona); err != nil {
+		slog.Error("unable to save persona", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to save persona")
+
+		return
+	}
+
+	respond(w, http.StatusCreated, persona)
+}
+
+func (m *Service) getPersona(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	persona, err := m.store.Persona(r.Context(), claims.UserID, r.PathValue("id"))
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		apiError(w, http.StatusNotFound, errPersonaNotFound.Error())
+	case err != nil:
+		slog.Error("unable to load persona", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to load persona")
+	default:
+		respond(w, http.StatusOK, persona)
+	}
+}
+
+func (m *Service) updatePersona(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	id := r.PathValue("id")
+
+	if _, err := m.store.Persona(r.Context(), claims.UserID, id); err != nil {
+		apiError(w, http.StatusNotFound, errPersonaNotFound.Error())
+		return
+	}
+
+	persona, err := m.persona(r, claims)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	persona.ID = id
+	persona.Owner = claims.UserID
+
+	if err := m.store.SavePersona(r.Context(), persona); err != nil {
+		slog.Error("unable to save persona", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to save persona")
+
+		return
+	}
+
+	respond(w, http.StatusOK, persona)
+}
+
+func (m *Service) deletePersona(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	err := m.store.DeletePersona(r.Context(), claims.UserID, r.PathValue("id"))
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		apiError(w, http.StatusNotFound, errPersonaNotFound.Error())
+	case err != nil:
+		slog.Error("unable to delete persona", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to delete persona")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pkg/ai"
+	mock_kafka "pkg/kafka/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder is a fake provider keeping the chat requests it receives
+type recorder struct {
+	ai.Fake
+	requests chan *ai.ChatRequest
+}
+
+func newRecorder(reply string) *recorder {
+	return &recorder{
+		Fake:     ai.Fake{Reply: reply},
+		requests: make(chan *ai.ChatRequest, 16),
+	}
+}
+
+func (r *recorder) Chat(ctx context.Context, request *ai.ChatRequest, cb ai.ChatCallBack) error {
+	r.requests <- request
+
+	return r.Fake.Chat(ctx, request, cb)
+}
+
+// request returns the next chat request the provider received, skipping the requests naming the
+// conversations which are made in the background
+func (r *recorder) request(t *testing.T) *ai.ChatRequest {
+	for {
+		select {
+		case request := <-r.requests:
+			if len(request.Messages) > 0 && request.Messages[0].Content == titlePrompt {
+				continue
+			}
+
+			return request
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a chat request")
+			return nil
+		}
+	}
+}
+
+func TestPersonas(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+	authServer.issue("other", "2", time.Now().Add(time.Hour))
+
+	provider := newRecorder("Arr matey")
+
+	service, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_kafka.NewMockProducer(ctrl), authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(provider),
+		WithAllowedModels("phi", "llama*"),
+	)
+
+	server := httptest.NewServer(service.Personas())
+	t.Cleanup(server.Close)
+
+	request := func(method, path, token string, body interface{}) (int, map[string]interface{}) {
+		data, _ := json.Marshal(body)
+
+		req, _ := http.NewRequest(method, server.URL+path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		response := map[string]interface{}{}
+		json.NewDecoder(res.Body).Decode(&response)
+
+		return res.StatusCode, response
+	}
+
+	status, pirate := request(http.MethodPost, "/personas", "token", map[string]interface{}{
+		"name":          "pirate",
+		"system_prompt": "talk like a pirate",
+		"model":         "llama3.2",
+		"options":       map[string]interface{}{"temperature": 0.2},
+	})
+
+	assert.Equal(t, http.StatusCreated, status)
+	assert.NotEmpty(t, pirate["id"])
+
+	id := pirate["id"].(string)
+
+	t.Run("validation", func(t *testing.T) {
+		status, body := request(http.MethodPost, "/personas", "token", map[string]interface{}{"name": "pirate"})
+
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, "system_prompt is required", body["error"])
+
+		status, body = request(http.MethodPost, "/personas", "token", map[string]interface{}{
+			"name":          "pirate",
+			"system_prompt": "talk like a pirate",
+			"model":         "gpt-4o",
+		})
+
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, "model not allowed", body["error"])
+	})
+
+	t.Run("manage", func(t *testing.T) {
+		status, body := request(http.MethodGet, "/personas", "token", nil)
+
+		assert.Equal(t, http.StatusOK, status)
+		assert.Len(t, body["data"], 1)
+
+		// personas are private to their owner
+		status, _ = request(http.MethodGet, "/personas/"+id, "other", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+
+		status, _ = request(http.MethodGet, "/personas/"+id, "", nil)
+		assert.Equal(t, http.StatusUnauthorized, status)
+
+		status, body = request(http.MethodPut, "/personas/"+id, "token", map[string]interface{}{
+			"name":          "pirate",
+			"system_prompt": "talk like a pirate, briefly",
+			"model":         "llama3.2",
+			"options":       map[string]interface{}{"temperature": 0.2},
+		})
+
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "talk like a pirate, briefly", body["system_prompt"])
+
+		status, body = request(http.MethodGet, "/personas/"+id, "token", nil)
+
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "talk like a pirate, briefly", body["system_prompt"])
+	})
+
+	t.Run("chat with a persona", func(t *testing.T) {
+		conn := dial(t, url, "token")
+		conn.WriteJSON(map[string]string{"type": "chat", "data": "hello", "persona": id})
+
+		// the model and parameters of the persona apply when the chat does not set them
+		chat := provider.request(t)
+
+		assert.Equal(t, "llama3.2", chat.Model)
+		assert.Equal(t, 0.2, chat.Options["temperature"])
+		assert.Equal(t, []ai.Message{
+			{Role: ai.SYSTEM, Content: "talk like a pirate, briefly"},
+			{Role: ai.USER, Content: "hello"},
+		}, chat.Messages)
+
+		// an ad-hoc system prompt takes precedence
+		conn.WriteJSON(map[string]string{"type": "chat", "data": "hello", "model": "phi", "persona": id, "system": "be polite"})
+
+		chat = provider.request(t)
+
+		assert.Equal(t, "phi", chat.Model)
+		assert.Equal(t, ai.Message{Role: ai.SYSTEM, Content: "be polite"}, chat.Messages[0])
+
+		conn.WriteJSON(map[string]string{"type": "chat", "data": "hello", "persona": "unknown", "request_id": "unknown"})
+
+		for {
+			frame, err := readFrame(t, conn)
+			assert.NoError(t, err)
+
+			if frame["request_id"] == "unknown" {
+				assert.Equal(t, "persona not found", frame["error"])
+				break
+			}
+		}
+	})
+
+	t.Run("conversation", func(t *testing.T) {
+		conn := dial(t, url, "token")
+
+		// the persona is remembered by the conversation
+		conn.WriteJSON(map[string]string{"type": "chat", "data": "hello", "persona": id, "conversation_id": "voyage", "request_id": "1"})
+		provider.request(t)
+
+		for {
+			frame, err := readFrame(t, conn)
+			assert.NoError(t, err)
+
+			if frame["done"] == true {
+				break
+			}
+		}
+
+		// the exchange is saved once the reply completes
+		assert.Eventually(t, func() bool {
+			conversation, err := service.store.Conversation(context.Background(), "1", "voyage")
+
+			return err == nil && len(conversation.Messages) == 2
+		}, time.Second, 10*time.Millisecond)
+
+		conn.WriteJSON(map[string]string{"type": "chat", "data": "where to?", "conversation_id": "voyage", "request_id": "2"})
+
+		chat := provider.request(t)
+
+		assert.Equal(t, "llama3.2", chat.Model)
+		assert.Equal(t, []ai.Message{
+			{Role: ai.SYSTEM, Content: "talk like a pirate, briefly"},
+			{Role: ai.USER, Content: "hello"},
+			{Role: ai.ASSISTANT, Content: "Arr matey"},
+			{Role: ai.USER, Content: "where to?"},
+		}, chat.Messages)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		status, _ := request(http.MethodDelete, "/personas/"+id, "token", nil)
+		assert.Equal(t, http.StatusNoContent, status)
+
+		status, _ = request(http.MethodDelete, "/personas/"+id, "token", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+}
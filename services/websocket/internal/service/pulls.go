@@ -0,0 +1,394 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"pkg/ai"
+	"sync"
+	"websocket/internal/model"
+)
+
+var (
+	errPullQueueFull = errors.New("too many pulls are waiting, please try again later")
+	errPullLimit     = errors.New("you have reached the limit of pulls in progress")
+)
+
+// job is a model pull running on the server. It is shared by every user requesting the model
+// and keeps running when the connections of the users drop.
+type job struct {
+	model  string
+	owner  string // user who started the pull, it counts against their limit
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	users  map[string]bool // users interested in the progress of the pull
+	status ai.PullResponse // latest progress reported by ollama
+}
+
+// pullStatus is the progress of a job as reported to the clients
+type pullStatus struct {
+	Model string `json:"model"`
+	ai.PullResponse
+}
+
+// follow adds the user to the audience of the job
+func (j *job) follow(user string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.users[user] = true
+}
+
+// unfollow removes the user from the audience of the job and returns the number of users remaining
+func (j *job) unfollow(user string) int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delete(j.users, user)
+
+	return len(j.users)
+}
+
+// progress records the latest progress and returns the users interested in it
+func (j *job) progress(status *ai.PullResponse) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if status != nil {
+		j.status = *status
+	}
+
+	users := make([]string, 0, len(j.users))
+
+	for user := range j.users {
+		users = append(users, user)
+	}
+
+	return users
+}
+
+// snapshot returns the latest progress of the job when the user is following it
+func (j *job) snapshot(user string) (*pullStatus, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.users[user] {
+		return nil, false
+	}
+
+	return &pullStatus{Model: j.model, PullResponse: j.status}, true
+}
+
+// pulls keeps the model pulls in progress keyed by model name. The pulls wait in a bounded
+// queue for one of the slots limiting how many models are downloaded at once.
+type pulls struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	slots     chan struct{} // a slot is held by every running pull
+	queued    int           // number of pulls waiting for a slot
+	queueSize int           // maximum number of pulls waiting for a slot
+	perUser   int           // maximum number of pulls a user can start at once, unlimited when zero
+}
+
+func newPulls(parallelism, queueSize, perUser int) *pulls {
+	return &pulls{
+		jobs:      make(map[string]*job),
+		slots:     make(chan struct{}, parallelism),
+		queueSize: queueSize,
+		perUser:   perUser,
+	}
+}
+
+// join adds the user to the pull of the model, queueing a new job when none is in progress.
+// It returns the job along with whether it has been started by this call, or an error when
+// the queue is full or the user has reached their limit.
+func (p *pulls) join(model, user string) (*job, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if job, ok := p.jobs[model]; ok {
+		job.follow(user)
+
+		return job, false, nil
+	}
+
+	if p.perUser > 0 {
+		owned := 0
+
+		for _, job := range p.jobs {
+			if job.owner == user {
+				owned++
+			}
+		}
+
+		if owned >= p.perUser {
+			return nil, false, errPullLimit
+		}
+	}
+
+	if p.queued >= p.queueSize {
+		return nil, false, errPullQueueFull
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := &job{
+		model:  model,
+		owner:  user,
+		ctx:    ctx,
+		cancel: cancel,
+		users:  map[string]bool{user: true},
+		status: ai.PullResponse{
+			Status: "queued",
+		},
+	}
+
+	p.jobs[model] = job
+	p.queued++
+
+	return job, true, nil
+}
+
+// acquire waits in the queue for a slot to run the job. It returns false when the job has been
+// canceled while waiting.
+func (p *pulls) acquire(job *job) bool {
+	defer func() {
+		p.mu.Lock()
+		p.queued--
+		p.mu.Unlock()
+	}()
+
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	case <-job.ctx.Done():
+		return false
+	}
+}
+
+// release the slot held by a running job
+func (p *pulls) release() {
+	<-p.slots
+}
+
+// leave removes the user from the pull of the model, the pull is canceled once nobody follows it.
+// It returns false when the user is not following a pull of the model.
+func (p *pulls) leave(model, user string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job, ok := p.jobs[model]
+
+	if !ok {
+		return false
+	}
+
+	if _, following := job.snapshot(user); !following {
+		return false
+	}
+
+	if job.unfollow(user) == 0 {
+		job.cancel()
+	}
+
+	return true
+}
+
+// abort cancels every pull in progress
+func (p *pulls) abort() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, job := range p.jobs {
+		job.cancel()
+	}
+}
+
+// finish forgets the completed job, the next pull of the model starts over
+func (p *pulls) finish(job *job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.jobs[job.model] == job {
+		delete(p.jobs, job.model)
+	}
+
+	job.cancel()
+}
+
+// status returns the progress of the pulls the user is following
+func (p *pulls) status(user string) []*pullStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := []*pullStatus{}
+
+	for _, job := range p.jobs {
+		if status, ok := job.snapshot(user); ok {
+			statuses = append(statuses, status)
+		}
+	}
+
+	return statuses
+}
+
+// pull joins the client's user to the pull of the model, starting the job when it is not in progress yet.
+// The progress is broadcast to every connection of the users following the job.
+func (c *Client) pull(manager *Service, model string) {
+	if !manager.manages(c.session.role()) {
+		if data := manager.pullFrame(&pullStatus{Model: model}, errNotManager.Error(), true); data != nil {
+			c.deliver(data)
+		}
+
+		return
+	}
+
+	job, started, err := manager.pulls.join(model, c.session.userID())
+
+	if err != nil {
+		if data := manager.pullFrame(&pullStatus{Model: model}, err.Error(), true); data != nil {
+			c.deliver(data)
+		}
+
+		return
+	}
+
+	if !started {
+		// let the client catch up with the job in progress
+		status, _ := job.snapshot(c.session.userID())
+
+		if data := manager.pullFrame(status, "", false); data != nil {
+			c.deliver(data)
+		}
+
+		return
+	}
+
+	manager.track(func() {
+		manager.runPull(job)
+	})
+}
+
+// runPull waits for a slot then pulls the model of the job from ollama, broadcasting the progress
+// until it completes or is canceled
+func (m *Service) runPull(job *job) {
+	defer m.pulls.finish(job)
+
+	if !m.pulls.acquire(job) {
+		m.broadcast(job, nil, "pull canceled", true)
+		return
+	}
+
+	defer m.pulls.release()
+
+	// Callback function to handle the response
+	callback := func(cr *ai.PullResponse) {
+		m.broadcast(job, cr, "", cr.Status == "success" || cr.Status == "writing manifest")
+	}
+
+	err := m.ai.Pull(job.ctx, job.model, callback)
+
+	switch {
+	case job.ctx.Err() != nil:
+		m.broadcast(job, nil, "pull canceled", true)
+	case err != nil:
+		slog.Error("unable to pull model", "model", job.model, "error", err)
+
+		m.broadcast(job, nil, "unable to pull model", true)
+	}
+}
+
+// broadcast the progress of the job to every connection of the users following it
+func (m *Service) broadcast(job *job, cr *ai.PullResponse, reason string, done bool) {
+	users := job.progress(cr)
+
+	status := &pullStatus{Model: job.model}
+
+	if cr != nil {
+		status.PullResponse = *cr
+	}
+
+	if data := m.pullFrame(status, reason, done); data != nil {
+		m.deliverUsers(users, data)
+	}
+}
+
+// deliverUsers delivers the frame to every connection of the users
+func (m *Service) deliverUsers(users []string, data []byte) {
+	m.mu.Lock()
+
+	var clients []*Client
+
+	for _, user := range users {
+		for client := range m.users[user] {
+			clients = append(clients, client)
+		}
+	}
+
+	m.mu.Unlock()
+
+	for _, client := range clients {
+		client.deliver(data)
+	}
+}
+
+// pullFrame marshals the progress of a pull, with the reason when it failed
+func (m *Service) pullFrame(status *pullStatus, reason string, done bool) []byte {
+	payload := map[string]interface{}{
+		"type":  "pull",
+		"model": status.Model,
+		"data":  status,
+		"done":  done,
+	}
+
+	if reason != "" {
+		payload["error"] = reason
+	}
+
+	data, err := json.Marshal(payload)
+
+	if err != nil {
+		slog.Error("unable to marshal json response", "error", err)
+		return nil
+	}
+
+	return data
+}
+
+// pulling replies with the progress of the pulls the user is following
+func (c *Client) pulling(manager *Service) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type": "pulls",
+		"data": manager.pulls.status(c.session.userID()),
+	})
+
+	if err != nil {
+		slog.Error("unable to marshal json response", "error", err)
+		return
+	}
+
+	c.deliver(data)
+}
+
+// cancel stops following the pull of the model, which is aborted once no user follows it
+func (c *Client) cancel(manager *Service, message *model.Message) {
+	if !manager.pulls.leave(message.Model, c.session.userID()) {
+		c.error(message.RequestID, "no pull of the model in progress")
+		return
+	}
+
+	data := manager.pullFrame(&pullStatus{
+		Model: message.Model,
+		PullResponse: ai.PullResponse{
+			Status: "canceled",
+		},
+	}, "", true)
+
+	if data != nil {
+		// every connection of the user stops following the pull
+		manager.deliverUsers([]string{c.session.userID()}, data)
+	}
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"pkg/ai"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// ollamaPull simulates the ollama pull api, streaming the progress for every status sent on the
+// channel and counting the pulls requested
+func ollamaPull(statuses <-chan string, requests *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		w.Header().Add("Content-Type", "application/x-ndjson")
+
+		flusher := w.(http.Flusher)
+		writer := json.NewEncoder(w)
+
+		for {
+			select {
+			case status, ok := <-statuses:
+				if !ok {
+					return
+				}
+
+				writer.Encode(ai.PullResponse{
+					Status: status,
+				})
+				flusher.Flush()
+
+				time.Sleep(50 * time.Millisecond)
+			case <-r.Context().Done():
+				// the pull has been aborted
+				return
+			}
+		}
+	})
+}
+
+func TestSharedPulls(t *testing.T) {
+	authServer := newAuthServer()
+	authServer.issue("alice", "1", time.Now().Add(time.Hour))
+	authServer.grant("alice", "admin")
+	authServer.issue("bob", "2", time.Now().Add(time.Hour))
+	authServer.grant("bob", "admin")
+
+	statuses := make(chan string)
+	requests := &atomic.Int32{}
+
+	url := serve(t, authServer, ollamaPull(statuses, requests), WithVerifyInterval(0))
+
+	alice := dial(t, url, "alice")
+	bob := dial(t, url, "bob")
+
+	// progress reads the next pull frame of the connection
+	progress := func(conn *websocket.Conn) (string, bool) {
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "pull", frame["type"])
+		assert.Equal(t, "phi", frame["model"])
+
+		return frame["data"].(map[string]interface{})["status"].(string), frame["done"].(bool)
+	}
+
+	// reconnect dials again as alice, the connection lives as long as the test
+	reconnect := func() *websocket.Conn {
+		return dial(t, url, "alice")
+	}
+
+	alice.WriteJSON(map[string]string{"type": "pull", "model": "phi"})
+
+	// wait for the job to start before bob joins it
+	assert.Eventually(t, func() bool {
+		return requests.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	bob.WriteJSON(map[string]string{"type": "pull", "model": "phi"})
+
+	t.Run("joining user catches up with the pull in progress", func(t *testing.T) {
+		status, done := progress(bob)
+
+		assert.Equal(t, "queued", status)
+		assert.False(t, done)
+	})
+
+	t.Run("progress is broadcast to every user", func(t *testing.T) {
+		statuses <- "pulling manifest"
+
+		for _, conn := range []*websocket.Conn{alice, bob} {
+			status, done := progress(conn)
+
+			assert.Equal(t, "pulling manifest", status)
+			assert.False(t, done)
+		}
+	})
+
+	t.Run("pull survives disconnects", func(t *testing.T) {
+		alice.Close()
+
+		statuses <- "downloading"
+
+		status, _ := progress(bob)
+		assert.Equal(t, "downloading", status)
+
+		alice = reconnect()
+		alice.WriteJSON(map[string]string{"type": "pulls"})
+
+		frame, err := readFrame(t, alice)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "pulls", frame["type"])
+
+		pulls := frame["data"].([]interface{})
+
+		assert.Len(t, pulls, 1)
+		assert.Equal(t, "phi", pulls[0].(map[string]interface{})["model"])
+		assert.Equal(t, "downloading", pulls[0].(map[string]interface{})["status"])
+	})
+
+	t.Run("reconnected user receives the completion", func(t *testing.T) {
+		statuses <- "success"
+		close(statuses)
+
+		for _, conn := range []*websocket.Conn{alice, bob} {
+			status, done := progress(conn)
+
+			assert.Equal(t, "success", status)
+			assert.True(t, done)
+		}
+
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("completed pulls are forgotten", func(t *testing.T) {
+		assert.Eventually(t, func() bool {
+			bob.WriteJSON(map[string]string{"type": "pulls"})
+
+			frame, err := readFrame(t, bob)
+
+			return err == nil && frame["type"] == "pulls" && len(frame["data"].([]interface{})) == 0
+		}, time.Second, 50*time.Millisecond)
+	})
+}
+
+func TestPullQueue(t *testing.T) {
+	authServer := newAuthServer()
+	authServer.issue("alice", "1", time.Now().Add(time.Hour))
+	authServer.grant("alice", "admin")
+	authServer.issue("bob", "2", time.Now().Add(time.Hour))
+	authServer.grant("bob", "admin")
+	authServer.issue("carol", "3", time.Now().Add(time.Hour))
+	authServer.grant("carol", "admin")
+
+	statuses := make(chan string)
+	requests := &atomic.Int32{}
+
+	// a single model is pulled at once, one more can wait and users start a single pull each
+	url := serve(t, authServer, ollamaPull(statuses, requests), WithVerifyInterval(0), WithPulls(1, 1, 1))
+
+	// complete the pulls still running before the servers are closed
+	t.Cleanup(func() {
+		close(statuses)
+	})
+
+	alice := dial(t, url, "alice")
+	bob := dial(t, url, "bob")
+	carol := dial(t, url, "carol")
+
+	// pull requests the model and returns the next frame of the connection
+	pull := func(conn *websocket.Conn, model string) map[string]interface{} {
+		conn.WriteJSON(map[string]string{"type": "pull", "model": model})
+
+		frame, err := readFrame(t, conn)
+		assert.NoError(t, err)
+
+		return frame
+	}
+
+	alice.WriteJSON(map[string]string{"type": "pull", "model": "phi"})
+
+	assert.Eventually(t, func() bool {
+		return requests.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	bob.WriteJSON(map[string]string{"type": "pull", "model": "gemma"})
+
+	// wait for the pull of bob to be queued
+	assert.Eventually(t, func() bool {
+		bob.WriteJSON(map[string]string{"type": "pulls"})
+
+		frame, err := readFrame(t, bob)
+
+		return err == nil && len(frame["data"].([]interface{})) == 1
+	}, time.Second, 50*time.Millisecond)
+
+	t.Run("user limit", func(t *testing.T) {
+		frame := pull(alice, "llama3.2")
+
+		assert.Equal(t, "llama3.2", frame["model"])
+		assert.Equal(t, errPullLimit.Error(), frame["error"])
+		assert.True(t, frame["done"].(bool))
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		frame := pull(carol, "llama3.2")
+
+		assert.Equal(t, errPullQueueFull.Error(), frame["error"])
+	})
+
+	t.Run("joining a pull is not limited", func(t *testing.T) {
+		frame := pull(carol, "phi")
+
+		assert.Equal(t, "phi", frame["model"])
+		assert.Nil(t, frame["error"])
+	})
+
+	t.Run("cancel a queued pull", func(t *testing.T) {
+		bob.WriteJSON(map[string]string{"type": "cancel", "model": "gemma"})
+
+		frame, err := readFrame(t, bob)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "gemma", frame["model"])
+		assert.Equal(t, "canceled", frame["data"].(map[string]interface{})["status"])
+		assert.True(t, frame["done"].(bool))
+	})
+
+	t.Run("cancel an unknown pull", func(t *testing.T) {
+		bob.WriteJSON(map[string]string{"type": "cancel", "model": "gemma"})
+
+		frame, err := readFrame(t, bob)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "error", frame["type"])
+	})
+
+	t.Run("pull continues while followed", func(t *testing.T) {
+		alice.WriteJSON(map[string]string{"type": "cancel", "model": "phi"})
+
+		frame, err := readFrame(t, alice)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "canceled", frame["data"].(map[string]interface{})["status"])
+
+		statuses <- "downloading"
+
+		frame, err = readFrame(t, carol)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "downloading", frame["data"].(map[string]interface{})["status"])
+	})
+
+	t.Run("pull is aborted once nobody follows it", func(t *testing.T) {
+		carol.WriteJSON(map[string]string{"type": "cancel", "model": "phi"})
+
+		frame, err := readFrame(t, carol)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "canceled", frame["data"].(map[string]interface{})["status"])
+
+		// the slot is released, so the next pull starts right away
+		alice.WriteJSON(map[string]string{"type": "pull", "model": "llama3.2"})
+
+		assert.Eventually(t, func() bool {
+			return requests.Load() == 2
+		}, time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"pkg/auth"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kinds of frames limited separately, every limited frame counts toward the total as well
+const (
+	chatFrames  = "chat"
+	pullFrames  = "pull"
+	totalFrames = "total"
+)
+
+// Rate is how many frames can be sent per minute, in bursts of up to Burst frames
+type Rate struct {
+	PerMinute float64 `json:"per_minute"`
+	Burst     int     `json:"burst"` // a single frame when zero
+}
+
+// RateLimits are the rates of the frames a user can send across its connections and over a single
+// connection, per kind of frame: chat, pull or total. The kinds without a rate are unlimited.
+type RateLimits struct {
+	User       map[string]Rate `json:"user"`
+	Connection map[string]Rate `json:"connection"`
+}
+
+// ParseRateLimits parses the rate limits per role from json, such as
+// {"*": {"user": {"chat": {"per_minute": 20, "burst": 5}}, "connection": {"total": {"per_minute": 120, "burst": 20}}}, "admin": {}}
+func ParseRateLimits(data string) (map[string]RateLimits, error) {
+	limits := make(map[string]RateLimits)
+
+	if strings.TrimSpace(data) == "" {
+		return limits, nil
+	}
+
+	if err := json.Unmarshal([]byte(data), &limits); err != nil {
+		return nil, fmt.Errorf("invalid rate limits: %w", err)
+	}
+
+	for role, roleLimits := range limits {
+		for _, rates := range []map[string]Rate{roleLimits.User, roleLimits.Connection} {
+			for kind, rate := range rates {
+				if !slices.Contains([]string{chatFrames, pullFrames, totalFrames}, kind) {
+					return nil, fmt.Errorf("unknown kind of frames %q in the rate limits of %q", kind, role)
+				}
+
+				if rate.PerMinute <= 0 || rate.Burst < 0 {
+					return nil, fmt.Errorf("the rate of the %s frames of %q must be positive", kind, role)
+				}
+			}
+		}
+	}
+
+	return limits, nil
+}
+
+// bucket is a token bucket, every frame takes a token and the tokens are added back at the rate
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last frame at the rate, which may have changed since
+func (b *bucket) refill(rate Rate, now time.Time) {
+	burst := float64(max(rate.Burst, 1))
+
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Minutes()*rate.PerMinute)
+	}
+
+	b.rate, b.last = rate, now
+}
+
+// wait returns how long until the bucket has a token for the next frame
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate.PerMinute * float64(time.Minute))
+}
+
+// full reports whether the bucket has refilled completely, so it is no different from a new one
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Minutes()*b.rate.PerMinute >= float64(max(b.rate.Burst, 1))
+}
+
+// limiter holds the buckets of the users, the buckets of a connection are held by its read loop
+type limiter struct {
+	mu    sync.Mutex
+	users map[string]*bucket // keyed by user and kind of frames
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		users: make(map[string]*bucket),
+	}
+}
+
+// prune forgets the buckets which have refilled completely
+func (l *limiter) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	for key, bucket := range l.users {
+		if bucket.full(now) {
+			delete(l.users, key)
+		}
+	}
+}
+
+// frameKinds returns the kinds of the frame for the rate limits, none for the frames which are
+// never limited: renewing the credentials and acknowledging the frames received
+func frameKinds(frame string) []string {
+	switch frame {
+	case "auth", "ack":
+		return nil
+	case "pull":
+		return []string{pullFrames, totalFrames}
+	case "resume", "pulls", "cancel", "models", "show", "delete", "copy", "ps":
+		return []string{totalFrames}
+	default:
+		return []string{chatFrames, totalFrames}
+	}
+}
+
+// ratesOf returns the rate limits of the role, the limits of every role apply when it has none of its own
+func (m *Service) ratesOf(role string) RateLimits {
+	if limits, ok := m.rates[role]; ok {
+		return limits
+	}
+
+	return m.rates[anyRole]
+}
+
+// throttle takes a token for the frame from the buckets of the user and the connection, the http
+// requests have no connection and pass nil. When one of the buckets is empty no token is taken,
+// it returns the kind of frames over the limit and how long until the frame would be accepted.
+func (m *Service) throttle(user, role string, connection map[string]*bucket, frame string) (string, time.Duration) {
+	limits := m.ratesOf(role)
+
+	type limited struct {
+		kind   string
+		bucket *bucket
+		rate   Rate
+	}
+
+	var buckets []limited
+
+	m.limiter.mu.Lock()
+	defer m.limiter.mu.Unlock()
+
+	for _, kind := range frameKinds(frame) {
+		if rate, ok := limits.User[kind]; ok && user != "" {
+			key := user + " " + kind
+
+			if m.limiter.users[key] == nil {
+				m.limiter.users[key] = &bucket{}
+			}
+
+			buckets = append(buckets, limited{kind, m.limiter.users[key], rate})
+		}
+
+		if rate, ok := limits.Connection[kind]; ok && connection != nil {
+			if connection[kind] == nil {
+				connection[kind] = &bucket{}
+			}
+
+			buckets = append(buckets, limited{kind, connection[kind], rate})
+		}
+	}
+
+	now := time.Now()
+
+	for _, b := range buckets {
+		b.bucket.refill(b.rate, now)
+
+		if wait := b.bucket.wait(); wait > 0 {
+			return b.kind, wait
+		}
+	}
+
+	for _, b := range buckets {
+		b.bucket.tokens--
+	}
+
+	return "", 0
+}
+
+// throttled rejects the chat request over the rate limits of the user with fail, telling how many
+// seconds to wait before sending it again in the Retry-After header. It reports whether the request
+// was rejected.
+func (m *Service) throttled(w http.ResponseWriter, claims *auth.Claims, fail func(w http.ResponseWriter, status int, message string)) bool {
+	kind, wait := m.throttle(claims.UserID, claims.Role, nil, chatFrames)
+
+	if wait <= 0 {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	fail(w, http.StatusTooManyRequests, fmt.Sprintf("too many %s requests, please slow down", kind))
+
+	return true
+}
+
+// rateLimited tells the client the frame has been dropped for exceeding the rate limit of its kind,
+// and how many seconds to wait before sending it again
+func (c *Client) rateLimited(requestID, kind string, wait time.Duration) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":        "rate_limited",
+		"request_id":  requestID,
+		"error":       fmt.Sprintf("too many %s frames, please slow down", kind),
+		"limit":       kind,
+		"retry_after": int(math.Ceil(wait.Seconds())),
+	})
+
+	if err != nil {
+		slog.Error("unable to marshal json response", "error", err)
+		return
+	}
+
+	c.deliver(data)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"pkg/ai"
+	"pkg/auth"
+	mock_kafka "pkg/kafka/mocks"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits(`{"*": {"user": {"chat": {"per_minute": 20, "burst": 5}}, "connection": {"total": {"per_minute": 120}}}, "admin": {}}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Rate{PerMinute: 20, Burst: 5}, limits["*"].User["chat"])
+	assert.Equal(t, Rate{PerMinute: 120}, limits["*"].Connection["total"])
+	assert.Empty(t, limits["admin"].User)
+
+	_, err = ParseRateLimits(`{"*": {"user": {"models": {"per_minute": 1}}}}`)
+	assert.EqualError(t, err, `unknown kind of frames "models" in the rate limits of "*"`)
+
+	_, err = ParseRateLimits(`{"*": {"connection": {"pull": {"per_minute": 0}}}}`)
+	assert.EqualError(t, err, `the rate of the pull frames of "*" must be positive`)
+}
+
+func TestBucket(t *testing.T) {
+	rate := Rate{PerMinute: 60, Burst: 2}
+	now := time.Now()
+	b := &bucket{}
+
+	for i := 0; i < 2; i++ {
+		b.refill(rate, now)
+		assert.Zero(t, b.wait())
+		b.tokens--
+	}
+
+	b.refill(rate, now)
+	assert.Equal(t, time.Second, b.wait())
+
+	// a token is added back every second
+	b.refill(rate, now.Add(1500*time.Millisecond))
+	assert.Zero(t, b.wait())
+	assert.False(t, b.full(now.Add(1500*time.Millisecond)))
+	assert.True(t, b.full(now.Add(2*time.Second)))
+}
+
+func TestRateLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	service, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_kafka.NewMockProducer(ctrl), authServer, ollamaModels("phi"),
+		WithVerifyInterval(0),
+		WithProvider(&ai.Fake{Reply: "hi"}),
+		WithAPIKeys(map[string]*auth.Claims{
+			"sk-1": {UserID: "1", Role: "member"},
+		}),
+		WithRateLimits(map[string]RateLimits{
+			"*": {
+				User:       map[string]Rate{"chat": {PerMinute: 1, Burst: 2}},
+				Connection: map[string]Rate{"total": {PerMinute: 1, Burst: 2}},
+			},
+		}),
+	)
+
+	first := dial(t, url, "token")
+	second := dial(t, url, "token")
+
+	// send writes the frame and returns the last frame of the response
+	send := func(conn *websocket.Conn, frame map[string]interface{}) map[string]interface{} {
+		conn.WriteJSON(frame)
+
+		for {
+			received, err := readFrame(t, conn)
+
+			if !assert.NoError(t, err) {
+				return nil
+			}
+
+			switch {
+			case received["type"] == "rate_limited" && received["request_id"] == frame["request_id"],
+				received["type"] == "chat" && received["request_id"] == frame["request_id"] && received["done"] == true,
+				received["type"] == "models" && frame["type"] == "models":
+				return received
+			}
+		}
+	}
+
+	assert.Equal(t, "chat", send(first, map[string]interface{}{"type": "chat", "model": "phi", "data": "hi", "request_id": "1"})["type"])
+	assert.Equal(t, "chat", send(second, map[string]interface{}{"type": "chat", "model": "phi", "data": "hi", "request_id": "2"})["type"])
+
+	// the chats of the user are limited across its connections
+	limited := send(first, map[string]interface{}{"type": "chat", "model": "phi", "data": "hi", "request_id": "3"})
+
+	assert.Equal(t, "rate_limited", limited["type"])
+	assert.Equal(t, "chat", limited["limit"])
+	assert.InDelta(t, 60, limited["retry_after"], 1)
+
+	// the other frames are only limited per connection, the chats count toward it as well but the rejected frames do not
+	assert.Equal(t, "models", send(first, map[string]interface{}{"type": "models", "request_id": "4"})["type"])
+
+	limited = send(first, map[string]interface{}{"type": "models", "request_id": "5"})
+
+	assert.Equal(t, "rate_limited", limited["type"])
+	assert.Equal(t, "total", limited["limit"])
+
+	assert.Equal(t, "models", send(second, map[string]interface{}{"type": "models", "request_id": "6"})["type"])
+
+	// the chats over http take from the buckets of the user as well
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat", service.ServeChat)
+	mux.Handle("/v1/", service.OpenAI())
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	for path, token := range map[string]string{"/chat": "token", "/v1/chat/completions": "sk-1", "/v1/embeddings": "sk-1"} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(`{"model":"phi","data":"hi","input":"hi","messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		res.Body.Close()
+
+		assert.Equal(t, http.StatusTooManyRequests, res.StatusCode, path)
+
+		retryAfter, err := strconv.Atoi(res.Header.Get("Retry-After"))
+		assert.NoError(t, err, path)
+		assert.InDelta(t, 60, retryAfter, 1, path)
+	}
+}
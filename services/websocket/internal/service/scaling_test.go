@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"pkg/auth"
+	"pkg/kafka/memory"
+	"pkg/notification"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// instance starts a websocket service replica consuming notifications from the broker
+func instance(t *testing.T, broker *memory.Broker, group string) *Service {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	client := broker.Client(group, config)
+
+	producer, err := client.SetupProducer()
+	assert.NoError(t, err)
+
+	consumer, err := client.SetupConsumer()
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		consumer.Close()
+		producer.Close()
+	})
+
+	service := New(consumer, producer, []string{"chat", "notification"}, "http://localhost:8080", "http://localhost:8081").(*Service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go service.Listen(ctx)
+	go service.Consume(ctx)
+
+	return service
+}
+
+// connectUser registers a connection of the user with the replica
+func connectUser(service *Service, user string) *Client {
+	client := &Client{
+		send: newOutbound(PolicyBlock, 256, time.Second),
+		session: newSession("token", &auth.Claims{
+			UserID: user,
+		}),
+	}
+
+	service.register <- client
+
+	return client
+}
+
+// await returns the data of the next notification delivered to the client
+func await(client *Client) (string, bool) {
+	data, ok := client.send.pop(time.After(time.Second))
+
+	if !ok {
+		return "", false
+	}
+
+	frame := map[string]interface{}{}
+	json.Unmarshal(data, &frame)
+
+	return frame["data"].(string), true
+}
+
+func TestMultipleInstances(t *testing.T) {
+	broker := memory.NewBroker()
+
+	replicas := []*Service{
+		instance(t, broker, "websocket-group-a"),
+		instance(t, broker, "websocket-group-b"),
+		instance(t, broker, "websocket-group-c"),
+	}
+
+	// alice is connected to the first replica with two tabs, bob to the last one
+	alice := connectUser(replicas[0], "1")
+	aliceTab := connectUser(replicas[0], "1")
+	bob := connectUser(replicas[2], "2")
+
+	publish := func(audience notification.Audience, data string) {
+		event, _ := json.Marshal(notification.New(audience, data))
+
+		err := broker.Publish(&sarama.ProducerMessage{
+			Topic: "notification",
+			Value: sarama.ByteEncoder(event),
+		})
+
+		assert.NoError(t, err)
+	}
+
+	t.Run("user notification reaches the replica of the user", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			publish(notification.Audience{Type: notification.AudienceUser, ID: "2"}, "for bob")
+
+			data, ok := await(bob)
+
+			assert.True(t, ok)
+			assert.Equal(t, "for bob", data)
+		}
+
+		_, ok := await(alice)
+		assert.False(t, ok)
+	})
+
+	t.Run("user notification reaches every connection of the user", func(t *testing.T) {
+		publish(notification.Audience{Type: notification.AudienceUser, ID: "1"}, "for alice")
+
+		for _, client := range []*Client{alice, aliceTab} {
+			data, ok := await(client)
+
+			assert.True(t, ok)
+			assert.Equal(t, "for alice", data)
+		}
+	})
+
+	t.Run("everyone notification reaches every replica", func(t *testing.T) {
+		publish(notification.Audience{Type: notification.AudienceEveryone}, "for everyone")
+
+		for _, client := range []*Client{alice, aliceTab, bob} {
+			data, ok := await(client)
+
+			assert.True(t, ok)
+			assert.Equal(t, "for everyone", data)
+		}
+	})
+}
+
+func TestPublishWithoutConnections(t *testing.T) {
+	service := instance(t, memory.NewBroker(), "websocket")
+
+	// the results of the messages are read even when no client is connected, the producer would
+	// block every publish once its buffers are full otherwise
+	published := make(chan struct{})
+
+	go func() {
+		for i := 0; i < 1024; i++ {
+			service.publish("hello")
+		}
+
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked")
+	}
+}
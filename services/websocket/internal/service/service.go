@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,101 +13,389 @@ import (
 	"pkg/ai"
 	"pkg/auth"
 	"pkg/kafka"
+	"pkg/notification"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"websocket/internal/moderation"
+	"websocket/internal/store"
+	"websocket/internal/tools"
 
 	"github.com/IBM/sarama"
 	"github.com/gorilla/websocket"
-	"github.com/rifaideen/talkative"
 )
 
+// errUnauthorized is returned when the request carries no valid token
+var errUnauthorized = errors.New("invalid or missing token")
+
 type WebsocketService interface {
-	Consume() error
+	Consume(ctx context.Context) error
+	Documents() http.Handler
 	Listen(ctx context.Context)
+	OpenAI() http.Handler
+	Personas() http.Handler
+	ServeChat(w http.ResponseWriter, r *http.Request)
+	ServeNotifications(w http.ResponseWriter, r *http.Request)
 	ServeWS(w http.ResponseWriter, r *http.Request)
+	Templates() http.Handler
+	Uploads() http.Handler
+	Usage() http.Handler
+	Shutdown(ctx context.Context) error
 	Verify(token string) (bool, error)
 }
 
-// Service maintains the set of active clients and broadcasts messages to them.
+// Service maintains the set of active clients and delivers notifications to them.
 type Service struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.Mutex
+	clients       map[*Client]bool
+	users         map[string]map[*Client]bool // open connections indexed by user id
+	notifications chan *notification.Notification
+	register      chan *Client
+	unregister    chan *Client
+	mu            sync.Mutex
+
+	producer         sarama.AsyncProducer
+	consumer         sarama.ConsumerGroup
+	topicConsumer    string
+	topicProducer    string
+	authServiceUrl   string
+	ai               *ai.AI                  // ollama client managing the local models
+	provider         ai.Provider             // provider of the chats, selected per model
+	allowedModels    []string                // patterns of the models users can chat with, every model when empty
+	managers         []string                // roles allowed to pull, delete and copy the local models
+	limits           map[string]Limits       // limits of the generation parameters per role
+	quotas           map[string]Quota        // daily quotas per role
+	rates            map[string]RateLimits   // rates of the frames per role
+	limiter          *limiter                // buckets of the rate limits of the users
+	apiKeys          map[string]*auth.Claims // identities of the api keys accepted by the openai compatible api
+	store            store.Store             // personas, conversations, documents and usage of the users
+	maxAttachments   int                     // number of images attached to a chat at most
+	attachmentSize   int64                   // size of an image attached to a chat at most, in bytes
+	uploadSize       int64                   // size of an uploaded file at most, in bytes
+	tools            *tools.Registry         // tools the assistant can call during chats
+	moderation       *moderation.Pipeline    // filters of the prompts and replies, everything is accepted when nil
+	topicAudit       string                  // topic the moderation violations are recorded to, not recorded when empty
+	embeddingModel   string                  // model computing the embeddings of the documents and prompts
+	retrievalResults int                     // number of chunks of the documents injected into the prompts
+	chunkSize        int                     // characters per chunk of the documents
+	chunkOverlap     int                     // characters repeated from the previous chunk
+	summaryThreshold int                     // estimated tokens of the history of a conversation summarized beyond, disabled when zero
+	summaryKeep      int                     // number of the last messages of a conversation kept as they are
+	summaryModel     string                  // model writing the summaries, the model of the conversation when empty
+	summarizing      sync.Map                // conversations being summarized
+	titleModel       string                  // model naming the conversations, the model of the conversation when empty
+	reauthWindow     time.Duration           // how long before token expiry clients are asked to re-authenticate
+	verifyInterval   time.Duration           // how often tokens of connected clients are re-verified, disabled when zero
+	streams          *streams                // recent streamed responses which can be resumed after reconnecting
+	pulls            *pulls                  // model pulls in progress, shared by the users requesting the model
+	policy           Policy                  // what to do with outbound frames of clients which are not keeping up
+	bufferSize       int                     // number of outbound frames queued per connection
+	blockTimeout     time.Duration           // how long the block policy waits for room in the queue
+
+	ctx      context.Context    // context of the chats and model requests, cancelled when abandoned on shutdown
+	abandon  context.CancelFunc // cancels the chats and model requests in progress
+	inflight sync.WaitGroup     // chats and pulls in progress
+	draining atomic.Bool        // set once the service is shutting down
+}
+
+// Option configures the optional behaviour of the Service
+type Option func(*Service)
 
-	producer       sarama.AsyncProducer
-	consumer       sarama.ConsumerGroup
-	topicConsumer  string
-	topicProducer  string
-	authServiceUrl string
-	ollama         *talkative.Client
-	ai             *ai.AI
+// WithReauthWindow sets how long before token expiry clients are asked to re-authenticate
+func WithReauthWindow(window time.Duration) Option {
+	return func(s *Service) {
+		s.reauthWindow = window
+	}
 }
 
-// New initializes and returns a new Service.
-func New(consumer kafka.Consumer, producer kafka.Producer, topics []string, authServiceUrl, ollamaServiceUrl string) WebsocketService {
-	client, err := talkative.New(ollamaServiceUrl)
+// WithStreams sets how many frames of a streamed response are buffered and how long
+// finished responses can be resumed after reconnecting
+func WithStreams(capacity int, retention time.Duration) Option {
+	return func(s *Service) {
+		s.streams = newStreams(capacity, retention)
+	}
+}
 
-	if err != nil {
-		log.Fatal(err)
+// WithOutbound sets the slow consumer policy applied to outbound frames, the number of frames
+// queued per connection and how long the block policy waits for room
+func WithOutbound(policy Policy, bufferSize int, blockTimeout time.Duration) Option {
+	return func(s *Service) {
+		s.policy = policy
+		s.bufferSize = bufferSize
+		s.blockTimeout = blockTimeout
+	}
+}
+
+// WithPulls sets how many models are pulled at once, how many pulls can wait in the queue and
+// how many pulls a user can start at once, unlimited when zero
+func WithPulls(parallelism, queueSize, perUser int) Option {
+	return func(s *Service) {
+		s.pulls = newPulls(parallelism, queueSize, perUser)
+	}
+}
+
+// WithAI sets the client of the ollama api used for model management, and for chats unless
+// another provider is set
+func WithAI(client *ai.AI) Option {
+	return func(s *Service) {
+		s.ai = client
+	}
+}
+
+// WithProvider sets the provider of the chats and the models offered to chat with
+func WithProvider(provider ai.Provider) Option {
+	return func(s *Service) {
+		s.provider = provider
+	}
+}
+
+// WithAllowedModels restricts the models users can chat with to the ones matching the patterns,
+// see path.Match for the syntax of the patterns
+func WithAllowedModels(patterns ...string) Option {
+	return func(s *Service) {
+		s.allowedModels = patterns
+	}
+}
+
+// WithModelManagers sets the roles allowed to pull, delete and copy the local models, admin only by default
+func WithModelManagers(roles ...string) Option {
+	return func(s *Service) {
+		s.managers = roles
+	}
+}
+
+// WithLimits sets the limits of the generation parameters clients can set per role, the limits of
+// the "*" role apply to the roles without limits of their own
+func WithLimits(limits map[string]Limits) Option {
+	return func(s *Service) {
+		s.limits = limits
+	}
+}
+
+// WithAPIKeys sets the api keys accepted by the openai compatible api along with the identity they act as
+func WithAPIKeys(keys map[string]*auth.Claims) Option {
+	return func(s *Service) {
+		s.apiKeys = keys
+	}
+}
+
+// WithQuotas sets how much the users can generate per day according to their role, the quota of
+// the "*" role applies to the roles without a quota of their own
+func WithQuotas(quotas map[string]Quota) Option {
+	return func(s *Service) {
+		s.quotas = quotas
+	}
+}
+
+// WithRateLimits sets how fast the users can send frames according to their role, the limits of
+// the "*" role apply to the roles without limits of their own
+func WithRateLimits(limits map[string]RateLimits) Option {
+	return func(s *Service) {
+		s.rates = limits
+	}
+}
+
+// WithStore sets where the personas, conversations and uploads of the users are kept, in memory by default
+func WithStore(store store.Store) Option {
+	return func(s *Service) {
+		s.store = store
+	}
+}
+
+// WithAttachments sets how many images can be attached to a chat and how large they can be in bytes
+func WithAttachments(maxCount int, maxSize int64) Option {
+	return func(s *Service) {
+		s.maxAttachments = maxCount
+		s.attachmentSize = maxSize
+	}
+}
+
+// WithUploadSize sets how large the uploaded files can be in bytes
+func WithUploadSize(maxSize int64) Option {
+	return func(s *Service) {
+		s.uploadSize = maxSize
+	}
+}
+
+// WithTools sets the tools the assistant can call during chats, none by default
+func WithTools(registry *tools.Registry) Option {
+	return func(s *Service) {
+		s.tools = registry
+	}
+}
+
+// WithModeration sets the filters of the prompts and replies, and the topic the violations are recorded to
+func WithModeration(pipeline *moderation.Pipeline, topic string) Option {
+	return func(s *Service) {
+		s.moderation = pipeline
+		s.topicAudit = topic
+	}
+}
+
+// WithSummaries summarizes the earlier messages of the conversations whose history is estimated
+// beyond threshold tokens, keeping the last keep messages as they are. The summaries are written by
+// model, or the model of the conversation when empty.
+func WithSummaries(threshold, keep int, model string) Option {
+	return func(s *Service) {
+		s.summaryThreshold = threshold
+		s.summaryKeep = keep
+		s.summaryModel = model
+	}
+}
+
+// WithTitles sets the model naming the conversations after their first exchange, the model of the
+// conversation names it when empty
+func WithTitles(model string) Option {
+	return func(s *Service) {
+		s.titleModel = model
+	}
+}
+
+// WithRetrieval sets the model embedding the documents and the prompts, along with how many chunks
+// of the documents are injected into the prompts of the conversations opting in to retrieval
+func WithRetrieval(model string, results int) Option {
+	return func(s *Service) {
+		s.embeddingModel = model
+		s.retrievalResults = results
+	}
+}
+
+// WithChunking sets how many characters the chunks of the documents hold and how many of them are
+// repeated from the previous chunk
+func WithChunking(size, overlap int) Option {
+	return func(s *Service) {
+		s.chunkSize = size
+		s.chunkOverlap = overlap
+	}
+}
+
+// WithVerifyInterval sets how often tokens of connected clients are re-verified with the auth service
+func WithVerifyInterval(interval time.Duration) Option {
+	return func(s *Service) {
+		s.verifyInterval = interval
 	}
+}
 
+// New initializes and returns a new Service.
+func New(consumer kafka.Consumer, producer kafka.Producer, topics []string, authServiceUrl, ollamaServiceUrl string, options ...Option) WebsocketService {
 	ai, err := ai.New(ollamaServiceUrl)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	return &Service{
-		clients:        make(map[*Client]bool),
-		broadcast:      make(chan []byte),
-		register:       make(chan *Client),
-		unregister:     make(chan *Client),
-		producer:       producer,
-		consumer:       consumer,
-		topicProducer:  topics[0],
-		topicConsumer:  topics[1],
-		authServiceUrl: authServiceUrl,
-		ollama:         client,
-		ai:             ai,
+	ctx, abandon := context.WithCancel(context.Background())
+
+	service := &Service{
+		clients:          make(map[*Client]bool),
+		users:            make(map[string]map[*Client]bool),
+		notifications:    make(chan *notification.Notification),
+		register:         make(chan *Client),
+		unregister:       make(chan *Client),
+		producer:         producer,
+		consumer:         consumer,
+		topicProducer:    topics[0],
+		topicConsumer:    topics[1],
+		authServiceUrl:   authServiceUrl,
+		ai:               ai,
+		ctx:              ctx,
+		abandon:          abandon,
+		reauthWindow:     5 * time.Minute,
+		verifyInterval:   time.Minute,
+		streams:          newStreams(1024, 5*time.Minute),
+		pulls:            newPulls(2, 16, 2),
+		limiter:          newLimiter(),
+		managers:         []string{"admin"},
+		store:            store.NewMemory(),
+		maxAttachments:   4,
+		attachmentSize:   5 << 20,
+		uploadSize:       10 << 20,
+		tools:            tools.NewRegistry(),
+		embeddingModel:   "nomic-embed-text",
+		retrievalResults: 4,
+		chunkSize:        1000,
+		chunkOverlap:     200,
+		summaryThreshold: 3000,
+		summaryKeep:      6,
+		policy:           PolicyBlock,
+		bufferSize:       256,
+		blockTimeout:     5 * time.Second,
+	}
+
+	for _, option := range options {
+		option(service)
+	}
+
+	if service.provider == nil {
+		// chat with the local models of ollama
+		service.provider = service.ai
 	}
+
+	return service
 }
 
-// Listen starts the service to handle client registration, unregistration, and broadcasting messages.
+// Listen starts the service to handle client registration, unregistration, and delivering notifications.
 func (m *Service) Listen(ctx context.Context) {
+	// the producer blocks every publish once its results pile up, they are read until it is closed
+	go m.acknowledge()
+
+	// periodically forget the streamed responses which can no longer be resumed
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case client := <-m.register:
 			m.mu.Lock()
 			m.clients[client] = true
+
+			if user := client.session.userID(); user != "" {
+				if m.users[user] == nil {
+					m.users[user] = make(map[*Client]bool)
+				}
+
+				m.users[user][client] = true
+			}
+
 			m.mu.Unlock()
 
 			log.Println("New client connected")
 
 		case client := <-m.unregister:
 			m.mu.Lock()
-
-			if _, ok := m.clients[client]; ok {
-				delete(m.clients, client)
-				close(client.send)
-			}
-
+			m.remove(client)
 			m.mu.Unlock()
+
 			log.Println("Client disconnected")
 
-		case message := <-m.broadcast:
-			m.mu.Lock()
+		case notification := <-m.notifications:
+			data := []byte(notification.Frame)
+
+			if len(data) == 0 {
+				var err error
 
-			for client := range m.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(m.clients, client)
+				data, err = json.Marshal(map[string]interface{}{
+					"type":  "notification",
+					"title": notification.Title,
+					"data":  notification.Data,
+				})
+
+				if err != nil {
+					slog.Error("error marshalling notification", "error", err)
+					continue
 				}
 			}
 
+			m.mu.Lock()
+			recipients := m.recipients(notification)
 			m.mu.Unlock()
+
+			for _, client := range recipients {
+				client.notify(data)
+			}
+		case <-ticker.C:
+			m.streams.prune()
+			m.limiter.prune()
 		case <-ctx.Done():
 			log.Println("Context cancelled, stopping service")
 			return
@@ -114,7 +403,56 @@ func (m *Service) Listen(ctx context.Context) {
 	}
 }
 
+// remove the client from the set of active clients and close its send channel.
+// The caller must hold the lock.
+func (m *Service) remove(client *Client) {
+	if _, ok := m.clients[client]; !ok {
+		return
+	}
+
+	delete(m.clients, client)
+	client.close()
+
+	user := client.session.userID()
+
+	if connections, ok := m.users[user]; ok {
+		delete(connections, client)
+
+		if len(connections) == 0 {
+			delete(m.users, user)
+		}
+	}
+}
+
+// recipients returns the open connections the notification is intended for.
+// The caller must hold the lock.
+func (m *Service) recipients(n *notification.Notification) []*Client {
+	var clients []*Client
+
+	switch n.Audience.Type {
+	case notification.AudienceUser:
+		// every open connection of the user
+		for client := range m.users[n.Audience.ID] {
+			clients = append(clients, client)
+		}
+	default:
+		for client := range m.clients {
+			if n.Audience.Includes(client.session.identity()) {
+				clients = append(clients, client)
+			}
+		}
+	}
+
+	return clients
+}
+
 func (m *Service) ServeWS(w http.ResponseWriter, r *http.Request) {
+	if m.draining.Load() {
+		// let the client reconnect to another instance
+		http.Error(w, "service is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true
@@ -129,49 +467,99 @@ func (m *Service) ServeWS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// verify the token
-	token := r.URL.Query().Get("token")
-	valid, err := m.Verify(token)
+	token := credentials(r)
+	claims, err := m.identify(token)
 
-	if err != nil {
+	if errors.Is(err, errUnauthorized) {
 		conn.WriteJSON(map[string]string{
-			"error": "unable to verify token",
+			"error": "Invalid token",
 		})
 		conn.Close()
 
-		log.Println("Error verifying token:", err)
+		log.Println("Invalid token")
 		return
 	}
 
-	if !valid {
+	if err != nil {
 		conn.WriteJSON(map[string]string{
-			"error": "Invalid token",
+			"error": "unable to verify token",
 		})
 		conn.Close()
 
-		log.Println("Invalid token")
+		log.Println("Error verifying token:", err)
 		return
 	}
 
 	client := &Client{
-		conn: conn,
-		send: make(chan []byte, 256),
+		conn:    conn,
+		send:    newOutbound(m.policy, m.bufferSize, m.blockTimeout),
+		session: newSession(token, claims),
+		renewed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		flushed: make(chan struct{}),
 	}
 
 	m.register <- client
 
-	// start the read and write goroutines
+	// start the read and write goroutines along with the token lifecycle watcher
 	go client.read(m)
 	go client.write()
+	go client.watch(m)
+}
+
+// credentials returns the token of the request, given either as a bearer token or in the token
+// query parameter for the clients which cannot set headers such as browsers opening a websocket
+func credentials(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+// identify verifies the token with the auth service and returns the identity it carries.
+// It returns errUnauthorized when the token is missing or invalid.
+func (m *Service) identify(token string) (*auth.Claims, error) {
+	if token == "" {
+		return nil, errUnauthorized
+	}
+
+	verification, err := m.verify(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !verification.Valid {
+		return nil, errUnauthorized
+	}
+
+	if verification.Claims == nil {
+		return &auth.Claims{}, nil
+	}
+
+	return verification.Claims, nil
 }
 
 // verify the token with auth service and return the result
 func (m *Service) Verify(token string) (bool, error) {
+	verification, err := m.verify(token)
+
+	if err != nil {
+		return false, err
+	}
+
+	return verification.Valid, nil
+}
+
+// verify the token with auth service and return the verification along with the token claims
+func (m *Service) verify(token string) (*auth.VerifyResponse, error) {
 	data, err := json.Marshal(auth.VerifyRequest{
 		Token: token,
 	})
 
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	url := fmt.Sprintf("%s/auth/verify", m.authServiceUrl)
@@ -179,26 +567,26 @@ func (m *Service) Verify(token string) (bool, error) {
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(data)))
 
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	client := &http.Client{}
 	response, err := client.Do(req)
 
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		return false, nil
+		return &auth.VerifyResponse{}, nil
 	}
 
 	body, err := io.ReadAll(response.Body)
 
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	verification := auth.VerifyResponse{}
@@ -206,20 +594,70 @@ func (m *Service) Verify(token string) (bool, error) {
 	err = json.Unmarshal(body, &verification)
 
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	if verification.Error != "" {
-		return false, fmt.Errorf("%s", verification.Error)
+		return nil, fmt.Errorf("%s", verification.Error)
 	}
 
-	return verification.Valid, nil
+	return &verification, nil
 }
 
-func (m *Service) Consume() error {
+// acknowledge reads the results of the messages sent to kafka until the producer is closed
+func (m *Service) acknowledge() {
+	successes, failures := m.producer.Successes(), m.producer.Errors()
+
+	for successes != nil || failures != nil {
+		select {
+		case _, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+
+			slog.Debug("message sent successfully")
+		case err, ok := <-failures:
+			if !ok {
+				failures = nil
+				continue
+			}
+
+			slog.Error("error sending message", "error", err)
+		}
+	}
+
+	slog.Info("producer channel closed")
+}
+
+// publish the chat message to the producer topic in kafka for the history
+func (m *Service) publish(message string) {
+	m.producer.Input() <- &sarama.ProducerMessage{
+		Topic: m.topicProducer,
+		Value: sarama.StringEncoder(message),
+	}
+}
+
+// Consume notifications from kafka until the context is cancelled or the consumer group is closed
+func (m *Service) Consume(ctx context.Context) error {
 	slog.Info("cosuming messages from kafka", "topic", m.topicConsumer)
 
-	return m.consumer.Consume(context.Background(), []string{m.topicConsumer}, &Consumer{
-		manager: m,
-	})
+	for {
+		// a session ends whenever the group rebalances, join the next one until cancelled
+		err := m.consumer.Consume(ctx, []string{m.topicConsumer}, &Consumer{
+			manager: m,
+		})
+
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
 }
@@ -8,9 +8,11 @@ import (
 	"net/http/httptest"
 	"pkg/auth"
 	mock_kafka "pkg/kafka/mocks"
+	"pkg/notification"
 	"testing"
 	"time"
 
+	"github.com/IBM/sarama"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -20,6 +22,8 @@ func TestListen(t *testing.T) {
 	defer ctrl.Finish()
 
 	mock_producer := mock_kafka.NewMockProducer(ctrl)
+	mock_producer.EXPECT().Successes().AnyTimes()
+	mock_producer.EXPECT().Errors().AnyTimes()
 	mock_consumer := mock_kafka.NewMockConsumer(ctrl)
 	topics := []string{"test-consumer", "test-producer"}
 
@@ -121,16 +125,152 @@ func TestConsume(t *testing.T) {
 	service := New(mock_consumer, mock_producer, topics, authServiceUrl, ollamaServiceUrl)
 
 	t.Run("consume success", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// the first session ends with a rebalance, the second one with the cancellation
 		mock_consumer.EXPECT().Consume(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+		mock_consumer.EXPECT().Consume(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+				cancel()
+				return nil
+			},
+		)
 
-		err := service.Consume()
+		err := service.Consume(ctx)
 
 		assert.NoError(t, err)
 	})
 
+	t.Run("consume closed", func(t *testing.T) {
+		mock_consumer.EXPECT().Consume(gomock.Any(), gomock.Any(), gomock.Any()).Return(sarama.ErrClosedConsumerGroup)
+		err := service.Consume(context.Background())
+		assert.NoError(t, err)
+	})
+
 	t.Run("consume error", func(t *testing.T) {
 		mock_consumer.EXPECT().Consume(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("error"))
-		err := service.Consume()
+		err := service.Consume(context.Background())
 		assert.Error(t, err)
 	})
 }
+
+func TestNotifications(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock_producer := mock_kafka.NewMockProducer(ctrl)
+	mock_producer.EXPECT().Successes().AnyTimes()
+	mock_producer.EXPECT().Errors().AnyTimes()
+	mock_consumer := mock_kafka.NewMockConsumer(ctrl)
+	topics := []string{"test-consumer", "test-producer"}
+	authServiceUrl := "http://localhost:8080"
+	ollamaServiceUrl := "http://localhost:8081"
+
+	service := New(mock_consumer, mock_producer, topics, authServiceUrl, ollamaServiceUrl).(*Service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go service.Listen(ctx)
+
+	client := func(user, org, role string) *Client {
+		client := &Client{
+			send: newOutbound(PolicyBlock, 256, time.Second),
+			session: newSession("token", &auth.Claims{
+				UserID: user,
+				Org:    org,
+				Role:   role,
+			}),
+		}
+
+		service.register <- client
+
+		return client
+	}
+
+	alice := client("1", "acme", "admin")
+	aliceTab := client("1", "acme", "admin")
+	bob := client("2", "acme", "member")
+	carol := client("3", "globex", "member")
+
+	// received drains the notifications delivered to the client
+	received := func(client *Client) []string {
+		var messages []string
+
+		for {
+			data, ok := client.send.pop(time.After(100 * time.Millisecond))
+
+			if !ok {
+				return messages
+			}
+
+			frame := map[string]interface{}{}
+			json.Unmarshal(data, &frame)
+
+			messages = append(messages, frame["data"].(string))
+		}
+	}
+
+	t.Run("user audience", func(t *testing.T) {
+		service.notifications <- notification.New(notification.Audience{Type: notification.AudienceUser, ID: "1"}, "for alice")
+
+		assert.Equal(t, []string{"for alice"}, received(alice))
+		assert.Equal(t, []string{"for alice"}, received(aliceTab))
+		assert.Empty(t, received(bob))
+		assert.Empty(t, received(carol))
+	})
+
+	t.Run("org audience", func(t *testing.T) {
+		service.notifications <- notification.New(notification.Audience{Type: notification.AudienceOrg, ID: "acme"}, "for acme")
+
+		assert.Equal(t, []string{"for acme"}, received(alice))
+		assert.Equal(t, []string{"for acme"}, received(aliceTab))
+		assert.Equal(t, []string{"for acme"}, received(bob))
+		assert.Empty(t, received(carol))
+	})
+
+	t.Run("role audience", func(t *testing.T) {
+		service.notifications <- notification.New(notification.Audience{Type: notification.AudienceRole, ID: "member"}, "for members")
+
+		assert.Empty(t, received(alice))
+		assert.Empty(t, received(aliceTab))
+		assert.Equal(t, []string{"for members"}, received(bob))
+		assert.Equal(t, []string{"for members"}, received(carol))
+	})
+
+	t.Run("legacy plain text for everyone", func(t *testing.T) {
+		service.notifications <- notification.Parse([]byte("for everyone"))
+
+		for _, client := range []*Client{alice, aliceTab, bob, carol} {
+			assert.Equal(t, []string{"for everyone"}, received(client))
+		}
+	})
+
+	t.Run("slow connection does not hold up the others", func(t *testing.T) {
+		slow := &Client{
+			send:    newOutbound(PolicyBlock, 1, time.Hour),
+			session: newSession("token", &auth.Claims{UserID: "4", Org: "acme", Role: "member"}),
+		}
+
+		service.register <- slow
+		defer func() { service.unregister <- slow }()
+
+		slow.send.push([]byte("{}"))
+
+		service.notifications <- notification.New(notification.Audience{Type: notification.AudienceOrg, ID: "acme"}, "for acme")
+
+		assert.Equal(t, []string{"for acme"}, received(alice))
+		assert.Equal(t, []string{"for acme"}, received(aliceTab))
+		assert.Equal(t, []string{"for acme"}, received(bob))
+		assert.Equal(t, 1, slow.send.len())
+	})
+
+	t.Run("unregistered connection", func(t *testing.T) {
+		service.unregister <- aliceTab
+
+		service.notifications <- notification.New(notification.Audience{Type: notification.AudienceUser, ID: "1"}, "for alice")
+
+		assert.Equal(t, []string{"for alice"}, received(alice))
+	})
+}
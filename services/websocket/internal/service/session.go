@@ -0,0 +1,291 @@
+package service
+
+import (
+	"encoding/json"
+	"log/slog"
+	"pkg/auth"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeTokenExpired is the websocket close code sent when credentials lapse
+const closeTokenExpired = 4001
+
+// session tracks the credentials a client connection is authenticated with
+type session struct {
+	mu        sync.Mutex
+	token     string
+	claims    *auth.Claims
+	expiresAt time.Time // zero when the token carries no expiry
+	verified  time.Time // last successful verification with the auth service
+	notified  bool      // whether the client has been asked to re-authenticate
+}
+
+func newSession(token string, claims *auth.Claims) *session {
+	s := &session{}
+	s.renew(token, claims)
+
+	return s
+}
+
+// renew replaces the credentials of the session with a freshly verified token
+func (s *session) renew(token string, claims *auth.Claims) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = token
+	s.claims = claims
+	s.expiresAt = time.Time{}
+	s.verified = time.Now()
+	s.notified = false
+
+	if claims != nil && claims.ExpiresAt > 0 {
+		s.expiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+}
+
+// userID returns the id of the authenticated user, empty when unknown
+func (s *session) userID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.claims == nil {
+		return ""
+	}
+
+	return s.claims.UserID
+}
+
+// role returns the role of the authenticated user, empty when unknown
+func (s *session) role() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.claims == nil {
+		return ""
+	}
+
+	return s.claims.Role
+}
+
+// identity returns the claims of the authenticated user, nil when unknown
+func (s *session) identity() *auth.Claims {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.claims
+}
+
+// watch keeps track of the client's token lifecycle. It asks the client to re-authenticate
+// shortly before the token expires, periodically re-verifies the token to detect revocation
+// and closes the connection once the credentials lapse.
+func (c *Client) watch(manager *Service) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.renewed:
+			// credentials have changed, re-evaluate the schedule
+		case <-timer.C:
+		}
+
+		next, ok := c.check(manager)
+
+		if !ok {
+			return
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		timer.Reset(next)
+	}
+}
+
+// check evaluates the session state and returns the duration until the next check.
+// It returns false when the connection has been closed because of lapsed credentials.
+func (c *Client) check(manager *Service) (time.Duration, bool) {
+	s := c.session
+	now := time.Now()
+
+	s.mu.Lock()
+	token := s.token
+	expiresAt := s.expiresAt
+	verifyAt := s.verified.Add(manager.verifyInterval)
+	s.mu.Unlock()
+
+	if !expiresAt.IsZero() && !now.Before(expiresAt) {
+		c.expire("token expired")
+
+		return 0, false
+	}
+
+	if manager.verifyInterval > 0 && !now.Before(verifyAt) {
+		response, err := manager.verify(token)
+
+		switch {
+		case err != nil:
+			// the auth service may be temporarily unavailable, try again later
+			slog.Warn("unable to re-verify client token", "error", err)
+		case !response.Valid:
+			c.expire("token revoked")
+
+			return 0, false
+		}
+
+		s.mu.Lock()
+
+		// skip the update when the token has been renewed in the meantime
+		if s.token == token {
+			s.verified = now
+		}
+
+		verifyAt = s.verified.Add(manager.verifyInterval)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+
+	// wake up at the earliest of the pending deadlines
+	var deadlines []time.Time
+	var notify bool
+
+	if manager.verifyInterval > 0 {
+		deadlines = append(deadlines, verifyAt)
+	}
+
+	if !s.expiresAt.IsZero() {
+		reauthAt := s.expiresAt.Add(-manager.reauthWindow)
+
+		if !s.notified && !now.Before(reauthAt) {
+			s.notified = true
+			notify = true
+		}
+
+		if !s.notified {
+			deadlines = append(deadlines, reauthAt)
+		}
+
+		deadlines = append(deadlines, s.expiresAt)
+	}
+
+	expiresAt = s.expiresAt
+	s.mu.Unlock()
+
+	if notify {
+		c.notifyReauth(expiresAt)
+	}
+
+	if len(deadlines) == 0 {
+		// nothing to watch until the credentials change
+		return time.Hour, true
+	}
+
+	next := deadlines[0]
+
+	for _, deadline := range deadlines[1:] {
+		if deadline.Before(next) {
+			next = deadline
+		}
+	}
+
+	return time.Until(next), true
+}
+
+// notifyReauth asks the client to send a fresh token before the current one expires
+func (c *Client) notifyReauth(expiresAt time.Time) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":       "reauth_required",
+		"expires_at": expiresAt.Unix(),
+	})
+
+	if err != nil {
+		slog.Error("unable to marshal json response", "error", err)
+		return
+	}
+
+	c.deliver(data)
+}
+
+// expire closes the connection because its credentials are no longer valid
+func (c *Client) expire(reason string) {
+	slog.Info("closing connection with lapsed credentials", "reason", reason)
+
+	if c.conn == nil {
+		// end the event stream, the client reconnects with a fresh token
+		c.error("", reason)
+		c.close()
+
+		return
+	}
+
+	message := websocket.FormatCloseMessage(closeTokenExpired, reason)
+
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	c.conn.Close()
+}
+
+// reauthenticate verifies a fresh token sent over the existing connection and renews the session
+func (c *Client) reauthenticate(manager *Service, token string) {
+	response, err := manager.verify(token)
+
+	result := map[string]interface{}{
+		"type": "auth",
+	}
+
+	// a token without the identity of its user or with the identity of another user ends the session
+	var rejected string
+
+	switch {
+	case err != nil:
+		slog.Error("unable to verify token", "error", err)
+
+		result["error"] = "unable to verify token"
+	case !response.Valid:
+		result["error"] = "Invalid token"
+	case response.Claims == nil:
+		rejected = "token without claims"
+		result["error"] = rejected
+	case response.Claims.UserID != c.session.userID():
+		// a connection must not change hands between users
+		rejected = "token belongs to another user"
+		result["error"] = rejected
+	default:
+		c.session.renew(token, response.Claims)
+
+		result["data"] = "ok"
+
+		if response.Claims.ExpiresAt > 0 {
+			result["expires_at"] = response.Claims.ExpiresAt
+		}
+
+		select {
+		case c.renewed <- struct{}{}:
+		default:
+		}
+	}
+
+	data, err := json.Marshal(result)
+
+	if err != nil {
+		slog.Error("unable to marshal json response", "error", err)
+		return
+	}
+
+	c.deliver(data)
+
+	if rejected != "" {
+		// close the connection once the reply has been written
+		c.closeWith(websocket.FormatCloseMessage(closeTokenExpired, rejected))
+		c.close()
+	}
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"pkg/auth"
+	mock_kafka "pkg/kafka/mocks"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// authServer simulates the auth service, tokens are valid until revoked
+type authServer struct {
+	mu      sync.Mutex
+	expiry  map[string]time.Time
+	users   map[string]string
+	roles   map[string]string
+	bare    map[string]bool
+	revoked map[string]bool
+}
+
+func newAuthServer() *authServer {
+	return &authServer{
+		expiry:  make(map[string]time.Time),
+		users:   make(map[string]string),
+		roles:   make(map[string]string),
+		bare:    make(map[string]bool),
+		revoked: make(map[string]bool),
+	}
+}
+
+func (a *authServer) issue(token, user string, expiry time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expiry[token] = expiry
+	a.users[token] = user
+}
+
+func (a *authServer) grant(token, role string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.roles[token] = role
+}
+
+// strip verifies the token without the claims of its user
+func (a *authServer) strip(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.bare[token] = true
+}
+
+func (a *authServer) revoke(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.revoked[token] = true
+}
+
+func (a *authServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := auth.VerifyRequest{}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	expiry, ok := a.expiry[req.Token]
+
+	if !ok || a.revoked[req.Token] || time.Now().After(expiry) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(auth.VerifyResponse{Error: "invalid token"})
+
+		return
+	}
+
+	if a.bare[req.Token] {
+		json.NewEncoder(w).Encode(auth.VerifyResponse{Valid: true})
+		return
+	}
+
+	json.NewEncoder(w).Encode(auth.VerifyResponse{
+		Valid: true,
+		Claims: &auth.Claims{
+			UserID:    a.users[req.Token],
+			Username:  a.users[req.Token],
+			Role:      a.roles[req.Token],
+			ExpiresAt: expiry.Unix(),
+		},
+	})
+}
+
+// serve starts a websocket service backed by the auth server and the ollama handler and returns its url
+func serve(t *testing.T, authServer *authServer, ollama http.Handler, options ...Option) string {
+	ctrl := gomock.NewController(t)
+
+	mock_producer := mock_kafka.NewMockProducer(ctrl)
+	mock_consumer := mock_kafka.NewMockConsumer(ctrl)
+
+	_, url := serveWith(t, mock_consumer, mock_producer, authServer, ollama, options...)
+
+	return url
+}
+
+// serveWith starts a websocket service using the kafka consumer and producer and returns it along with its url
+func serveWith(t *testing.T, mock_consumer *mock_kafka.MockConsumer, mock_producer *mock_kafka.MockProducer, authServer *authServer, ollama http.Handler, options ...Option) (*Service, string) {
+	topics := []string{"test-consumer", "test-producer"}
+
+	mock_producer.EXPECT().Successes().AnyTimes()
+	mock_producer.EXPECT().Errors().AnyTimes()
+	mock_producer.EXPECT().Input().Return(make(chan *sarama.ProducerMessage, 256)).AnyTimes()
+
+	auth := httptest.NewServer(authServer)
+	t.Cleanup(auth.Close)
+
+	ollamaUrl := auth.URL
+
+	if ollama != nil {
+		server := httptest.NewServer(ollama)
+		t.Cleanup(server.Close)
+
+		ollamaUrl = server.URL
+	}
+
+	service := New(mock_consumer, mock_producer, topics, auth.URL, ollamaUrl, options...).(*Service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go service.Listen(ctx)
+
+	server := httptest.NewServer(http.HandlerFunc(service.ServeWS))
+	t.Cleanup(server.Close)
+
+	return service, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// dial connects to the websocket service with the token
+func dial(t *testing.T, url, token string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token="+token, nil)
+
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	return conn
+}
+
+// connect starts a websocket service backed by the auth server and dials it with the token
+func connect(t *testing.T, authServer *authServer, token string, options ...Option) *websocket.Conn {
+	return dial(t, serve(t, authServer, nil, options...), token)
+}
+
+// readFrame reads the next json frame from the connection
+func readFrame(t *testing.T, conn *websocket.Conn) (map[string]interface{}, error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	frame := map[string]interface{}{}
+	err := conn.ReadJSON(&frame)
+
+	return frame, err
+}
+
+func TestSession(t *testing.T) {
+	t.Run("reauth required before expiry", func(t *testing.T) {
+		authServer := newAuthServer()
+		authServer.issue("token", "1", time.Now().Add(3*time.Second))
+
+		conn := connect(t, authServer, "token", WithReauthWindow(time.Hour), WithVerifyInterval(0))
+
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "reauth_required", frame["type"])
+	})
+
+	t.Run("reauth with fresh token", func(t *testing.T) {
+		authServer := newAuthServer()
+		authServer.issue("token", "1", time.Now().Add(2*time.Second))
+		authServer.issue("fresh-token", "1", time.Now().Add(time.Hour))
+
+		conn := connect(t, authServer, "token", WithReauthWindow(time.Second), WithVerifyInterval(0))
+
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "reauth_required", frame["type"])
+
+		err = conn.WriteJSON(map[string]string{
+			"type": "auth",
+			"data": "fresh-token",
+		})
+
+		assert.NoError(t, err)
+
+		frame, err = readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "auth", frame["type"])
+		assert.Equal(t, "ok", frame["data"])
+
+		// the connection outlives the original token
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, _, err = conn.ReadMessage()
+
+		var netErr net.Error
+
+		assert.ErrorAs(t, err, &netErr)
+		assert.True(t, netErr.Timeout())
+	})
+
+	t.Run("reauth with token of another user", func(t *testing.T) {
+		authServer := newAuthServer()
+		authServer.issue("token", "1", time.Now().Add(time.Hour))
+		authServer.issue("other-token", "2", time.Now().Add(time.Hour))
+
+		conn := connect(t, authServer, "token", WithReauthWindow(time.Second), WithVerifyInterval(0))
+
+		err := conn.WriteJSON(map[string]string{
+			"type": "auth",
+			"data": "other-token",
+		})
+
+		assert.NoError(t, err)
+
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "auth", frame["type"])
+		assert.Equal(t, "token belongs to another user", frame["error"])
+
+		_, err = readFrame(t, conn)
+
+		assert.True(t, websocket.IsCloseError(err, closeTokenExpired))
+	})
+
+	t.Run("reauth with token without claims", func(t *testing.T) {
+		authServer := newAuthServer()
+		authServer.issue("token", "1", time.Now().Add(time.Hour))
+		authServer.issue("bare-token", "1", time.Now().Add(time.Hour))
+		authServer.strip("bare-token")
+
+		conn := connect(t, authServer, "token", WithReauthWindow(time.Second), WithVerifyInterval(0))
+
+		err := conn.WriteJSON(map[string]string{
+			"type": "auth",
+			"data": "bare-token",
+		})
+
+		assert.NoError(t, err)
+
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "auth", frame["type"])
+		assert.Equal(t, "token without claims", frame["error"])
+
+		_, err = readFrame(t, conn)
+
+		assert.True(t, websocket.IsCloseError(err, closeTokenExpired))
+	})
+
+	t.Run("close on expiry", func(t *testing.T) {
+		authServer := newAuthServer()
+		authServer.issue("token", "1", time.Now().Add(time.Second))
+
+		conn := connect(t, authServer, "token", WithReauthWindow(0), WithVerifyInterval(0))
+
+		_, err := readFrame(t, conn)
+
+		assert.True(t, websocket.IsCloseError(err, closeTokenExpired))
+	})
+
+	t.Run("close on revocation", func(t *testing.T) {
+		authServer := newAuthServer()
+		authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+		conn := connect(t, authServer, "token", WithReauthWindow(0), WithVerifyInterval(500*time.Millisecond))
+
+		// wait for the connection to be authenticated before revoking its token
+		conn.WriteJSON(map[string]string{
+			"type": "auth",
+			"data": "token",
+		})
+
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", frame["data"])
+
+		authServer.revoke("token")
+
+		_, err = readFrame(t, conn)
+
+		assert.True(t, websocket.IsCloseError(err, closeTokenExpired))
+	})
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// track runs the request in the background and keeps count of it, so shutdown can wait for it to complete
+func (m *Service) track(request func()) {
+	m.inflight.Add(1)
+
+	go func() {
+		defer m.inflight.Done()
+
+		request()
+	}()
+}
+
+// Shutdown drains the service. Connected clients are told to reconnect elsewhere and no new
+// requests are accepted, while the chats and pulls in progress are given until the context
+// deadline to complete. Finally the connections are closed along with the kafka consumer group
+// and producer.
+func (m *Service) Shutdown(ctx context.Context) error {
+	if m.draining.Swap(true) {
+		return nil
+	}
+
+	slog.Info("draining websocket connections")
+
+	data, err := json.Marshal(map[string]interface{}{
+		"type": "reconnect",
+		"data": "service is shutting down, please reconnect",
+	})
+
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+
+	clients := make([]*Client, 0, len(m.clients))
+
+	for client := range m.clients {
+		clients = append(clients, client)
+	}
+
+	m.mu.Unlock()
+
+	for _, client := range clients {
+		client.deliver(data)
+	}
+
+	// wait for the requests in progress up to the deadline
+	completed := make(chan struct{})
+
+	go func() {
+		m.inflight.Wait()
+		close(completed)
+	}()
+
+	select {
+	case <-completed:
+		slog.Info("requests in progress completed")
+	case <-ctx.Done():
+		slog.Warn("drain deadline exceeded, abandoning requests in progress", "error", ctx.Err())
+
+		m.abandon()
+		m.pulls.abort()
+	}
+
+	message := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "service is shutting down")
+
+	m.mu.Lock()
+
+	clients = clients[:0]
+
+	for client := range m.clients {
+		// close the connection once the queued frames have been written
+		client.closeWith(message)
+		m.remove(client)
+
+		clients = append(clients, client)
+	}
+
+	m.mu.Unlock()
+
+	// give the write loops a moment to flush, they run concurrently so the wait is shared
+	flush := time.NewTimer(time.Second)
+	defer flush.Stop()
+
+	for _, client := range clients {
+		select {
+		case <-client.flushed:
+		case <-flush.C:
+			// the remaining clients are not reading, close their connections regardless
+			flush.Reset(0)
+			client.abort()
+		}
+	}
+
+	if err := m.consumer.Close(); err != nil {
+		slog.Error("error closing consumer group", "error", err)
+	}
+
+	return m.producer.Close()
+}
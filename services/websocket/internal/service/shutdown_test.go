@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	mock_kafka "pkg/kafka/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock_producer := mock_kafka.NewMockProducer(ctrl)
+	mock_consumer := mock_kafka.NewMockConsumer(ctrl)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	words := make(chan string)
+	service, url := serveWith(t, mock_consumer, mock_producer, authServer, ollamaChat(words), WithVerifyInterval(0))
+
+	conn := dial(t, url, "token")
+
+	conn.WriteJSON(map[string]string{
+		"type":       "chat",
+		"data":       "hello",
+		"request_id": "request-1",
+	})
+
+	words <- "Hello"
+
+	frame, err := readFrame(t, conn)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", frame["data"])
+
+	// the consumer group and producer are closed once drained
+	mock_consumer.EXPECT().Close().Return(nil)
+	mock_producer.EXPECT().Close().Return(nil)
+
+	shutdown := make(chan error)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		shutdown <- service.Shutdown(ctx)
+	}()
+
+	frame, err = readFrame(t, conn)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "reconnect", frame["type"])
+
+	t.Run("new requests are rejected while draining", func(t *testing.T) {
+		conn.WriteJSON(map[string]string{
+			"type": "chat",
+			"data": "hello again",
+		})
+
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "error", frame["type"])
+
+		_, _, err = websocket.DefaultDialer.Dial(url+"?token=token", nil)
+
+		assert.Error(t, err)
+	})
+
+	t.Run("chats in progress complete before closing", func(t *testing.T) {
+		words <- " world"
+		close(words)
+
+		for {
+			frame, err := readFrame(t, conn)
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			if frame["done"] == true {
+				break
+			}
+		}
+
+		assert.NoError(t, <-shutdown)
+
+		_, err := readFrame(t, conn)
+
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseServiceRestart))
+	})
+}
+
+func TestShutdownEventStreams(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mock_producer := mock_kafka.NewMockProducer(ctrl)
+	mock_consumer := mock_kafka.NewMockConsumer(ctrl)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	service, _ := serveWith(t, mock_consumer, mock_producer, authServer, nil, WithVerifyInterval(0))
+
+	server := httptest.NewServer(http.HandlerFunc(service.ServeNotifications))
+	t.Cleanup(server.Close)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Authorization", "Bearer token")
+
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+
+	assert.Eventually(t, func() bool {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+
+		return len(service.users["1"]) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	mock_consumer.EXPECT().Close().Return(nil)
+	mock_producer.EXPECT().Close().Return(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, service.Shutdown(ctx))
+
+	// the event streams end once the service has drained, so the http server can stop right away
+	ended := make(chan error)
+
+	go func() {
+		_, err := io.ReadAll(res.Body)
+		ended <- err
+	}()
+
+	select {
+	case err := <-ended:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the event stream is still open")
+	}
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pkg/auth"
+	"time"
+	"websocket/internal/model"
+)
+
+// heartbeat is how often an idle event stream sends a comment, so proxies keep it open
+const heartbeat = 30 * time.Second
+
+// sse authenticates the request and prepares the response for an event stream. It returns a client
+// whose frames are written as events by stream, or nil when the request has been rejected.
+func (m *Service) sse(w http.ResponseWriter, r *http.Request) *Client {
+	if m.draining.Load() {
+		// let the client reconnect to another instance
+		http.Error(w, "service is shutting down", http.StatusServiceUnavailable)
+		return nil
+	}
+
+	token := credentials(r)
+	claims, err := m.identify(token)
+
+	if err != nil {
+		if !errors.Is(err, errUnauthorized) {
+			slog.Error("unable to verify token", "error", err)
+		}
+
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+
+		return nil
+	}
+
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	return m.eventClient(token, claims)
+}
+
+// eventClient creates a client without a websocket connection, its frames are written as events
+func (m *Service) eventClient(token string, claims *auth.Claims) *Client {
+	return &Client{
+		send:    newOutbound(m.policy, m.bufferSize, m.blockTimeout),
+		session: newSession(token, claims),
+		renewed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		flushed: make(chan struct{}),
+	}
+}
+
+// stream writes the queued frames of the client as events until its queue is closed and drained
+// or the request is cancelled
+func (c *Client) stream(w http.ResponseWriter, r *http.Request) {
+	defer close(c.flushed)
+
+	flusher := w.(http.Flusher)
+
+	// the client went away, stop queueing frames for it
+	stop := context.AfterFunc(r.Context(), c.close)
+	defer stop()
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		message, ok := c.send.pop(time.After(heartbeat))
+
+		if !ok {
+			if c.send.drained() {
+				return
+			}
+
+			// keep the idle stream open
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", message); err != nil {
+			slog.Error("error writing event", "error", err)
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// ServeChat streams the response to the chat message posted in the body as server-sent events,
+// each event carrying the same frame a websocket chat produces
+func (m *Service) ServeChat(w http.ResponseWriter, r *http.Request) {
+	message := &model.Message{}
+
+	if err := json.NewDecoder(r.Body).Decode(message); err != nil || (message.Data == "" && message.Template == "") {
+		http.Error(w, "invalid chat message", http.StatusBadRequest)
+		return
+	}
+
+	client := m.sse(w, r)
+
+	if client == nil {
+		return
+	}
+
+	if m.throttled(w, client.session.identity(), func(w http.ResponseWriter, status int, message string) {
+		http.Error(w, message, status)
+	}) {
+		return
+	}
+
+	if message.Template != "" {
+		if err := m.instantiate(r.Context(), client.session.identity(), message); err != nil {
+			status := http.StatusBadRequest
+
+			if errors.Is(err, errTemplateNotFound) {
+				status = http.StatusNotFound
+			}
+
+			http.Error(w, err.Error(), status)
+
+			return
+		}
+	}
+
+	slog.Info("received message from client", "chat", message.Data)
+
+	// forward the message to the producer topic in kafka and then initiate a chat
+	m.publish(message.Data)
+
+	// the generation continues even if the client goes away, it can be resumed over a websocket
+	m.track(func() {
+		defer close(client.done)
+
+		client.chat(m, message)
+		client.close()
+	})
+
+	client.stream(w, r)
+}
+
+// ServeNotifications streams the notifications of the user as server-sent events until the
+// client goes away or its credentials lapse
+func (m *Service) ServeNotifications(w http.ResponseWriter, r *http.Request) {
+	client := m.sse(w, r)
+
+	if client == nil {
+		return
+	}
+
+	m.register <- client
+
+	defer func() {
+		close(client.done)
+		m.unregister <- client
+	}()
+
+	go client.watch(m)
+
+	client.stream(w, r)
+}
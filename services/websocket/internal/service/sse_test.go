@@ -0,0 +1,139 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pkg/ai"
+	mock_kafka "pkg/kafka/mocks"
+	"pkg/notification"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// events reads the frames of the event stream
+func events(t *testing.T, res *http.Response) <-chan map[string]interface{} {
+	frames := make(chan map[string]interface{})
+
+	go func() {
+		defer close(frames)
+
+		scanner := bufio.NewScanner(res.Body)
+
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+
+			if !ok {
+				continue
+			}
+
+			frame := map[string]interface{}{}
+
+			if err := json.Unmarshal([]byte(data), &frame); err != nil {
+				t.Errorf("invalid event %q: %v", data, err)
+				return
+			}
+
+			frames <- frame
+		}
+	}()
+
+	return frames
+}
+
+func TestServerSentEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	mock_producer := mock_kafka.NewMockProducer(ctrl)
+	mock_producer.EXPECT().Input().Return(make(chan *sarama.ProducerMessage, 16)).AnyTimes()
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	service, _ := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_producer, authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(&ai.Fake{Reply: "Hello over events"}),
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat", service.ServeChat)
+	mux.HandleFunc("GET /notifications/stream", service.ServeNotifications)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	t.Run("chat", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/chat", strings.NewReader(`{"type":"chat","model":"phi","data":"hello","request_id":"request-1"}`))
+		req.Header.Set("Authorization", "Bearer token")
+
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+		var content string
+		var seq float64
+
+		// the stream ends with the frame completing the chat
+		for frame := range events(t, res) {
+			assert.Equal(t, "chat", frame["type"])
+			assert.Equal(t, "request-1", frame["request_id"])
+			assert.Equal(t, seq+1, frame["seq"])
+
+			seq = frame["seq"].(float64)
+			content += frame["data"].(string)
+
+			if frame["done"].(bool) {
+				break
+			}
+		}
+
+		assert.Equal(t, "Hello over events", content)
+	})
+
+	t.Run("chat unauthorized", func(t *testing.T) {
+		res, err := http.Post(server.URL+"/chat?token=invalid", "application/json", strings.NewReader(`{"data":"hello"}`))
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("notifications", func(t *testing.T) {
+		// browsers cannot set headers on an event source, so the token is given in the query
+		res, err := http.Get(server.URL + "/notifications/stream?token=token")
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		frames := events(t, res)
+
+		// wait for the stream to be registered
+		assert.Eventually(t, func() bool {
+			service.mu.Lock()
+			defer service.mu.Unlock()
+
+			return len(service.users["1"]) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		service.notifications <- notification.New(notification.Audience{Type: notification.AudienceUser, ID: "2"}, "for bob")
+		service.notifications <- notification.New(notification.Audience{Type: notification.AudienceUser, ID: "1"}, "for alice")
+
+		select {
+		case frame := <-frames:
+			assert.Equal(t, "notification", frame["type"])
+			assert.Equal(t, "for alice", frame["data"])
+		case <-time.After(time.Second):
+			t.Error("expected the notification")
+		}
+	})
+}
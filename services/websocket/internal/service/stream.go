@@ -0,0 +1,236 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var errRequestInProgress = errors.New("a request with this request_id is in progress")
+
+// frame is a buffered outbound message of a stream
+type frame struct {
+	seq  int64
+	data []byte
+}
+
+// stream buffers the outbound frames of a single request with sequence numbers, so a client
+// reconnecting after a dropped connection can resume it from its last acknowledged frame
+type stream struct {
+	id       string // request id
+	user     string // owner of the request
+	capacity int    // maximum number of buffered frames
+
+	mu         sync.Mutex
+	frames     []frame
+	seq        int64     // sequence number of the last frame
+	finishedAt time.Time // zero while the request is in progress
+	client     *Client   // currently attached connection, nil while disconnected
+}
+
+// send assigns the next sequence number to the payload, buffers it and delivers it to the attached
+// client. The frame is delivered outside of the lock as a slow client may block the delivery.
+func (s *stream) send(payload map[string]interface{}) {
+	s.mu.Lock()
+
+	s.seq++
+
+	payload["request_id"] = s.id
+	payload["seq"] = s.seq
+
+	data, err := json.Marshal(payload)
+
+	if err != nil {
+		s.mu.Unlock()
+		slog.Error("unable to marshal json response", "error", err)
+
+		return
+	}
+
+	s.frames = append(s.frames, frame{seq: s.seq, data: data})
+
+	if len(s.frames) > s.capacity {
+		// the client has not acknowledged the oldest frames, they can no longer be resumed
+		s.frames = s.frames[len(s.frames)-s.capacity:]
+	}
+
+	client := s.client
+
+	s.mu.Unlock()
+
+	if client == nil || client.deliver(data) {
+		return
+	}
+
+	// the connection is gone, keep buffering until the client resumes
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == client {
+		s.client = nil
+	}
+}
+
+// finish marks the request as complete, the stream is kept for resumption until it expires
+func (s *stream) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.finishedAt = time.Now()
+}
+
+// ack discards the buffered frames the client has received
+func (s *stream) ack(seq int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := 0
+
+	for i < len(s.frames) && s.frames[i].seq <= seq {
+		i++
+	}
+
+	s.frames = s.frames[i:]
+}
+
+// attach the client to the stream and replay the frames after the given sequence number.
+// It returns the sequence number of the first frame which is no longer available, zero when nothing was lost.
+// The frames are replayed outside of the lock like in send, the frames sent meanwhile are only buffered
+// until the replay catches up with them so the client receives every frame in order.
+func (s *stream) attach(client *Client, seq int64) int64 {
+	s.mu.Lock()
+
+	var lost int64
+
+	if seq < s.seq && (len(s.frames) == 0 || s.frames[0].seq > seq+1) {
+		lost = seq + 1
+	}
+
+	s.client = nil
+
+	for {
+		var pending [][]byte
+
+		for _, frame := range s.frames {
+			if frame.seq > seq {
+				pending = append(pending, frame.data)
+			}
+		}
+
+		if len(pending) == 0 {
+			s.client = client
+			s.mu.Unlock()
+
+			return lost
+		}
+
+		seq = s.seq
+
+		s.mu.Unlock()
+
+		for _, data := range pending {
+			if !client.deliver(data) {
+				// the connection is gone, keep buffering until the client resumes again
+				return lost
+			}
+		}
+
+		s.mu.Lock()
+	}
+}
+
+// finished reports whether the request is complete
+func (s *stream) finished() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.finishedAt.IsZero()
+}
+
+// expired reports whether the finished request has been kept longer than the retention period
+func (s *stream) expired(retention time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.finishedAt.IsZero() && time.Since(s.finishedAt) > retention
+}
+
+// streams keeps the recent streams of every user
+type streams struct {
+	mu        sync.Mutex
+	items     map[string]*stream // streams keyed by user and request id
+	capacity  int                // maximum number of buffered frames per stream
+	retention time.Duration      // how long finished streams can be resumed
+}
+
+func newStreams(capacity int, retention time.Duration) *streams {
+	return &streams{
+		items:     make(map[string]*stream),
+		capacity:  capacity,
+		retention: retention,
+	}
+}
+
+func streamKey(user, id string) string {
+	return user + "/" + id
+}
+
+// open starts a new stream for the request of the client, generating a request id when none is given.
+// The id of a request still in progress cannot be reused.
+func (s *streams) open(client *Client, id string) (*stream, error) {
+	if id == "" {
+		id = newID()
+	}
+
+	stream := &stream{
+		id:       id,
+		user:     client.session.userID(),
+		capacity: s.capacity,
+		client:   client,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := streamKey(stream.user, id)
+
+	if running, ok := s.items[key]; ok && !running.finished() {
+		return nil, errRequestInProgress
+	}
+
+	s.items[key] = stream
+
+	return stream, nil
+}
+
+// get returns the stream of the user's request, nil when unknown or expired
+func (s *streams) get(user, id string) *stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.items[streamKey(user, id)]
+}
+
+// prune forgets the finished streams which are past their retention period
+func (s *streams) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, stream := range s.items {
+		if stream.expired(s.retention) {
+			delete(s.items, key)
+		}
+	}
+}
+
+// newID generates a random identifier
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"pkg/ai"
+	"pkg/auth"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ollamaChat simulates the ollama chat api, streaming a chunk for every word sent on the channel
+// at the pace of a generation
+func ollamaChat(words <-chan string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/x-ndjson")
+
+		flusher := w.(http.Flusher)
+		writer := json.NewEncoder(w)
+
+		for word := range words {
+			writer.Encode(ai.ChatResponse{
+				Message: ai.Message{
+					Role:    ai.ASSISTANT,
+					Content: word,
+				},
+			})
+			flusher.Flush()
+
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		writer.Encode(ai.ChatResponse{
+			Message: ai.Message{
+				Role: ai.ASSISTANT,
+			},
+			Done: true,
+		})
+	})
+}
+
+func TestResumeStream(t *testing.T) {
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+	authServer.issue("other-token", "2", time.Now().Add(time.Hour))
+
+	words := make(chan string)
+	url := serve(t, authServer, ollamaChat(words), WithVerifyInterval(0))
+
+	conn := dial(t, url, "token")
+
+	err := conn.WriteJSON(map[string]string{
+		"type":       "chat",
+		"data":       "hello",
+		"request_id": "request-1",
+	})
+
+	assert.NoError(t, err)
+
+	words <- "Hello"
+	words <- ", "
+
+	for seq := 1; seq <= 2; seq++ {
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "request-1", frame["request_id"])
+		assert.Equal(t, float64(seq), frame["seq"])
+	}
+
+	// drop the connection mid-answer, the generation continues meanwhile
+	conn.Close()
+
+	words <- "nice "
+	words <- "talking."
+	close(words)
+
+	t.Run("resume unknown request", func(t *testing.T) {
+		conn := dial(t, url, "token")
+
+		conn.WriteJSON(map[string]interface{}{
+			"type":       "resume",
+			"request_id": "request-2",
+		})
+
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "error", frame["type"])
+	})
+
+	t.Run("resume request of another user", func(t *testing.T) {
+		conn := dial(t, url, "other-token")
+
+		conn.WriteJSON(map[string]interface{}{
+			"type":       "resume",
+			"request_id": "request-1",
+		})
+
+		frame, err := readFrame(t, conn)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "error", frame["type"])
+	})
+
+	t.Run("resume from the last received frame", func(t *testing.T) {
+		conn := dial(t, url, "token")
+
+		conn.WriteJSON(map[string]interface{}{
+			"type":       "resume",
+			"request_id": "request-1",
+			"seq":        2,
+		})
+
+		var content string
+
+		for seq := 3; ; seq++ {
+			frame, err := readFrame(t, conn)
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, float64(seq), frame["seq"])
+
+			content += frame["data"].(string)
+
+			if frame["done"] == true {
+				break
+			}
+		}
+
+		assert.Equal(t, "nice talking.", content)
+	})
+}
+
+func TestStreamBuffer(t *testing.T) {
+	stream := &stream{
+		id:       "request",
+		capacity: 2,
+	}
+
+	for i := 0; i < 3; i++ {
+		stream.send(map[string]interface{}{
+			"type": "chat",
+		})
+	}
+
+	client := &Client{
+		send: newOutbound(PolicyBlock, 256, time.Second),
+	}
+
+	t.Run("frames evicted from the buffer are lost", func(t *testing.T) {
+		assert.Equal(t, int64(1), stream.attach(client, 0))
+		assert.Equal(t, 2, client.send.len())
+	})
+
+	t.Run("acknowledged frames are released", func(t *testing.T) {
+		stream.ack(2)
+
+		assert.Len(t, stream.frames, 1)
+		assert.Equal(t, int64(0), stream.attach(client, 2))
+	})
+}
+
+func TestStreamReplay(t *testing.T) {
+	stream := &stream{
+		id:       "request",
+		capacity: 8,
+	}
+
+	for i := 0; i < 3; i++ {
+		stream.send(map[string]interface{}{
+			"type": "chat",
+		})
+	}
+
+	// the client only has room for one frame, the replay waits for it to read
+	client := &Client{
+		send: newOutbound(PolicyBlock, 1, 5*time.Second),
+	}
+
+	attached := make(chan int64)
+
+	go func() {
+		attached <- stream.attach(client, 0)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return client.send.len() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the stream is not locked while the replay waits
+	sent := make(chan struct{})
+
+	go func() {
+		stream.send(map[string]interface{}{
+			"type": "chat",
+		})
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("send blocked by the replay")
+	}
+
+	var seqs []float64
+
+	for len(seqs) < 4 {
+		data, ok := client.send.pop(time.After(time.Second))
+
+		if !assert.True(t, ok) {
+			break
+		}
+
+		frame := map[string]interface{}{}
+		json.Unmarshal(data, &frame)
+
+		seqs = append(seqs, frame["seq"].(float64))
+	}
+
+	assert.Equal(t, []float64{1, 2, 3, 4}, seqs)
+	assert.Equal(t, int64(0), <-attached)
+
+	// live frames are delivered once the replay caught up
+	stream.send(map[string]interface{}{
+		"type": "chat",
+	})
+
+	assert.Equal(t, 1, client.send.len())
+}
+
+func TestOpenStream(t *testing.T) {
+	streams := newStreams(8, time.Minute)
+
+	client := func(user string) *Client {
+		return &Client{
+			send:    newOutbound(PolicyBlock, 256, time.Second),
+			session: newSession("token", &auth.Claims{UserID: user}),
+		}
+	}
+
+	first, err := streams.open(client("1"), "request")
+	assert.NoError(t, err)
+
+	// the id of a request in progress is not taken over by another request
+	_, err = streams.open(client("1"), "request")
+	assert.ErrorIs(t, err, errRequestInProgress)
+	assert.Same(t, first, streams.get("1", "request"))
+
+	_, err = streams.open(client("2"), "request")
+	assert.NoError(t, err)
+
+	// once the request is complete its id can be used again
+	first.finish()
+
+	second, err := streams.open(client("1"), "request")
+	assert.NoError(t, err)
+	assert.Same(t, second, streams.get("1", "request"))
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"pkg/ai"
+	"pkg/auth"
+	"websocket/internal/schema"
+	"websocket/internal/store"
+)
+
+var errFormat = errors.New(`format must be "json" or a json schema`)
+
+// format compiles the output format requested by a chat, any json document is accepted when the
+// format is "json"
+func format(raw json.RawMessage) (*schema.Schema, error) {
+	raw = bytes.TrimSpace(raw)
+
+	if string(raw) == `"json"` {
+		return schema.Compile(json.RawMessage(`true`))
+	}
+
+	if len(raw) == 0 || raw[0] != '{' {
+		return nil, errFormat
+	}
+
+	return schema.Compile(raw)
+}
+
+// conform validates the reply against the format, asking the model once more to fix it when retry
+// is set. The chunks of the second reply are preceded by a retry frame listing the problems of
+// the first one. It returns the final reply along with the result frame.
+func (m *Service) conform(ctx context.Context, claims *auth.Claims, request *ai.ChatRequest, reply string, format *schema.Schema, retry bool, spent *store.Usage, send func(map[string]interface{})) (string, map[string]interface{}, error) {
+	err := format.Validate([]byte(reply))
+
+	if err != nil && retry {
+		send(map[string]interface{}{
+			"type":   "retry",
+			"errors": problems(err),
+		})
+
+		request.Messages = append(request.Messages,
+			ai.Message{Role: ai.ASSISTANT, Content: reply},
+			ai.Message{Role: ai.USER, Content: "The response does not follow the requested format: " + err.Error() + ". Reply again with the corrected JSON only."},
+		)
+
+		var generateErr error
+
+		if reply, generateErr = m.generate(ctx, claims, request, spent, send); generateErr != nil {
+			return "", nil, generateErr
+		}
+
+		err = format.Validate([]byte(reply))
+	}
+
+	result := map[string]interface{}{
+		"type":  "result",
+		"valid": err == nil,
+		"done":  true,
+	}
+
+	if err != nil {
+		result["errors"] = problems(err)
+	} else {
+		result["data"] = json.RawMessage(bytes.TrimSpace([]byte(reply)))
+	}
+
+	return reply, result, nil
+}
+
+// problems lists the reasons the reply does not follow the format
+func problems(err error) []string {
+	var invalid *schema.Error
+
+	if errors.As(err, &invalid) {
+		return invalid.Problems
+	}
+
+	return []string{err.Error()}
+}
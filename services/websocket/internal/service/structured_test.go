@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"pkg/ai"
+	mock_kafka "pkg/kafka/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// sequence is a fake provider replying with the replies in turn
+type sequence struct {
+	ai.Fake
+	replies  chan string
+	requests chan *ai.ChatRequest
+}
+
+func (s *sequence) Chat(ctx context.Context, request *ai.ChatRequest, cb ai.ChatCallBack) error {
+	recorded := *request
+	recorded.Messages = append([]ai.Message(nil), request.Messages...)
+	s.requests <- &recorded
+
+	cb(&ai.ChatResponse{Message: ai.Message{Role: ai.ASSISTANT, Content: <-s.replies}})
+	cb(&ai.ChatResponse{Message: ai.Message{Role: ai.ASSISTANT}, Done: true})
+
+	return nil
+}
+
+func TestStructuredOutput(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	provider := &sequence{replies: make(chan string, 4), requests: make(chan *ai.ChatRequest, 4)}
+
+	_, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_kafka.NewMockProducer(ctrl), authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(provider),
+	)
+
+	conn := dial(t, url, "token")
+
+	city := map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"city": map[string]string{"type": "string"}},
+		"required":   []string{"city"},
+	}
+
+	// frames reads the frames of the request until the response ends
+	frames := func(t *testing.T, requestID string) []map[string]interface{} {
+		var frames []map[string]interface{}
+
+		for {
+			frame, err := readFrame(t, conn)
+			assert.NoError(t, err)
+
+			if frame["request_id"] != requestID {
+				continue
+			}
+
+			frames = append(frames, frame)
+
+			if frame["done"] == true {
+				return frames
+			}
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		provider.replies <- `{"city": "Paris"}`
+
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "capital of France?", "request_id": "valid", "format": city})
+
+		received := frames(t, "valid")
+		result := received[len(received)-1]
+
+		assert.Equal(t, "chat", received[0]["type"])
+		assert.Equal(t, "result", result["type"])
+		assert.Equal(t, true, result["valid"])
+		assert.Equal(t, map[string]interface{}{"city": "Paris"}, result["data"])
+
+		request := <-provider.requests
+		assert.JSONEq(t, `{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}`, string(request.Format))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		provider.replies <- `{"city": 1}`
+
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "capital of France?", "request_id": "invalid", "format": city})
+
+		received := frames(t, "invalid")
+		result := received[len(received)-1]
+
+		assert.Equal(t, false, result["valid"])
+		assert.Equal(t, []interface{}{"$.city: expected string, got integer"}, result["errors"])
+		assert.Nil(t, result["data"])
+
+		<-provider.requests
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		provider.replies <- `The capital is Rome`
+		provider.replies <- `{"city": "Rome"}`
+
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "capital of Italy?", "request_id": "retry", "format": "json", "retry": true})
+
+		received := frames(t, "retry")
+
+		assert.Equal(t, "retry", received[2]["type"])
+		assert.Equal(t, true, received[len(received)-1]["valid"])
+		assert.Equal(t, map[string]interface{}{"city": "Rome"}, received[len(received)-1]["data"])
+
+		<-provider.requests
+
+		// the model is told what was wrong with its reply
+		request := <-provider.requests
+		assert.Len(t, request.Messages, 3)
+		assert.Equal(t, "The capital is Rome", request.Messages[1].Content)
+		assert.Contains(t, request.Messages[2].Content, "invalid json")
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "capital of Spain?", "request_id": "format", "format": "yaml"})
+
+		received := frames(t, "format")
+
+		assert.Equal(t, `format must be "json" or a json schema`, received[0]["error"])
+	})
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"pkg/ai"
+	"pkg/auth"
+	"strings"
+	"unicode/utf8"
+	"websocket/internal/store"
+)
+
+// imageTokens is roughly how many tokens the vision models spend on an image
+const imageTokens = 768
+
+// summaryPrompt asks the model for the summary of the earlier messages of a conversation
+const summaryPrompt = "You summarize conversations between a user and an assistant. Write a concise summary of " +
+	"the conversation below, keeping the facts, decisions, names, figures and open questions needed to " +
+	"continue it. Reply with the summary only."
+
+var errEmptySummary = errors.New("the model returned an empty summary")
+
+// estimateTokens estimates the tokens of the message, about four characters per token along with
+// the few tokens marking the message
+func estimateTokens(message store.Message) int {
+	return (utf8.RuneCountInString(message.Content)+3)/4 + 4 + len(message.Attachments)*imageTokens
+}
+
+// historyTokens estimates the tokens of the history of the conversation sent along with the prompts
+func historyTokens(conversation *store.Conversation) int {
+	tokens := 0
+
+	if conversation.Summary != "" {
+		tokens = estimateTokens(store.Message{Message: ai.Message{Content: conversation.Summary}})
+	}
+
+	for _, message := range conversation.Messages[min(conversation.Summarized, len(conversation.Messages)):] {
+		tokens += estimateTokens(message)
+	}
+
+	return tokens
+}
+
+// condense summarizes the conversation in the background once its history exceeds the threshold,
+// unless it is being summarized already
+func (m *Service) condense(claims *auth.Claims, conversation *store.Conversation, model string) {
+	if m.summaryThreshold <= 0 || conversation == nil {
+		return
+	}
+
+	key := conversation.Owner + " " + conversation.ID
+
+	if _, busy := m.summarizing.LoadOrStore(key, true); busy {
+		return
+	}
+
+	m.track(func() {
+		defer m.summarizing.Delete(key)
+
+		if err := m.summarize(claims, conversation.Owner, conversation.ID, model); err != nil {
+			slog.Error("unable to summarize conversation", "conversation", conversation.ID, "error", err)
+		}
+	})
+}
+
+// summarize replaces the earlier messages of the conversation with a summary when its history
+// exceeds the threshold. The last messages are kept as they are, starting with a message of the
+// user so no reply is separated from its question. The summary covers the previous one as well.
+func (m *Service) summarize(claims *auth.Claims, owner, id, model string) error {
+	conversation, err := m.store.Conversation(m.ctx, owner, id)
+
+	if err != nil {
+		return err
+	}
+
+	if historyTokens(conversation) <= m.summaryThreshold {
+		return nil
+	}
+
+	messages := conversation.Messages
+	cut := max(len(messages)-m.summaryKeep, 0)
+
+	for cut < len(messages) && cut > conversation.Summarized && messages[cut].Role != ai.USER {
+		cut--
+	}
+
+	if cut <= conversation.Summarized {
+		// nothing left to summarize but the messages to keep
+		return nil
+	}
+
+	summary, err := m.summary(claims, model, conversation.Summary, messages[conversation.Summarized:cut])
+
+	if err != nil {
+		return err
+	}
+
+	slog.Info("summarized conversation", "conversation", id, "messages", cut-conversation.Summarized)
+
+	return m.store.SaveSummary(m.ctx, owner, id, summary, cut)
+}
+
+// summary asks the summary model, or the model of the conversation when none is set, for the
+// summary of the messages following the previous summary
+func (m *Service) summary(claims *auth.Claims, model, previous string, messages []store.Message) (string, error) {
+	var transcript strings.Builder
+
+	if previous != "" {
+		fmt.Fprintf(&transcript, "Summary of the conversation so far:\n%s\n\n", previous)
+	}
+
+	for _, message := range messages {
+		speaker := "User"
+
+		if message.Role == ai.ASSISTANT {
+			speaker = "Assistant"
+		}
+
+		fmt.Fprintf(&transcript, "%s: %s", speaker, message.Content)
+
+		for range message.Attachments {
+			transcript.WriteString(" [image]")
+		}
+
+		transcript.WriteString("\n\n")
+	}
+
+	if m.summaryModel != "" {
+		model = m.summaryModel
+	}
+
+	summary, err := m.ask(claims, model, summaryPrompt, strings.TrimSpace(transcript.String()))
+
+	if err != nil {
+		return "", err
+	}
+
+	if summary == "" {
+		return "", errEmptySummary
+	}
+
+	return summary, nil
+}
+
+// ask returns the reply of the model to the prompt, written on behalf of the user behind the scenes.
+// The tokens spent count toward the usage of the user but not the request, which the user did not make.
+func (m *Service) ask(claims *auth.Claims, model, system, prompt string) (string, error) {
+	request := &ai.ChatRequest{
+		Model: model,
+		Messages: []ai.Message{
+			{Role: ai.SYSTEM, Content: system},
+			{Role: ai.USER, Content: prompt},
+		},
+	}
+
+	spent := metered(claims, model)
+	spent.Requests = 0
+	defer m.record(spent)
+
+	var reply strings.Builder
+
+	err := m.provider.Chat(m.ctx, request, func(cr *ai.ChatResponse) {
+		meter(spent, cr)
+		reply.WriteString(cr.Message.Content)
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(reply.String()), nil
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"pkg/ai"
+	mock_kafka "pkg/kafka/mocks"
+	"strings"
+	"testing"
+	"time"
+	"websocket/internal/store"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	assert.Equal(t, 4, estimateTokens(store.Message{}))
+	assert.Equal(t, 7, estimateTokens(store.Message{Message: ai.Message{Content: "héllo there"}}))
+	assert.Equal(t, 4+imageTokens, estimateTokens(store.Message{Attachments: []store.Attachment{{Upload: "1"}}}))
+
+	conversation := &store.Conversation{
+		Summary:    "they said hi",
+		Summarized: 1,
+		Messages: []store.Message{
+			{Message: ai.Message{Role: ai.USER, Content: "a long forgotten message"}},
+			{Message: ai.Message{Role: ai.ASSISTANT, Content: "hi"}},
+		},
+	}
+
+	assert.Equal(t, 7+5, historyTokens(conversation))
+}
+
+func TestSummaries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	provider := newRecorder("Noted, the plan is set")
+
+	service, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_kafka.NewMockProducer(ctrl), authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(provider),
+		WithSummaries(30, 2, "tiny"),
+	)
+
+	conn := dial(t, url, "token")
+
+	chat := func(data string) *ai.ChatRequest {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "model": "phi", "data": data, "conversation_id": "trip", "request_id": data})
+
+		for {
+			frame, err := readFrame(t, conn)
+
+			if !assert.NoError(t, err) || frame["done"] == true {
+				return provider.request(t)
+			}
+		}
+	}
+
+	chat("we are travelling to Lisbon in May with two children")
+
+	// the history goes over the threshold, the first turn is summarized
+	chat("we need a hotel close to the old town with a pool")
+
+	summary := provider.request(t)
+
+	assert.Equal(t, "tiny", summary.Model)
+	assert.Equal(t, summaryPrompt, summary.Messages[0].Content)
+	assert.Equal(t, "User: we are travelling to Lisbon in May with two children\n\nAssistant: Noted, the plan is set", summary.Messages[1].Content)
+
+	assert.Eventually(t, func() bool {
+		conversation, err := service.store.Conversation(context.Background(), "1", "trip")
+		return err == nil && conversation.Summarized == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// the summarized messages are replaced with the summary
+	request := chat("what about the museums?")
+
+	var contents []string
+
+	for _, message := range request.Messages {
+		contents = append(contents, string(message.Role)+": "+message.Content)
+	}
+
+	assert.Equal(t, []string{
+		"system: Summary of the earlier conversation:\nNoted, the plan is set",
+		"user: we need a hotel close to the old town with a pool",
+		"assistant: Noted, the plan is set",
+		"user: what about the museums?",
+	}, contents)
+
+	// the next summary covers the previous one
+	summary = provider.request(t)
+	assert.True(t, strings.HasPrefix(summary.Messages[1].Content, "Summary of the conversation so far:\nNoted, the plan is set\n\nUser: we need a hotel"))
+
+	assert.Eventually(t, func() bool {
+		conversation, err := service.store.Conversation(context.Background(), "1", "trip")
+		return err == nil && conversation.Summarized == 4
+	}, time.Second, 10*time.Millisecond)
+}
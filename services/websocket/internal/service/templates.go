@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pkg/auth"
+	"regexp"
+	"slices"
+	"strings"
+	"websocket/internal/model"
+	"websocket/internal/store"
+)
+
+var (
+	errTemplateNotFound = errors.New("template not found")
+	errTemplateOwner    = errors.New("only the owner can change the template")
+)
+
+// placeholder is a variable of a template such as {{name}} or {{ name }}
+var placeholder = regexp.MustCompile(`\{\{\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\}\}`)
+
+// Templates returns the handler of the prompt template api, serving /templates
+func (m *Service) Templates() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /templates", m.authorized(apiError, m.listTemplates))
+	mux.HandleFunc("POST /templates", m.authorized(apiError, m.createTemplate))
+	mux.HandleFunc("GET /templates/{id}", m.authorized(apiError, m.getTemplate))
+	mux.HandleFunc("PUT /templates/{id}", m.authorized(apiError, m.updateTemplate))
+	mux.HandleFunc("DELETE /templates/{id}", m.authorized(apiError, m.deleteTemplate))
+
+	return mux
+}
+
+// variables returns the names of the variables of the content in the order they first appear
+func variables(content string) []string {
+	names := []string{}
+
+	for _, match := range placeholder.FindAllStringSubmatch(content, -1) {
+		if !slices.Contains(names, match[1]) {
+			names = append(names, match[1])
+		}
+	}
+
+	return names
+}
+
+// render fills in the variables of the template with the values, or their defaults when left out
+func render(template *store.Template, values map[string]string) (string, error) {
+	for name := range values {
+		if !slices.Contains(template.Variables, name) {
+			return "", fmt.Errorf("unknown variable %q of template %q", name, template.Name)
+		}
+	}
+
+	var missing []string
+
+	content := placeholder.ReplaceAllStringFunc(template.Content, func(match string) string {
+		name := placeholder.FindStringSubmatch(match)[1]
+
+		if value, ok := values[name]; ok {
+			return value
+		}
+
+		if value, ok := template.Defaults[name]; ok {
+			return value
+		}
+
+		if !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+
+		return match
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing variables %s of template %q", strings.Join(missing, ", "), template.Name)
+	}
+
+	return content, nil
+}
+
+// instantiate fills in the template of the chat message with its variables. The rendered template
+// becomes the data of the message, followed by the data sent along with it such as a log to summarize.
+func (m *Service) instantiate(ctx context.Context, claims *auth.Claims, message *model.Message) error {
+	template, err := m.store.Template(ctx, claims.UserID, claims.Org, message.Template)
+
+	if errors.Is(err, store.ErrNotFound) {
+		return errTemplateNotFound
+	}
+
+	if err != nil {
+		slog.Error("unable to load template", "template", message.Template, "error", err)
+		return errTemplateNotFound
+	}
+
+	content, err := render(template, message.Variables)
+
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(message.Data) != "" {
+		content += "\n\n" + message.Data
+	}
+
+	message.Data = content
+
+	return nil
+}
+
+// templateRequest is the body of the requests creating or updating a template
+type templateRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Content     string            `json:"content"`
+	Defaults    map[string]string `json:"defaults"`
+	Visibility  string            `json:"visibility"` // private by default
+}
+
+// template decodes and validates the template in the body of the request, it can only be shared
+// by the users of an organization
+func (m *Service) template(r *http.Request, claims *auth.Claims) (*store.Template, error) {
+	request := &templateRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		return nil, errors.New("invalid template")
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+
+	if request.Visibility == "" {
+		request.Visibility = store.Private
+	}
+
+	switch {
+	case request.Name == "":
+		return nil, errors.New("name is required")
+	case strings.TrimSpace(request.Content) == "":
+		return nil, errors.New("content is required")
+	case request.Visibility != store.Private && request.Visibility != store.Shared:
+		return nil, errors.New(`visibility must be "private" or "org"`)
+	case request.Visibility == store.Shared && claims.Org == "":
+		return nil, errors.New("only the members of an organization can share templates")
+	}
+
+	names := variables(request.Content)
+
+	for name := range request.Defaults {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("default of unknown variable %q", name)
+		}
+	}
+
+	return &store.Template{
+		Org:         claims.Org,
+		Name:        request.Name,
+		Description: request.Description,
+		Content:     request.Content,
+		Variables:   names,
+		Defaults:    request.Defaults,
+		Visibility:  request.Visibility,
+	}, nil
+}
+
+// owned returns the template when the user owns it
+func (m *Service) owned(ctx context.Context, claims *auth.Claims, id string) (*store.Template, error) {
+	template, err := m.store.Template(ctx, claims.UserID, claims.Org, id)
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return nil, errTemplateNotFound
+	case err != nil:
+		return nil, err
+	case template.Owner != claims.UserID:
+		return nil, errTemplateOwner
+	}
+
+	return template, nil
+}
+
+// ownedError responds with the reason the template could not be changed
+func ownedError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errTemplateNotFound):
+		apiError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errTemplateOwner):
+		apiError(w, http.StatusForbidden, err.Error())
+	default:
+		slog.Error("unable to load template", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to load template")
+	}
+}
+
+func (m *Service) listTemplates(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	templates, err := m.store.Templates(r.Context(), claims.UserID, claims.Org)
+
+	if err != nil {
+		slog.Error("unable to list templates", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to list templates")
+
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]interface{}{
+		"data": templates,
+	})
+}
+
+func (m *Service) createTemplate(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	template, err := m.template(r, claims)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	template.ID = newID()
+	template.Owner = claims.UserID
+
+	if err := m.store.SaveTemplate(r.Context(), template); err != nil {
+		slog.Error("unable to save template", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to save template")
+
+		return
+	}
+
+	respond(w, http.StatusCreated, template)
+}
+
+func (m *Service) getTemplate(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	template, err := m.store.Template(r.Context(), claims.UserID, claims.Org, r.PathValue("id"))
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		apiError(w, http.StatusNotFound, errTemplateNotFound.Error())
+	case err != nil:
+		slog.Error("unable to load template", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to load template")
+	default:
+		respond(w, http.StatusOK, template)
+	}
+}
+
+func (m *Service) updateTemplate(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	id := r.PathValue("id")
+
+	if _, err := m.owned(r.Context(), claims, id); err != nil {
+		ownedError(w, err)
+		return
+	}
+
+	template, err := m.template(r, claims)
+
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	template.ID = id
+	template.Owner = claims.UserID
+
+	if err := m.store.SaveTemplate(r.Context(), template); err != nil {
+		slog.Error("unable to save template", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to save template")
+
+		return
+	}
+
+	respond(w, http.StatusOK, template)
+}
+
+func (m *Service) deleteTemplate(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	id := r.PathValue("id")
+
+	if _, err := m.owned(r.Context(), claims, id); err != nil {
+		ownedError(w, err)
+		return
+	}
+
+	if err := m.store.DeleteTemplate(r.Context(), claims.UserID, id); err != nil {
+		slog.Error("unable to delete template", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to delete template")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
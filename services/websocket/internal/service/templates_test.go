@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pkg/auth"
+	mock_kafka "pkg/kafka/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	provider := newRecorder("Looks good")
+
+	service, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_kafka.NewMockProducer(ctrl), authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(provider),
+		WithAPIKeys(map[string]*auth.Claims{
+			"sk-owner":    {UserID: "1", Org: "acme", Role: "member"},
+			"sk-member":   {UserID: "2", Org: "acme", Role: "member"},
+			"sk-outsider": {UserID: "3", Role: "member"},
+		}),
+	)
+
+	server := httptest.NewServer(service.Templates())
+	t.Cleanup(server.Close)
+
+	request := func(method, path, key string, body interface{}) (int, map[string]interface{}) {
+		data, _ := json.Marshal(body)
+
+		req, _ := http.NewRequest(method, server.URL+path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer "+key)
+
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		response := map[string]interface{}{}
+		json.NewDecoder(res.Body).Decode(&response)
+
+		return res.StatusCode, response
+	}
+
+	status, review := request(http.MethodPost, "/templates", "sk-owner", map[string]interface{}{
+		"name":     "review",
+		"content":  "Review this {{ language }} code for {{focus}}, mind the {{focus}}:",
+		"defaults": map[string]string{"focus": "bugs"},
+	})
+
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, []interface{}{"language", "focus"}, review["variables"])
+	assert.Equal(t, "private", review["visibility"])
+	assert.Equal(t, "1", review["owner"])
+
+	status, commit := request(http.MethodPost, "/templates", "sk-owner", map[string]interface{}{
+		"name":       "commit",
+		"content":    "Write a commit message for {{diff}}",
+		"visibility": "org",
+	})
+
+	assert.Equal(t, http.StatusCreated, status)
+
+	t.Run("invalid", func(t *testing.T) {
+		for key, body := range map[string]map[string]interface{}{
+			"sk-owner":    {"name": "", "content": "hi"},
+			"sk-member":   {"name": "empty", "content": " "},
+			"sk-outsider": {"name": "shared", "content": "hi", "visibility": "org"},
+		} {
+			status, _ := request(http.MethodPost, "/templates", key, body)
+			assert.Equal(t, http.StatusBadRequest, status)
+		}
+
+		status, response := request(http.MethodPost, "/templates", "sk-owner", map[string]interface{}{
+			"name":     "defaults",
+			"content":  "Hello {{name}}",
+			"defaults": map[string]string{"nmae": "you"},
+		})
+
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, `default of unknown variable "nmae"`, response["error"])
+	})
+
+	t.Run("sharing", func(t *testing.T) {
+		_, list := request(http.MethodGet, "/templates", "sk-owner", nil)
+		assert.Len(t, list["data"], 2)
+
+		// the members of the organization see the shared templates only
+		_, list = request(http.MethodGet, "/templates", "sk-member", nil)
+
+		if assert.Len(t, list["data"], 1) {
+			assert.Equal(t, "commit", list["data"].([]interface{})[0].(map[string]interface{})["name"])
+		}
+
+		status, _ := request(http.MethodGet, "/templates/"+review["id"].(string), "sk-member", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+
+		status, _ = request(http.MethodGet, "/templates/"+commit["id"].(string), "sk-outsider", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+
+		// only the owner can change a shared template
+		status, response := request(http.MethodPut, "/templates/"+commit["id"].(string), "sk-member", map[string]interface{}{
+			"name":    "commit",
+			"content": "Write a haiku for {{diff}}",
+		})
+
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Equal(t, "only the owner can change the template", response["error"])
+
+		status, _ = request(http.MethodDelete, "/templates/"+commit["id"].(string), "sk-member", nil)
+		assert.Equal(t, http.StatusForbidden, status)
+
+		status, updated := request(http.MethodPut, "/templates/"+commit["id"].(string), "sk-owner", map[string]interface{}{
+			"name":       "commit",
+			"content":    "Write a conventional commit message for {{diff}}",
+			"visibility": "org",
+		})
+
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, commit["created_at"], updated["created_at"])
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		conn := dial(t, url, "token")
+
+		chat := func(frame map[string]interface{}) map[string]interface{} {
+			conn.WriteJSON(frame)
+
+			for {
+				received, err := readFrame(t, conn)
+
+				if !assert.NoError(t, err) {
+					return nil
+				}
+
+				if received["type"] == "error" || received["done"] == true {
+					return received
+				}
+			}
+		}
+
+		reply := chat(map[string]interface{}{
+			"type":       "chat",
+			"model":      "phi",
+			"request_id": "1",
+			"template":   review["id"],
+			"variables":  map[string]string{"language": "go"},
+			"data":       "func main() {}",
+		})
+
+		assert.Empty(t, reply["error"])
+
+		messages := provider.request(t).Messages
+		assert.Equal(t, "Review this go code for bugs, mind the bugs:\n\nfunc main() {}", messages[len(messages)-1].Content)
+
+		reply = chat(map[string]interface{}{"type": "chat", "model": "phi", "request_id": "2", "template": review["id"]})
+		assert.Equal(t, `missing variables language of template "review"`, reply["error"])
+
+		reply = chat(map[string]interface{}{
+			"type":       "chat",
+			"model":      "phi",
+			"request_id": "3",
+			"template":   review["id"],
+			"variables":  map[string]string{"language": "go", "tone": "harsh"},
+		})
+
+		assert.Equal(t, `unknown variable "tone" of template "review"`, reply["error"])
+
+		reply = chat(map[string]interface{}{"type": "chat", "model": "phi", "request_id": "4", "template": "unknown"})
+		assert.Equal(t, "template not found", reply["error"])
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		status, _ := request(http.MethodDelete, "/templates/"+review["id"].(string), "sk-owner", nil)
+		assert.Equal(t, http.StatusNoContent, status)
+
+		status, _ = request(http.MethodGet, "/templates/"+review["id"].(string), "sk-owner", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"pkg/auth"
+	"pkg/notification"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/IBM/sarama"
+)
+
+// titlePrompt asks the model for the title of a conversation
+const titlePrompt = "You name conversations between a user and an assistant. Write a short title of at most six " +
+	"words for the conversation below. Reply with the title only, without quotes."
+
+// maxTitleLength is how many characters of the title generated by the model are kept
+const maxTitleLength = 80
+
+var errEmptyTitle = errors.New("the model returned an empty title")
+
+// entitle names the conversation in the background after its first exchange, with the title model
+// or the model of the conversation when none is set, and tells every connection of the user about it
+func (m *Service) entitle(claims *auth.Claims, turn *turn, model, reply string) {
+	// the conversation is as loaded before the exchange
+	conversation := turn.conversation
+
+	if conversation == nil || len(conversation.Messages) > 0 || conversation.Title != "" {
+		return
+	}
+
+	if m.titleModel != "" {
+		model = m.titleModel
+	}
+
+	m.track(func() {
+		prompt := fmt.Sprintf("User: %s\n\nAssistant: %s", turn.message.Content, reply)
+
+		answer, err := m.ask(claims, model, titlePrompt, prompt)
+
+		if err == nil {
+			answer, err = title(answer)
+		}
+
+		if err == nil {
+			err = m.store.SaveTitle(m.ctx, conversation.Owner, conversation.ID, answer)
+		}
+
+		if err != nil {
+			slog.Error("unable to name conversation", "conversation", conversation.ID, "error", err)
+			return
+		}
+
+		m.notify(conversation.Owner, map[string]interface{}{
+			"type":            "conversation_updated",
+			"conversation_id": conversation.ID,
+			"title":           answer,
+		})
+	})
+}
+
+// title cleans up the title written by the model, which tends to quote it or add a label
+func title(answer string) (string, error) {
+	answer = strings.TrimSpace(answer)
+	answer, _, _ = strings.Cut(answer, "\n")
+
+	if label, rest, ok := strings.Cut(answer, ":"); ok && strings.EqualFold(strings.TrimSpace(label), "title") {
+		answer = rest
+	}
+
+	answer = strings.TrimRight(strings.Trim(strings.TrimSpace(answer), `"'*#`+"`"), ".")
+	answer = strings.TrimSpace(answer)
+
+	if utf8.RuneCountInString(answer) > maxTitleLength {
+		answer = strings.TrimSpace(string([]rune(answer)[:maxTitleLength]))
+	}
+
+	if answer == "" {
+		return "", errEmptyTitle
+	}
+
+	return answer, nil
+}
+
+// notify publishes the frame to every connection of the user through the notification topic, so the
+// connections to the other instances are told as well
+func (m *Service) notify(user string, frame map[string]interface{}) {
+	data, err := json.Marshal(frame)
+
+	if err != nil {
+		slog.Error("unable to marshal json response", "error", err)
+		return
+	}
+
+	event, err := json.Marshal(notification.NewFrame(notification.Audience{Type: notification.AudienceUser, ID: user}, data))
+
+	if err != nil {
+		slog.Error("error marshalling notification", "error", err)
+		return
+	}
+
+	m.producer.Input() <- &sarama.ProducerMessage{
+		Topic: m.topicConsumer,
+		Key:   sarama.StringEncoder(user),
+		Value: sarama.ByteEncoder(event),
+	}
+}
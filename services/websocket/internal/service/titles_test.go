@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	mock_kafka "pkg/kafka/mocks"
+	"pkg/notification"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTitle(t *testing.T) {
+	for answer, expected := range map[string]string{
+		"Lisbon Family Trip":                  "Lisbon Family Trip",
+		`  "Lisbon Family Trip."  `:           "Lisbon Family Trip",
+		"Title: **Lisbon Family Trip**\nmore": "Lisbon Family Trip",
+		strings.Repeat("long ", 30):           strings.TrimSpace(strings.Repeat("long ", 16)),
+	} {
+		title, err := title(answer)
+
+		assert.NoError(t, err)
+		assert.Equal(t, expected, title)
+	}
+
+	_, err := title(` "" `)
+	assert.ErrorIs(t, err, errEmptyTitle)
+}
+
+func TestTitles(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	mock_producer := mock_kafka.NewMockProducer(ctrl)
+
+	published := make(chan *sarama.ProducerMessage, 16)
+	mock_producer.EXPECT().Input().Return(published).AnyTimes()
+
+	provider := newRecorder(`"Lisbon Family Trip."`)
+
+	service, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_producer, authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(provider),
+		WithTitles("tiny"),
+	)
+
+	// the notifications published by the service come back to it, as they would from kafka
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() {
+		for {
+			select {
+			case message := <-published:
+				if message.Topic != service.topicConsumer {
+					continue
+				}
+
+				data, _ := message.Value.Encode()
+				event := notification.Parse(data)
+
+				assert.Equal(t, notification.Audience{Type: notification.AudienceUser, ID: "1"}, event.Audience)
+
+				select {
+				case service.notifications <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	first := dial(t, url, "token")
+	second := dial(t, url, "token")
+
+	assert.Eventually(t, func() bool {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+
+		return len(service.users["1"]) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// updated waits for the conversation_updated frame on the connection
+	updated := func(conn *websocket.Conn) map[string]interface{} {
+		for {
+			frame, err := readFrame(t, conn)
+
+			if !assert.NoError(t, err) || frame["type"] == "conversation_updated" {
+				return frame
+			}
+		}
+	}
+
+	first.WriteJSON(map[string]interface{}{"type": "chat", "model": "phi", "data": "plan a trip to Lisbon", "conversation_id": "trip"})
+
+	// every connection of the user is told about the title
+	for _, conn := range []*websocket.Conn{first, second} {
+		frame := updated(conn)
+
+		assert.Equal(t, "trip", frame["conversation_id"])
+		assert.Equal(t, "Lisbon Family Trip", frame["title"])
+	}
+
+	conversation, err := service.store.Conversation(context.Background(), "1", "trip")
+	assert.NoError(t, err)
+	assert.Equal(t, "Lisbon Family Trip", conversation.Title)
+
+	// the title is written by the title model from the first exchange
+	var named int
+
+	for len(provider.requests) > 0 {
+		request := <-provider.requests
+
+		if request.Messages[0].Content == titlePrompt {
+			named++
+
+			assert.Equal(t, "tiny", request.Model)
+			assert.Equal(t, "User: plan a trip to Lisbon\n\nAssistant: \"Lisbon Family Trip.\"", request.Messages[1].Content)
+		}
+	}
+
+	assert.Equal(t, 1, named)
+
+	// the conversation is named once
+	first.WriteJSON(map[string]interface{}{"type": "chat", "model": "phi", "data": "and then Porto", "conversation_id": "trip", "request_id": "2"})
+
+	for {
+		frame, err := readFrame(t, first)
+
+		if !assert.NoError(t, err) || frame["done"] == true {
+			break
+		}
+	}
+
+	request := provider.request(t)
+	assert.Equal(t, "and then Porto", request.Messages[len(request.Messages)-1].Content)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, provider.requests)
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"pkg/ai"
+	"pkg/auth"
+	"strings"
+	"websocket/internal/store"
+)
+
+// maxToolRounds is how many times the model can call tools before answering
+const maxToolRounds = 5
+
+var errToolRounds = errors.New("too many tool calls")
+
+// generate chats with the model, calling the tools it asks for and feeding their results back until
+// it answers. The chunks of the answer are sent as chat frames, every call as a tool_call frame
+// followed by a tool_result frame. It returns the content generated across the rounds, the tokens
+// of every round are added to the usage.
+func (m *Service) generate(ctx context.Context, claims *auth.Claims, request *ai.ChatRequest, spent *store.Usage, send func(map[string]interface{})) (string, error) {
+	var reply strings.Builder
+
+	for round := 0; ; round++ {
+		var calls []ai.ToolCall
+
+		// Callback function to handle the response
+		callback := func(cr *ai.ChatResponse) {
+			meter(spent, cr)
+
+			calls = append(calls, cr.Message.ToolCalls...)
+			done := cr.Done
+
+			// the answer goes on once the tools have been called
+			if len(calls) > 0 && (done || len(cr.Message.ToolCalls) > 0) {
+				if cr.Message.Content == "" {
+					return
+				}
+
+				done = false
+			}
+
+			reply.WriteString(cr.Message.Content)
+
+			send(map[string]interface{}{
+				"type": "chat",
+				"data": cr.Message.Content,
+				"done": done,
+			})
+		}
+
+		err := m.provider.Chat(ctx, request, callback)
+
+		if err != nil && len(request.Tools) > 0 && unsupportedTools(err) {
+			slog.Info("model does not support tools, chatting without them", "model", request.Model)
+
+			request.Tools = nil
+			err = m.provider.Chat(ctx, request, callback)
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if len(calls) == 0 {
+			return reply.String(), nil
+		}
+
+		if round == maxToolRounds {
+			return "", errToolRounds
+		}
+
+		for i := range calls {
+			if calls[i].ID == "" {
+				calls[i].ID = "call_" + newID()
+			}
+		}
+
+		request.Messages = append(request.Messages, ai.Message{Role: ai.ASSISTANT, ToolCalls: calls})
+
+		// the model may only call the tools offered to it
+		offered := make([]string, 0, len(request.Tools))
+
+		for _, tool := range request.Tools {
+			offered = append(offered, tool.Function.Name)
+		}
+
+		for _, call := range calls {
+			request.Messages = append(request.Messages, m.call(ctx, claims, offered, call, send))
+		}
+	}
+}
+
+// call runs the tool the model asked for and returns the message feeding the result back to the model
+func (m *Service) call(ctx context.Context, claims *auth.Claims, offered []string, call ai.ToolCall, send func(map[string]interface{})) ai.Message {
+	send(map[string]interface{}{
+		"type":      "tool_call",
+		"id":        call.ID,
+		"name":      call.Function.Name,
+		"arguments": call.Function.Arguments,
+	})
+
+	result, err := m.tools.Call(ctx, claims, offered, call)
+
+	frame := map[string]interface{}{
+		"type": "tool_result",
+		"id":   call.ID,
+		"name": call.Function.Name,
+	}
+
+	if err != nil {
+		slog.Error("tool call failed", "tool", call.Function.Name, "error", err)
+
+		// the model is told about the failure so it can answer without the result
+		result = "error: " + err.Error()
+		frame["error"] = err.Error()
+	} else {
+		frame["result"] = result
+	}
+
+	send(frame)
+
+	return ai.Message{
+		Role:       ai.TOOL,
+		Content:    result,
+		ToolCallID: call.ID,
+		ToolName:   call.Function.Name,
+	}
+}
+
+// unsupportedTools reports whether the chat failed because the model cannot call tools
+func unsupportedTools(err error) bool {
+	var apiErr *ai.APIError
+
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.Message, "does not support tools")
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"pkg/ai"
+	"pkg/auth"
+	mock_kafka "pkg/kafka/mocks"
+	"strings"
+	"testing"
+	"time"
+	"websocket/internal/tools"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// caller is a fake provider calling the first tool offered, then answering with its result
+type caller struct {
+	ai.Fake
+	requests chan *ai.ChatRequest
+}
+
+func (c *caller) Chat(ctx context.Context, request *ai.ChatRequest, cb ai.ChatCallBack) error {
+	// the messages keep growing once the request is recorded
+	recorded := *request
+	recorded.Messages = append([]ai.Message(nil), request.Messages...)
+	c.requests <- &recorded
+
+	last := request.Messages[len(request.Messages)-1]
+
+	if last.Role == ai.TOOL {
+		cb(&ai.ChatResponse{Message: ai.Message{Role: ai.ASSISTANT, Content: "It is " + last.Content}})
+		cb(&ai.ChatResponse{Message: ai.Message{Role: ai.ASSISTANT}, Done: true})
+
+		return nil
+	}
+
+	// the model calls the first tool offered, or the one the prompt names
+	name := request.Tools[0].Function.Name
+
+	if tool, ok := strings.CutPrefix(last.Content, "call "); ok {
+		name = tool
+	}
+
+	cb(&ai.ChatResponse{Message: ai.Message{
+		Role: ai.ASSISTANT,
+		ToolCalls: []ai.ToolCall{{Function: ai.ToolCallFunction{
+			Name:      name,
+			Arguments: json.RawMessage(`{"city":"Paris"}`),
+		}}},
+	}})
+	cb(&ai.ChatResponse{Message: ai.Message{Role: ai.ASSISTANT}, Done: true})
+
+	return nil
+}
+
+func TestTools(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	registry := tools.NewRegistry()
+
+	registry.Register(tools.Tool{
+		Name: "weather",
+		Call: func(ctx context.Context, claims *auth.Claims, arguments json.RawMessage) (string, error) {
+			return "sunny for " + claims.UserID, nil
+		},
+	})
+	registry.Register(tools.Tool{
+		Name:    "slow",
+		Timeout: 10 * time.Millisecond,
+		Call: func(ctx context.Context, claims *auth.Claims, arguments json.RawMessage) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+	})
+	registry.Register(tools.Tool{Name: "purge", Roles: []string{"admin"}, Call: func(ctx context.Context, claims *auth.Claims, arguments json.RawMessage) (string, error) {
+		return "purged", nil
+	}})
+
+	provider := &caller{requests: make(chan *ai.ChatRequest, 16)}
+
+	_, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_kafka.NewMockProducer(ctrl), authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(provider),
+		WithTools(registry),
+	)
+
+	conn := dial(t, url, "token")
+
+	// frames reads the frames of the request until the chat is done
+	frames := func(t *testing.T, requestID string) []map[string]interface{} {
+		var frames []map[string]interface{}
+
+		for {
+			frame, err := readFrame(t, conn)
+			assert.NoError(t, err)
+
+			if frame["request_id"] != requestID {
+				continue
+			}
+
+			frames = append(frames, frame)
+
+			if frame["type"] == "chat" && frame["done"] == true {
+				return frames
+			}
+		}
+	}
+
+	t.Run("call", func(t *testing.T) {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "weather in Paris?", "request_id": "weather", "tools": []string{"weather"}})
+
+		received := frames(t, "weather")
+
+		assert.Len(t, received, 4)
+		assert.Equal(t, "tool_call", received[0]["type"])
+		assert.Equal(t, "weather", received[0]["name"])
+		assert.Equal(t, map[string]interface{}{"city": "Paris"}, received[0]["arguments"])
+		assert.Equal(t, "tool_result", received[1]["type"])
+		assert.Equal(t, received[0]["id"], received[1]["id"])
+		assert.Equal(t, "sunny for 1", received[1]["result"])
+		assert.Equal(t, "It is sunny for 1", received[2]["data"])
+
+		first := <-provider.requests
+		assert.Len(t, first.Tools, 1)
+
+		// the call and its result are fed back to the model
+		second := <-provider.requests
+		assert.Len(t, second.Messages, 3)
+		assert.Equal(t, "weather", second.Messages[1].ToolCalls[0].Function.Name)
+		assert.Equal(t, ai.TOOL, second.Messages[2].Role)
+		assert.Equal(t, received[0]["id"], second.Messages[2].ToolCallID)
+		assert.Equal(t, "sunny for 1", second.Messages[2].Content)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "hurry", "request_id": "slow", "tools": []string{"slow"}})
+
+		received := frames(t, "slow")
+
+		assert.Equal(t, "tool_result", received[1]["type"])
+		assert.Equal(t, "tool timed out", received[1]["error"])
+		assert.Equal(t, "It is error: tool timed out", received[2]["data"])
+
+		<-provider.requests
+		<-provider.requests
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "clean up", "request_id": "purge", "tools": []string{"purge"}})
+
+		received := frames(t, "purge")
+
+		assert.Len(t, received, 1)
+		assert.Equal(t, `tool not allowed "purge"`, received[0]["error"])
+	})
+
+	t.Run("not offered", func(t *testing.T) {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "data": "call weather", "request_id": "sneaky", "tools": []string{"slow"}})
+
+		received := frames(t, "sneaky")
+
+		assert.Equal(t, "tool_result", received[1]["type"])
+		assert.Equal(t, `tool not allowed "weather"`, received[1]["error"])
+		assert.Equal(t, `It is error: tool not allowed "weather"`, received[2]["data"])
+
+		<-provider.requests
+		<-provider.requests
+	})
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"pkg/ai"
+	"pkg/auth"
+	"strings"
+	"time"
+	"websocket/internal/store"
+)
+
+var (
+	errTokenQuota   = errors.New("daily token quota exceeded")
+	errRequestQuota = errors.New("daily request quota exceeded")
+)
+
+// Quota is how much a user can generate per day, unlimited when zero
+type Quota struct {
+	Tokens   int64 `json:"tokens"`   // prompt and completion tokens
+	Requests int64 `json:"requests"` // number of chats
+}
+
+// ParseQuotas parses the daily quotas per role from json, such as
+// {"*": {"tokens": 200000, "requests": 1000}, "admin": {}}
+func ParseQuotas(data string) (map[string]Quota, error) {
+	quotas := make(map[string]Quota)
+
+	if strings.TrimSpace(data) == "" {
+		return quotas, nil
+	}
+
+	if err := json.Unmarshal([]byte(data), &quotas); err != nil {
+		return nil, fmt.Errorf("invalid quotas: %w", err)
+	}
+
+	for role, quota := range quotas {
+		if quota.Tokens < 0 || quota.Requests < 0 {
+			return nil, fmt.Errorf("the quota of %q must not be negative", role)
+		}
+	}
+
+	return quotas, nil
+}
+
+// today is the day the usage is recorded on
+func today() string {
+	return time.Now().UTC().Format(time.DateOnly)
+}
+
+// quotaOf returns the quota of the role, the quota of every role applies when it has none of its own
+func (m *Service) quotaOf(role string) Quota {
+	if quota, ok := m.quotas[role]; ok {
+		return quota
+	}
+
+	return m.quotas[anyRole]
+}
+
+// used returns the usage of the user today across every model
+func (m *Service) used(ctx context.Context, user string) (*store.Usage, error) {
+	day := today()
+	totals, err := m.store.Usage(ctx, store.UsageFilter{Owner: user, From: day, To: day})
+
+	if err != nil {
+		return nil, err
+	}
+
+	used := &store.Usage{Owner: user, Day: day}
+
+	for _, total := range totals {
+		used.Requests += total.Requests
+		used.PromptTokens += total.PromptTokens
+		used.CompletionTokens += total.CompletionTokens
+		used.Duration += total.Duration
+	}
+
+	return used, nil
+}
+
+// quota checks the user can start another chat today. The chat is let through when the usage
+// cannot be loaded, metering must not take the chats down.
+func (m *Service) quota(ctx context.Context, user, role string) error {
+	quota := m.quotaOf(role)
+
+	if quota.Tokens == 0 && quota.Requests == 0 {
+		return nil
+	}
+
+	used, err := m.used(ctx, user)
+
+	if err != nil {
+		slog.Error("unable to load usage", "user", user, "error", err)
+		return nil
+	}
+
+	if quota.Tokens > 0 && used.PromptTokens+used.CompletionTokens >= quota.Tokens {
+		return errTokenQuota
+	}
+
+	if quota.Requests > 0 && used.Requests >= quota.Requests {
+		return errRequestQuota
+	}
+
+	return nil
+}
+
+// metered starts metering a chat of the user with the model
+func metered(claims *auth.Claims, model string) *store.Usage {
+	usage := &store.Usage{Model: model, Requests: 1}
+
+	if claims != nil {
+		usage.Owner = claims.UserID
+		usage.Org = claims.Org
+	}
+
+	return usage
+}
+
+// meter adds the statistics reported by the last chunk of a generation to the usage
+func meter(usage *store.Usage, cr *ai.ChatResponse) {
+	if !cr.Done {
+		return
+	}
+
+	usage.PromptTokens += int64(cr.PromptEvalCount)
+	usage.CompletionTokens += int64(cr.EvalCount)
+	usage.Duration += time.Duration(cr.TotalDuration)
+}
+
+// record adds the usage of a chat to the daily totals of the user
+func (m *Service) record(usage *store.Usage) {
+	if usage.Owner == "" {
+		return
+	}
+
+	usage.Day = today()
+
+	if err := m.store.RecordUsage(m.ctx, usage); err != nil {
+		slog.Error("unable to record usage", "user", usage.Owner, "model", usage.Model, "error", err)
+	}
+}
+
+// Usage returns the handler of the usage api, serving /usage. The users see their own daily usage
+// per model, the admins can see the usage of their organization with ?scope=org. The days default
+// to the last 30 and can be set with ?from= and ?to= such as 2006-01-02.
+func (m *Service) Usage() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /usage", m.authorized(apiError, m.listUsage))
+
+	return mux
+}
+
+func (m *Service) listUsage(w http.ResponseWriter, r *http.Request, claims *auth.Claims) {
+	query := r.URL.Query()
+	now := time.Now().UTC()
+
+	filter := store.UsageFilter{
+		Owner: claims.UserID,
+		From:  query.Get("from"),
+		To:    query.Get("to"),
+	}
+
+	if filter.From == "" {
+		filter.From = now.AddDate(0, 0, -29).Format(time.DateOnly)
+	}
+
+	if filter.To == "" {
+		filter.To = now.Format(time.DateOnly)
+	}
+
+	for _, day := range []string{filter.From, filter.To} {
+		if _, err := time.Parse(time.DateOnly, day); err != nil {
+			apiError(w, http.StatusBadRequest, fmt.Sprintf("invalid day %q, expected such as 2006-01-02", day))
+			return
+		}
+	}
+
+	switch query.Get("scope") {
+	case "", "user":
+	case "org":
+		if claims.Role != "admin" || claims.Org == "" {
+			apiError(w, http.StatusForbidden, "only the admins of an organization can see its usage")
+			return
+		}
+
+		filter.Owner, filter.Org = "", claims.Org
+	default:
+		apiError(w, http.StatusBadRequest, `scope must be "user" or "org"`)
+		return
+	}
+
+	totals, err := m.store.Usage(r.Context(), filter)
+
+	if err != nil {
+		slog.Error("unable to load usage", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to load usage")
+
+		return
+	}
+
+	used, err := m.used(r.Context(), claims.UserID)
+
+	if err != nil {
+		slog.Error("unable to load usage", "error", err)
+		apiError(w, http.StatusInternalServerError, "unable to load usage")
+
+		return
+	}
+
+	quota := m.quotaOf(claims.Role)
+
+	respond(w, http.StatusOK, map[string]interface{}{
+		"data": totals,
+		"quota": map[string]interface{}{
+			"tokens":        quota.Tokens,
+			"requests":      quota.Requests,
+			"used_tokens":   used.PromptTokens + used.CompletionTokens,
+			"used_requests": used.Requests,
+		},
+	})
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pkg/ai"
+	"pkg/auth"
+	mock_kafka "pkg/kafka/mocks"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuotas(t *testing.T) {
+	quotas, err := ParseQuotas(`{"*": {"tokens": 1000, "requests": 10}, "admin": {}}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Quota{Tokens: 1000, Requests: 10}, quotas["*"])
+	assert.Equal(t, Quota{}, quotas["admin"])
+
+	_, err = ParseQuotas(`{"*": {"tokens": -1}}`)
+	assert.EqualError(t, err, `the quota of "*" must not be negative`)
+
+	quotas, err = ParseQuotas("")
+	assert.NoError(t, err)
+	assert.Empty(t, quotas)
+}
+
+func TestUsage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	authServer := newAuthServer()
+	authServer.issue("token", "1", time.Now().Add(time.Hour))
+
+	service, url := serveWith(t, mock_kafka.NewMockConsumer(ctrl), mock_kafka.NewMockProducer(ctrl), authServer, nil,
+		WithVerifyInterval(0),
+		WithProvider(&ai.Fake{Reply: "hello there"}),
+		WithQuotas(map[string]Quota{"*": {Requests: 2}, "admin": {}}),
+		WithAPIKeys(map[string]*auth.Claims{
+			"sk-admin":  {UserID: "1", Org: "acme", Role: "admin"},
+			"sk-member": {UserID: "2", Org: "acme", Role: "member"},
+		}),
+	)
+
+	usage := httptest.NewServer(service.Usage())
+	t.Cleanup(usage.Close)
+
+	completions := httptest.NewServer(service.OpenAI())
+	t.Cleanup(completions.Close)
+
+	request := func(server *httptest.Server, method, path, key string, body interface{}) (int, map[string]interface{}) {
+		data, _ := json.Marshal(body)
+
+		req, _ := http.NewRequest(method, server.URL+path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer "+key)
+
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+
+		response := map[string]interface{}{}
+		json.NewDecoder(res.Body).Decode(&response)
+
+		return res.StatusCode, response
+	}
+
+	conn := dial(t, url, "token")
+
+	// chat sends the message and returns the last frame of the response
+	chat := func(requestID string) map[string]interface{} {
+		conn.WriteJSON(map[string]interface{}{"type": "chat", "model": "phi", "data": "hi", "request_id": requestID})
+
+		for {
+			frame, err := readFrame(t, conn)
+			assert.NoError(t, err)
+
+			if frame["request_id"] == requestID && frame["done"] == true {
+				return frame
+			}
+		}
+	}
+
+	assert.Empty(t, chat("first")["error"])
+	assert.Empty(t, chat("second")["error"])
+
+	// the usage is recorded once the response has been sent
+	assert.Eventually(t, func() bool {
+		used, err := service.used(context.Background(), "1")
+		return err == nil && used.Requests == 2
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "daily request quota exceeded", chat("third")["error"])
+
+	status, _ := request(completions, http.MethodPost, "/v1/chat/completions", "sk-member", map[string]interface{}{
+		"model":    "phi",
+		"messages": []map[string]string{{"role": "user", "content": "hi"}},
+	})
+	assert.Equal(t, http.StatusOK, status)
+
+	t.Run("own usage", func(t *testing.T) {
+		status, response := request(usage, http.MethodGet, "/usage", "sk-admin", nil)
+
+		assert.Equal(t, http.StatusOK, status)
+
+		data := response["data"].([]interface{})
+		assert.Len(t, data, 1)
+
+		total := data[0].(map[string]interface{})
+		assert.Equal(t, "phi", total["model"])
+		assert.Equal(t, time.Now().UTC().Format(time.DateOnly), total["day"])
+		assert.Equal(t, float64(2), total["requests"])
+		assert.Equal(t, float64(2), total["prompt_tokens"])
+		assert.Equal(t, float64(4), total["completion_tokens"])
+
+		// the admins have no quota
+		quota := response["quota"].(map[string]interface{})
+		assert.Equal(t, float64(0), quota["requests"])
+		assert.Equal(t, float64(2), quota["used_requests"])
+		assert.Equal(t, float64(6), quota["used_tokens"])
+	})
+
+	t.Run("embeddings", func(t *testing.T) {
+		status, response := request(completions, http.MethodPost, "/v1/embeddings", "sk-member", map[string]interface{}{
+			"model": "phi",
+			"input": "the quick brown fox",
+		})
+
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, float64(5), response["usage"].(map[string]interface{})["prompt_tokens"])
+
+		// the embeddings count against the quota along with the chats
+		assert.Eventually(t, func() bool {
+			used, err := service.used(context.Background(), "2")
+			return err == nil && used.Requests == 2
+		}, time.Second, 10*time.Millisecond)
+
+		status, response = request(completions, http.MethodPost, "/v1/embeddings", "sk-member", map[string]interface{}{
+			"model": "phi",
+			"input": "the quick brown fox",
+		})
+
+		assert.Equal(t, http.StatusTooManyRequests, status)
+		assert.Equal(t, "daily request quota exceeded", response["error"].(map[string]interface{})["message"])
+	})
+
+	t.Run("organization usage", func(t *testing.T) {
+		status, response := request(usage, http.MethodGet, "/usage?scope=org", "sk-admin", nil)
+
+		assert.Equal(t, http.StatusOK, status)
+
+		// the websocket chats of the first user carry no organization
+		data := response["data"].([]interface{})
+		assert.Len(t, data, 1)
+		assert.Equal(t, "2", data[0].(map[string]interface{})["user_id"])
+
+		status, _ = request(usage, http.MethodGet, "/usage?scope=org", "sk-member", nil)
+		assert.Equal(t, http.StatusForbidden, status)
+
+		status, _ = request(usage, http.MethodGet, "/usage?from=yesterday", "sk-member", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
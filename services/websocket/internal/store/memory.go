@@ -0,0 +1,475 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// key identifies a record of a user
+type key struct {
+	owner string
+	id    string
+}
+
+// Memory keeps the records in memory, they are lost on restart and not shared between instances
+type Memory struct {
+	mu            sync.Mutex
+	personas      map[key]*Persona
+	templates     map[key]*Template
+	conversations map[key]*Conversation
+	uploads       map[key]*Upload
+	documents     map[key]*Document
+	chunks        map[key][]Chunk // chunks of the documents
+	usage         map[usageKey]*Usage
+}
+
+// usageKey identifies the daily totals of a user and model
+type usageKey struct {
+	owner string
+	model string
+	day   string
+}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		personas:      make(map[key]*Persona),
+		templates:     make(map[key]*Template),
+		conversations: make(map[key]*Conversation),
+		uploads:       make(map[key]*Upload),
+		documents:     make(map[key]*Document),
+		chunks:        make(map[key][]Chunk),
+		usage:         make(map[usageKey]*Usage),
+	}
+}
+
+func (m *Memory) Personas(ctx context.Context, owner string) ([]*Persona, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	personas := []*Persona{}
+
+	for k, persona := range m.personas {
+		if k.owner == owner {
+			copy := *persona
+			personas = append(personas, &copy)
+		}
+	}
+
+	slices.SortFunc(personas, func(a, b *Persona) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return personas, nil
+}
+
+func (m *Memory) Persona(ctx context.Context, owner, id string) (*Persona, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	persona, ok := m.personas[key{owner, id}]
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	copy := *persona
+
+	return &copy, nil
+}
+
+func (m *Memory) SavePersona(ctx context.Context, persona *Persona) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	k := key{persona.Owner, persona.ID}
+
+	if existing, ok := m.personas[k]; ok {
+		persona.CreatedAt = existing.CreatedAt
+	} else {
+		persona.CreatedAt = now
+	}
+
+	persona.UpdatedAt = now
+
+	copy := *persona
+	m.personas[k] = &copy
+
+	return nil
+}
+
+func (m *Memory) DeletePersona(ctx context.Context, owner, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := key{owner, id}
+
+	if _, ok := m.personas[k]; !ok {
+		return ErrNotFound
+	}
+
+	delete(m.personas, k)
+
+	return nil
+}
+
+// visible reports whether the template belongs to the user or is shared within the organization
+func (t *Template) visible(owner, org string) bool {
+	return t.Owner == owner || (t.Visibility == Shared && org != "" && t.Org == org)
+}
+
+func (m *Memory) Templates(ctx context.Context, owner, org string) ([]*Template, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	templates := []*Template{}
+
+	for _, template := range m.templates {
+		if template.visible(owner, org) {
+			copy := *template
+			templates = append(templates, &copy)
+		}
+	}
+
+	slices.SortFunc(templates, func(a, b *Template) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), strings.Compare(a.ID, b.ID))
+	})
+
+	return templates, nil
+}
+
+func (m *Memory) Template(ctx context.Context, owner, org, id string) (*Template, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, template := range m.templates {
+		if k.id == id && template.visible(owner, org) {
+			copy := *template
+			return &copy, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *Memory) SaveTemplate(ctx context.Context, template *Template) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	k := key{template.Owner, template.ID}
+
+	if existing, ok := m.templates[k]; ok {
+		template.CreatedAt = existing.CreatedAt
+	} else {
+		template.CreatedAt = now
+	}
+
+	template.UpdatedAt = now
+
+	copy := *template
+	m.templates[k] = &copy
+
+	return nil
+}
+
+func (m *Memory) DeleteTemplate(ctx context.Context, owner, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := key{owner, id}
+
+	if _, ok := m.templates[k]; !ok {
+		return ErrNotFound
+	}
+
+	delete(m.templates, k)
+
+	return nil
+}
+
+func (m *Memory) Conversation(ctx context.Context, owner, id string) (*Conversation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conversation, ok := m.conversations[key{owner, id}]
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	copy := *conversation
+	copy.Messages = slices.Clone(conversation.Messages)
+
+	return &copy, nil
+}
+
+func (m *Memory) SaveConversation(ctx context.Context, conversation *Conversation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	k := key{conversation.Owner, conversation.ID}
+
+	copy := *conversation
+
+	if existing, ok := m.conversations[k]; ok {
+		copy.CreatedAt = existing.CreatedAt
+		copy.Messages = existing.Messages
+		copy.Title = existing.Title
+		copy.Summary = existing.Summary
+		copy.Summarized = existing.Summarized
+	} else {
+		copy.CreatedAt = now
+		copy.Messages = nil
+		copy.Title = ""
+		copy.Summary = ""
+		copy.Summarized = 0
+	}
+
+	copy.UpdatedAt = now
+	m.conversations[k] = &copy
+
+	conversation.CreatedAt = copy.CreatedAt
+	conversation.UpdatedAt = copy.UpdatedAt
+
+	return nil
+}
+
+func (m *Memory) AppendMessages(ctx context.Context, owner, id string, messages ...Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conversation, ok := m.conversations[key{owner, id}]
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	conversation.Messages = append(conversation.Messages, messages...)
+	conversation.UpdatedAt = time.Now()
+
+	return nil
+}
+
+func (m *Memory) SaveTitle(ctx context.Context, owner, id, title string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conversation, ok := m.conversations[key{owner, id}]
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	conversation.Title = title
+
+	return nil
+}
+
+func (m *Memory) SaveSummary(ctx context.Context, owner, id, summary string, summarized int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conversation, ok := m.conversations[key{owner, id}]
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	conversation.Summary = summary
+	conversation.Summarized = summarized
+
+	return nil
+}
+
+func (m *Memory) Upload(ctx context.Context, owner, id string) (*Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, ok := m.uploads[key{owner, id}]
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	copy := *upload
+
+	return &copy, nil
+}
+
+func (m *Memory) SaveUpload(ctx context.Context, upload *Upload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload.CreatedAt = time.Now()
+
+	copy := *upload
+	m.uploads[key{upload.Owner, upload.ID}] = &copy
+
+	return nil
+}
+
+func (m *Memory) DeleteUpload(ctx context.Context, owner, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := key{owner, id}
+
+	if _, ok := m.uploads[k]; !ok {
+		return ErrNotFound
+	}
+
+	delete(m.uploads, k)
+
+	return nil
+}
+
+func (m *Memory) Documents(ctx context.Context, owner string) ([]*Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	documents := []*Document{}
+
+	for k, document := range m.documents {
+		if k.owner == owner {
+			copy := *document
+			documents = append(documents, &copy)
+		}
+	}
+
+	slices.SortFunc(documents, func(a, b *Document) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return documents, nil
+}
+
+func (m *Memory) Document(ctx context.Context, owner, id string) (*Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	document, ok := m.documents[key{owner, id}]
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	copy := *document
+
+	return &copy, nil
+}
+
+func (m *Memory) SaveDocument(ctx context.Context, document *Document, chunks []Chunk) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	document.CreatedAt = time.Now()
+	document.Chunks = len(chunks)
+
+	k := key{document.Owner, document.ID}
+	copy := *document
+
+	m.documents[k] = &copy
+	m.chunks[k] = slices.Clone(chunks)
+
+	return nil
+}
+
+func (m *Memory) DeleteDocument(ctx context.Context, owner, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := key{owner, id}
+
+	if _, ok := m.documents[k]; !ok {
+		return ErrNotFound
+	}
+
+	delete(m.documents, k)
+	delete(m.chunks, k)
+
+	return nil
+}
+
+// Search compares the embedding with every chunk of the documents of the user
+func (m *Memory) Search(ctx context.Context, owner string, embedding []float32, limit int) ([]Match, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matches := []Match{}
+
+	for k, chunks := range m.chunks {
+		if k.owner != owner {
+			continue
+		}
+
+		for _, chunk := range chunks {
+			matches = append(matches, Match{
+				Document: k.id,
+				Name:     m.documents[k].Name,
+				Index:    chunk.Index,
+				Content:  chunk.Content,
+				Score:    similarity(embedding, chunk.Embedding),
+			})
+		}
+	}
+
+	return best(matches, limit), nil
+}
+
+func (m *Memory) RecordUsage(ctx context.Context, usage *Usage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := usageKey{usage.Owner, usage.Model, usage.Day}
+	total, ok := m.usage[k]
+
+	if !ok {
+		total = &Usage{Owner: usage.Owner, Model: usage.Model, Day: usage.Day}
+		m.usage[k] = total
+	}
+
+	total.Org = usage.Org
+	total.Requests += usage.Requests
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.Duration += usage.Duration
+
+	return nil
+}
+
+func (m *Memory) Usage(ctx context.Context, filter UsageFilter) ([]*Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	totals := []*Usage{}
+
+	for _, usage := range m.usage {
+		switch {
+		case filter.Owner != "" && usage.Owner != filter.Owner,
+			filter.Org != "" && usage.Org != filter.Org,
+			filter.From != "" && usage.Day < filter.From,
+			filter.To != "" && usage.Day > filter.To:
+			continue
+		}
+
+		copy := *usage
+		totals = append(totals, &copy)
+	}
+
+	slices.SortFunc(totals, func(a, b *Usage) int {
+		return cmp.Or(
+			strings.Compare(a.Day, b.Day),
+			strings.Compare(a.Owner, b.Owner),
+			strings.Compare(a.Model, b.Model),
+		)
+	})
+
+	return totals, nil
+}
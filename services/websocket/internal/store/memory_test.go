@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"pkg/ai"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryPersonas(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	assert.NoError(t, store.SavePersona(ctx, &Persona{ID: "2", Owner: "1", Name: "pirate", SystemPrompt: "talk like a pirate"}))
+	assert.NoError(t, store.SavePersona(ctx, &Persona{ID: "1", Owner: "1", Name: "chef", SystemPrompt: "answer like a chef"}))
+	assert.NoError(t, store.SavePersona(ctx, &Persona{ID: "3", Owner: "2", Name: "poet", SystemPrompt: "answer in verse"}))
+
+	personas, err := store.Personas(ctx, "1")
+	assert.NoError(t, err)
+	assert.Len(t, personas, 2)
+	assert.Equal(t, "chef", personas[0].Name)
+	assert.Equal(t, "pirate", personas[1].Name)
+
+	// personas of other users are not visible
+	_, err = store.Persona(ctx, "1", "3")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	created := personas[1].CreatedAt
+
+	assert.NoError(t, store.SavePersona(ctx, &Persona{ID: "2", Owner: "1", Name: "pirate", SystemPrompt: "talk like a polite pirate"}))
+
+	persona, err := store.Persona(ctx, "1", "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "talk like a polite pirate", persona.SystemPrompt)
+	assert.Equal(t, created, persona.CreatedAt)
+
+	assert.NoError(t, store.DeletePersona(ctx, "1", "2"))
+	assert.ErrorIs(t, store.DeletePersona(ctx, "1", "2"), ErrNotFound)
+}
+
+func TestMemoryTemplates(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	assert.NoError(t, store.SaveTemplate(ctx, &Template{ID: "1", Owner: "1", Org: "acme", Name: "summarize", Content: "Summarize {{text}}", Visibility: Private}))
+	assert.NoError(t, store.SaveTemplate(ctx, &Template{ID: "2", Owner: "1", Org: "acme", Name: "commit", Content: "Write a commit message for {{diff}}", Visibility: Shared}))
+	assert.NoError(t, store.SaveTemplate(ctx, &Template{ID: "3", Owner: "3", Org: "other", Name: "review", Content: "Review {{code}}", Visibility: Shared}))
+
+	templates, err := store.Templates(ctx, "1", "acme")
+	assert.NoError(t, err)
+	assert.Len(t, templates, 2)
+	assert.Equal(t, "commit", templates[0].Name)
+	assert.Equal(t, "summarize", templates[1].Name)
+
+	// the members of the organization only see the shared templates
+	templates, err = store.Templates(ctx, "2", "acme")
+	assert.NoError(t, err)
+	assert.Len(t, templates, 1)
+	assert.Equal(t, "2", templates[0].ID)
+
+	_, err = store.Template(ctx, "2", "acme", "1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	template, err := store.Template(ctx, "2", "acme", "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", template.Owner)
+
+	// the templates are not shared with the users without an organization
+	templates, err = store.Templates(ctx, "4", "")
+	assert.NoError(t, err)
+	assert.Empty(t, templates)
+
+	// only the owner can delete the template
+	assert.ErrorIs(t, store.DeleteTemplate(ctx, "2", "2"), ErrNotFound)
+	assert.NoError(t, store.DeleteTemplate(ctx, "1", "2"))
+
+	_, err = store.Template(ctx, "2", "acme", "2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemoryConversations(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	hi := Message{Message: ai.Message{Role: ai.USER, Content: "hi"}}
+	ahoy := Message{
+		Message:     ai.Message{Role: ai.ASSISTANT, Content: "ahoy"},
+		Attachments: []Attachment{{Upload: "map", ContentType: "image/png", Size: 42}},
+	}
+
+	assert.ErrorIs(t, store.AppendMessages(ctx, "1", "chat", hi), ErrNotFound)
+
+	assert.NoError(t, store.SaveConversation(ctx, &Conversation{ID: "chat", Owner: "1", Persona: "pirate"}))
+	assert.NoError(t, store.AppendMessages(ctx, "1", "chat", hi, ahoy))
+
+	// updating the settings keeps the messages
+	assert.NoError(t, store.SaveConversation(ctx, &Conversation{ID: "chat", Owner: "1", SystemPrompt: "be brief"}))
+
+	conversation, err := store.Conversation(ctx, "1", "chat")
+	assert.NoError(t, err)
+	assert.Equal(t, "be brief", conversation.SystemPrompt)
+	assert.Empty(t, conversation.Persona)
+	assert.Equal(t, []Message{hi, ahoy}, conversation.Messages)
+
+	_, err = store.Conversation(ctx, "2", "chat")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.ErrorIs(t, store.SaveSummary(ctx, "2", "chat", "greetings", 2), ErrNotFound)
+	assert.NoError(t, store.SaveSummary(ctx, "1", "chat", "greetings", 2))
+	assert.ErrorIs(t, store.SaveTitle(ctx, "2", "chat", "Pirate greetings"), ErrNotFound)
+	assert.NoError(t, store.SaveTitle(ctx, "1", "chat", "Pirate greetings"))
+
+	// updating the settings keeps the title and summary
+	assert.NoError(t, store.SaveConversation(ctx, &Conversation{ID: "chat", Owner: "1", SystemPrompt: "be briefer"}))
+
+	conversation, err = store.Conversation(ctx, "1", "chat")
+	assert.NoError(t, err)
+	assert.Equal(t, "Pirate greetings", conversation.Title)
+	assert.Equal(t, "greetings", conversation.Summary)
+	assert.Equal(t, 2, conversation.Summarized)
+}
+
+func TestMemoryUploads(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	assert.NoError(t, store.SaveUpload(ctx, &Upload{ID: "map", Owner: "1", ContentType: "image/png", Size: 3, Data: []byte("png")}))
+
+	upload, err := store.Upload(ctx, "1", "map")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("png"), upload.Data)
+	assert.False(t, upload.CreatedAt.IsZero())
+
+	_, err = store.Upload(ctx, "2", "map")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, store.DeleteUpload(ctx, "1", "map"))
+	assert.ErrorIs(t, store.DeleteUpload(ctx, "1", "map"), ErrNotFound)
+}
+
+func TestMemoryDocuments(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	assert.NoError(t, store.SaveDocument(ctx, &Document{ID: "runbook", Owner: "1", Name: "runbook.md"}, []Chunk{
+		{Index: 0, Content: "restart the database", Embedding: []float32{1, 0}},
+		{Index: 1, Content: "rotate the certificates", Embedding: []float32{0, 1}},
+	}))
+	assert.NoError(t, store.SaveDocument(ctx, &Document{ID: "secret", Owner: "2", Name: "secret.md"}, []Chunk{
+		{Index: 0, Content: "restart everything", Embedding: []float32{1, 0}},
+	}))
+
+	documents, err := store.Documents(ctx, "1")
+	assert.NoError(t, err)
+	assert.Len(t, documents, 1)
+	assert.Equal(t, 2, documents[0].Chunks)
+
+	// the chunks of other users are not searched
+	matches, err := store.Search(ctx, "1", []float32{0.9, 0.1}, 1)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, "runbook", matches[0].Document)
+	assert.Equal(t, "runbook.md", matches[0].Name)
+	assert.Equal(t, "restart the database", matches[0].Content)
+	assert.Greater(t, matches[0].Score, 0.9)
+
+	assert.NoError(t, store.DeleteDocument(ctx, "1", "runbook"))
+	assert.ErrorIs(t, store.DeleteDocument(ctx, "1", "runbook"), ErrNotFound)
+
+	matches, err = store.Search(ctx, "1", []float32{1, 0}, 4)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestMemoryUsage(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory()
+
+	assert.NoError(t, store.RecordUsage(ctx, &Usage{Owner: "1", Org: "acme", Model: "phi", Day: "2026-10-18", Requests: 1, PromptTokens: 10, CompletionTokens: 20}))
+	assert.NoError(t, store.RecordUsage(ctx, &Usage{Owner: "1", Org: "acme", Model: "phi", Day: "2026-10-19", Requests: 1, PromptTokens: 5, CompletionTokens: 7}))
+	assert.NoError(t, store.RecordUsage(ctx, &Usage{Owner: "1", Org: "acme", Model: "phi", Day: "2026-10-19", Requests: 1, PromptTokens: 3, CompletionTokens: 4}))
+	assert.NoError(t, store.RecordUsage(ctx, &Usage{Owner: "2", Org: "acme", Model: "llama3", Day: "2026-10-19", Requests: 1, PromptTokens: 1, CompletionTokens: 1}))
+	assert.NoError(t, store.RecordUsage(ctx, &Usage{Owner: "3", Org: "other", Model: "phi", Day: "2026-10-19", Requests: 1}))
+
+	totals, err := store.Usage(ctx, UsageFilter{Owner: "1", From: "2026-10-19"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*Usage{{Owner: "1", Org: "acme", Model: "phi", Day: "2026-10-19", Requests: 2, PromptTokens: 8, CompletionTokens: 11}}, totals)
+
+	totals, err = store.Usage(ctx, UsageFilter{Org: "acme", To: "2026-10-19"})
+	assert.NoError(t, err)
+	assert.Len(t, totals, 3)
+	assert.Equal(t, "2026-10-18", totals[0].Day)
+	assert.Equal(t, "2", totals[2].Owner)
+}
@@ -0,0 +1,845 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres keeps the records in postgres, shared by every instance of the service
+type Postgres struct {
+	db     *sql.DB
+	vector bool // whether the pgvector extension is available to search the chunks
+}
+
+// NewPostgres returns a store backed by the database, creating its tables when missing. The chunks
+// of the documents are searched with pgvector when the extension can be enabled, otherwise they
+// are compared one by one.
+func NewPostgres(db *sql.DB) (*Postgres, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS
+			personas (
+				owner VARCHAR(255) NOT NULL,
+				id VARCHAR(255) NOT NULL,
+				name VARCHAR(255) NOT NULL,
+				system_prompt TEXT NOT NULL,
+				model VARCHAR(255) NOT NULL DEFAULT '',
+				options JSONB,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW (),
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW (),
+				PRIMARY KEY (owner, id)
+			);
+
+		CREATE TABLE IF NOT EXISTS
+			templates (
+				owner VARCHAR(255) NOT NULL,
+				id VARCHAR(255) NOT NULL,
+				org VARCHAR(255) NOT NULL DEFAULT '',
+				name VARCHAR(255) NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				content TEXT NOT NULL,
+				variables TEXT[] NOT NULL,
+				defaults JSONB,
+				visibility VARCHAR(16) NOT NULL DEFAULT 'private',
+				created_at TIMESTAMP NOT NULL DEFAULT NOW (),
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW (),
+				PRIMARY KEY (owner, id)
+			);
+
+		CREATE INDEX IF NOT EXISTS idx_templates_org ON templates (org, visibility);
+
+		CREATE TABLE IF NOT EXISTS
+			conversations (
+				owner VARCHAR(255) NOT NULL,
+				id VARCHAR(255) NOT NULL,
+				title VARCHAR(255) NOT NULL DEFAULT '',
+				persona VARCHAR(255) NOT NULL DEFAULT '',
+				system_prompt TEXT NOT NULL DEFAULT '',
+				retrieval BOOLEAN NOT NULL DEFAULT FALSE,
+				summary TEXT NOT NULL DEFAULT '',
+				summarized INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW (),
+				updated_at TIMESTAMP NOT NULL DEFAULT NOW (),
+				PRIMARY KEY (owner, id)
+			);
+
+		-- the titles and summaries came after the conversations
+		ALTER TABLE conversations ADD COLUMN IF NOT EXISTS title VARCHAR(255) NOT NULL DEFAULT '';
+		ALTER TABLE conversations ADD COLUMN IF NOT EXISTS summary TEXT NOT NULL DEFAULT '';
+		ALTER TABLE conversations ADD COLUMN IF NOT EXISTS summarized INTEGER NOT NULL DEFAULT 0;
+
+		CREATE TABLE IF NOT EXISTS
+			conversation_messages (
+				id BIGSERIAL PRIMARY KEY,
+				owner VARCHAR(255) NOT NULL,
+				conversation VARCHAR(255) NOT NULL,
+				message JSONB NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW (),
+				FOREIGN KEY (owner, conversation) REFERENCES conversations (owner, id) ON DELETE CASCADE
+			);
+
+		CREATE INDEX IF NOT EXISTS idx_conversation_messages_conversation ON conversation_messages (owner, conversation, id);
+
+		CREATE TABLE IF NOT EXISTS
+			uploads (
+				owner VARCHAR(255) NOT NULL,
+				id VARCHAR(255) NOT NULL,
+				name VARCHAR(255) NOT NULL DEFAULT '',
+				content_type VARCHAR(255) NOT NULL,
+				size BIGINT NOT NULL,
+				data BYTEA NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW (),
+				PRIMARY KEY (owner, id)
+			);
+
+		CREATE TABLE IF NOT EXISTS
+			documents (
+				owner VARCHAR(255) NOT NULL,
+				id VARCHAR(255) NOT NULL,
+				name VARCHAR(255) NOT NULL,
+				content_type VARCHAR(255) NOT NULL,
+				size BIGINT NOT NULL,
+				chunks INTEGER NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT NOW (),
+				PRIMARY KEY (owner, id)
+			);
+
+		CREATE TABLE IF NOT EXISTS
+			document_chunks (
+				owner VARCHAR(255) NOT NULL,
+				document VARCHAR(255) NOT NULL,
+				index INTEGER NOT NULL,
+				content TEXT NOT NULL,
+				embedding REAL[] NOT NULL,
+				PRIMARY KEY (owner, document, index),
+				FOREIGN KEY (owner, document) REFERENCES documents (owner, id) ON DELETE CASCADE
+			);
+
+		CREATE TABLE IF NOT EXISTS
+			usage (
+				owner VARCHAR(255) NOT NULL,
+				org VARCHAR(255) NOT NULL DEFAULT '',
+				model VARCHAR(255) NOT NULL,
+				day DATE NOT NULL,
+				requests BIGINT NOT NULL DEFAULT 0,
+				prompt_tokens BIGINT NOT NULL DEFAULT 0,
+				completion_tokens BIGINT NOT NULL DEFAULT 0,
+				duration BIGINT NOT NULL DEFAULT 0,
+				PRIMARY KEY (owner, model, day)
+			);
+
+		CREATE INDEX IF NOT EXISTS idx_usage_org ON usage (org, day);
+	`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the extension is missing from the stock postgres images
+	_, err = db.Exec(`CREATE EXTENSION IF NOT EXISTS vector`)
+
+	return &Postgres{db: db, vector: err == nil}, nil
+}
+
+func (p *Postgres) Personas(ctx context.Context, owner string) ([]*Persona, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT id, owner, name, system_prompt, model, options, created_at, updated_at
+		FROM personas WHERE owner = $1 ORDER BY name
+	`, owner)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	personas := []*Persona{}
+
+	for rows.Next() {
+		persona, err := scanPersona(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		personas = append(personas, persona)
+	}
+
+	return personas, rows.Err()
+}
+
+func (p *Postgres) Persona(ctx context.Context, owner, id string) (*Persona, error) {
+	row := p.db.QueryRowContext(ctx, `
+		SELECT id, owner, name, system_prompt, model, options, created_at, updated_at
+		FROM personas WHERE owner = $1 AND id = $2
+	`, owner, id)
+
+	persona, err := scanPersona(row)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return persona, err
+}
+
+func (p *Postgres) SavePersona(ctx context.Context, persona *Persona) error {
+	options, err := json.Marshal(persona.Options)
+
+	if err != nil {
+		return err
+	}
+
+	return p.db.QueryRowContext(ctx, `
+		INSERT INTO personas (owner, id, name, system_prompt, model, options)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (owner, id) DO UPDATE SET
+			name = EXCLUDED.name,
+			system_prompt = EXCLUDED.system_prompt,
+			model = EXCLUDED.model,
+			options = EXCLUDED.options,
+			updated_at = NOW()
+		RETURNING created_at, updated_at
+	`,
+		persona.Owner,
+		persona.ID,
+		persona.Name,
+		persona.SystemPrompt,
+		persona.Model,
+		options,
+	).Scan(&persona.CreatedAt, &persona.UpdatedAt)
+}
+
+func (p *Postgres) DeletePersona(ctx context.Context, owner, id string) error {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM personas WHERE owner = $1 AND id = $2`, owner, id)
+
+	if err != nil {
+		return err
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (p *Postgres) Conversation(ctx context.Context, owner, id string) (*Conversation, error) {
+	conversation := &Conversation{}
+
+	err := p.db.QueryRowContext(ctx, `
+		SELECT id, owner, title, persona, system_prompt, retrieval, summary, summarized, created_at, updated_at
+		FROM conversations WHERE owner = $1 AND id = $2
+	`, owner, id).Scan(
+		&conversation.ID,
+		&conversation.Owner,
+		&conversation.Title,
+		&conversation.Persona,
+		&conversation.SystemPrompt,
+		&conversation.Retrieval,
+		&conversation.Summary,
+		&conversation.Summarized,
+		&conversation.CreatedAt,
+		&conversation.UpdatedAt,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT message FROM conversation_messages WHERE owner = $1 AND conversation = $2 ORDER BY id
+	`, owner, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var data []byte
+		var message Message
+
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(data, &message); err != nil {
+			return nil, err
+		}
+
+		conversation.Messages = append(conversation.Messages, message)
+	}
+
+	return conversation, rows.Err()
+}
+
+func (p *Postgres) SaveConversation(ctx context.Context, conversation *Conversation) error {
+	return p.db.QueryRowContext(ctx, `
+		INSERT INTO conversations (owner, id, persona, system_prompt, retrieval)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (owner, id) DO UPDATE SET
+			persona = EXCLUDED.persona,
+			system_prompt = EXCLUDED.system_prompt,
+			retrieval = EXCLUDED.retrieval,
+			updated_at = NOW()
+		RETURNING created_at, updated_at
+	`,
+		conversation.Owner,
+		conversation.ID,
+		conversation.Persona,
+		conversation.SystemPrompt,
+		conversation.Retrieval,
+	).Scan(&conversation.CreatedAt, &conversation.UpdatedAt)
+}
+
+func (p *Postgres) SaveTitle(ctx context.Context, owner, id, title string) error {
+	result, err := p.db.ExecContext(ctx, `
+		UPDATE conversations SET title = $3, updated_at = NOW() WHERE owner = $1 AND id = $2
+	`, owner, id, title)
+
+	if err != nil {
+		return err
+	}
+
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (p *Postgres) SaveSummary(ctx context.Context, owner, id, summary string, summarized int) error {
+	result, err := p.db.ExecContext(ctx, `
+		UPDATE conversations SET summary = $3, summarized = $4 WHERE owner = $1 AND id = $2
+	`, owner, id, summary, summarized)
+
+	if err != nil {
+		return err
+	}
+
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (p *Postgres) AppendMessages(ctx context.Context, owner, id string, messages ...Message) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE conversations SET updated_at = NOW() WHERE owner = $1 AND id = $2`, owner, id)
+
+	if err != nil {
+		return err
+	}
+
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNotFound
+	}
+
+	for _, message := range messages {
+		data, err := json.Marshal(message)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO conversation_messages (owner, conversation, message) VALUES ($1, $2, $3)
+		`, owner, id, data)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (p *Postgres) Upload(ctx context.Context, owner, id string) (*Upload, error) {
+	upload := &Upload{}
+
+	err := p.db.QueryRowContext(ctx, `
+		SELECT id, owner, name, content_type, size, data, created_at
+		FROM uploads WHERE owner = $1 AND id = $2
+	`, owner, id).Scan(
+		&upload.ID,
+		&upload.Owner,
+		&upload.Name,
+		&upload.ContentType,
+		&upload.Size,
+		&upload.Data,
+		&upload.CreatedAt,
+	)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+func (p *Postgres) SaveUpload(ctx context.Context, upload *Upload) error {
+	return p.db.QueryRowContext(ctx, `
+		INSERT INTO uploads (owner, id, name, content_type, size, data)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at
+	`,
+		upload.Owner,
+		upload.ID,
+		upload.Name,
+		upload.ContentType,
+		upload.Size,
+		upload.Data,
+	).Scan(&upload.CreatedAt)
+}
+
+func (p *Postgres) DeleteUpload(ctx context.Context, owner, id string) error {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM uploads WHERE owner = $1 AND id = $2`, owner, id)
+
+	if err != nil {
+		return err
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (p *Postgres) Documents(ctx context.Context, owner string) ([]*Document, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT id, owner, name, content_type, size, chunks, created_at
+		FROM documents WHERE owner = $1 ORDER BY name
+	`, owner)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	documents := []*Document{}
+
+	for rows.Next() {
+		document, err := scanDocument(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		documents = append(documents, document)
+	}
+
+	return documents, rows.Err()
+}
+
+func (p *Postgres) Document(ctx context.Context, owner, id string) (*Document, error) {
+	row := p.db.QueryRowContext(ctx, `
+		SELECT id, owner, name, content_type, size, chunks, created_at
+		FROM documents WHERE owner = $1 AND id = $2
+	`, owner, id)
+
+	document, err := scanDocument(row)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return document, err
+}
+
+func (p *Postgres) SaveDocument(ctx context.Context, document *Document, chunks []Chunk) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	document.Chunks = len(chunks)
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO documents (owner, id, name, content_type, size, chunks)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at
+	`,
+		document.Owner,
+		document.ID,
+		document.Name,
+		document.ContentType,
+		document.Size,
+		document.Chunks,
+	).Scan(&document.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO document_chunks (owner, document, index, content, embedding) VALUES ($1, $2, $3, $4, $5)
+		`, document.Owner, document.ID, chunk.Index, chunk.Content, pq.Array(chunk.Embedding))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (p *Postgres) DeleteDocument(ctx context.Context, owner, id string) error {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM documents WHERE owner = $1 AND id = $2`, owner, id)
+
+	if err != nil {
+		return err
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (p *Postgres) Search(ctx context.Context, owner string, embedding []float32, limit int) ([]Match, error) {
+	if p.vector {
+		return p.nearest(ctx, owner, embedding, limit)
+	}
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT c.document, d.name, c.index, c.content, c.embedding
+		FROM document_chunks c JOIN documents d ON d.owner = c.owner AND d.id = c.document
+		WHERE c.owner = $1
+	`, owner)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	matches := []Match{}
+
+	for rows.Next() {
+		var match Match
+		var chunk pq.Float32Array
+
+		if err := rows.Scan(&match.Document, &match.Name, &match.Index, &match.Content, &chunk); err != nil {
+			return nil, err
+		}
+
+		match.Score = similarity(embedding, chunk)
+		matches = append(matches, match)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return best(matches, limit), nil
+}
+
+// nearest searches the chunks with pgvector, skipping the ones embedded with another number of dimensions
+func (p *Postgres) nearest(ctx context.Context, owner string, embedding []float32, limit int) ([]Match, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT c.document, d.name, c.index, c.content, 1 - (c.embedding::vector <=> $2::real[]::vector)
+		FROM document_chunks c JOIN documents d ON d.owner = c.owner AND d.id = c.document
+		WHERE c.owner = $1 AND cardinality(c.embedding) = $3
+		ORDER BY c.embedding::vector <=> $2::real[]::vector
+		LIMIT $4
+	`, owner, pq.Array(embedding), len(embedding), limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	matches := []Match{}
+
+	for rows.Next() {
+		var match Match
+
+		if err := rows.Scan(&match.Document, &match.Name, &match.Index, &match.Content, &match.Score); err != nil {
+			return nil, err
+		}
+
+		matches = append(matches, match)
+	}
+
+	return matches, rows.Err()
+}
+
+func (p *Postgres) RecordUsage(ctx context.Context, usage *Usage) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO usage (owner, org, model, day, requests, prompt_tokens, completion_tokens, duration)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (owner, model, day) DO UPDATE SET
+			org = EXCLUDED.org,
+			requests = usage.requests + EXCLUDED.requests,
+			prompt_tokens = usage.prompt_tokens + EXCLUDED.prompt_tokens,
+			completion_tokens = usage.completion_tokens + EXCLUDED.completion_tokens,
+			duration = usage.duration + EXCLUDED.duration
+	`,
+		usage.Owner,
+		usage.Org,
+		usage.Model,
+		usage.Day,
+		usage.Requests,
+		usage.PromptTokens,
+		usage.CompletionTokens,
+		int64(usage.Duration),
+	)
+
+	return err
+}
+
+func (p *Postgres) Usage(ctx context.Context, filter UsageFilter) ([]*Usage, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT owner, org, model, day, requests, prompt_tokens, completion_tokens, duration
+		FROM usage
+		WHERE ($1 = '' OR owner = $1)
+			AND ($2 = '' OR org = $2)
+			AND day >= COALESCE(NULLIF($3, '')::DATE, day)
+			AND day <= COALESCE(NULLIF($4, '')::DATE, day)
+		ORDER BY day, owner, model
+	`, filter.Owner, filter.Org, filter.From, filter.To)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	totals := []*Usage{}
+
+	for rows.Next() {
+		usage := &Usage{}
+
+		var day time.Time
+		var duration int64
+
+		err := rows.Scan(
+			&usage.Owner,
+			&usage.Org,
+			&usage.Model,
+			&day,
+			&usage.Requests,
+			&usage.PromptTokens,
+			&usage.CompletionTokens,
+			&duration,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		usage.Day = day.Format(time.DateOnly)
+		usage.Duration = time.Duration(duration)
+		totals = append(totals, usage)
+	}
+
+	return totals, rows.Err()
+}
+
+func (p *Postgres) Templates(ctx context.Context, owner, org string) ([]*Template, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT id, owner, org, name, description, content, variables, defaults, visibility, created_at, updated_at
+		FROM templates
+		WHERE owner = $1 OR (visibility = 'org' AND org <> '' AND org = $2)
+		ORDER BY name, id
+	`, owner, org)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	templates := []*Template{}
+
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		templates = append(templates, template)
+	}
+
+	return templates, rows.Err()
+}
+
+func (p *Postgres) Template(ctx context.Context, owner, org, id string) (*Template, error) {
+	row := p.db.QueryRowContext(ctx, `
+		SELECT id, owner, org, name, description, content, variables, defaults, visibility, created_at, updated_at
+		FROM templates
+		WHERE id = $3 AND (owner = $1 OR (visibility = 'org' AND org <> '' AND org = $2))
+		ORDER BY owner = $1 DESC
+		LIMIT 1
+	`, owner, org, id)
+
+	template, err := scanTemplate(row)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return template, err
+}
+
+func (p *Postgres) SaveTemplate(ctx context.Context, template *Template) error {
+	defaults, err := json.Marshal(template.Defaults)
+
+	if err != nil {
+		return err
+	}
+
+	return p.db.QueryRowContext(ctx, `
+		INSERT INTO templates (owner, id, org, name, description, content, variables, defaults, visibility)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (owner, id) DO UPDATE SET
+			org = EXCLUDED.org,
+			name = EXCLUDED.name,
+			description = EXCLUDED.description,
+			content = EXCLUDED.content,
+			variables = EXCLUDED.variables,
+			defaults = EXCLUDED.defaults,
+			visibility = EXCLUDED.visibility,
+			updated_at = NOW()
+		RETURNING created_at, updated_at
+	`,
+		template.Owner,
+		template.ID,
+		template.Org,
+		template.Name,
+		template.Description,
+		template.Content,
+		pq.Array(template.Variables),
+		defaults,
+		template.Visibility,
+	).Scan(&template.CreatedAt, &template.UpdatedAt)
+}
+
+func (p *Postgres) DeleteTemplate(ctx context.Context, owner, id string) error {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM templates WHERE owner = $1 AND id = $2`, owner, id)
+
+	if err != nil {
+		return err
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// scanner is a row of a query
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPersona(row scanner) (*Persona, error) {
+	persona := &Persona{}
+	var options []byte
+
+	err := row.Scan(
+		&persona.ID,
+		&persona.Owner,
+		&persona.Name,
+		&persona.SystemPrompt,
+		&persona.Model,
+		&options,
+		&persona.CreatedAt,
+		&persona.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &persona.Options); err != nil {
+			return nil, err
+		}
+	}
+
+	return persona, nil
+}
+
+func scanDocument(row scanner) (*Document, error) {
+	document := &Document{}
+
+	err := row.Scan(
+		&document.ID,
+		&document.Owner,
+		&document.Name,
+		&document.ContentType,
+		&document.Size,
+		&document.Chunks,
+		&document.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+func scanTemplate(row scanner) (*Template, error) {
+	template := &Template{}
+	var defaults []byte
+
+	err := row.Scan(
+		&template.ID,
+		&template.Owner,
+		&template.Org,
+		&template.Name,
+		&template.Description,
+		&template.Content,
+		pq.Array(&template.Variables),
+		&defaults,
+		&template.Visibility,
+		&template.CreatedAt,
+		&template.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(defaults) > 0 {
+		if err := json.Unmarshal(defaults, &template.Defaults); err != nil {
+			return nil, err
+		}
+	}
+
+	return template, nil
+}
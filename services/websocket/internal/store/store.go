@@ -0,0 +1,183 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"pkg/ai"
+	"time"
+)
+
+// ErrNotFound is returned when the requested record does not exist or belongs to another user
+var ErrNotFound = errors.New("not found")
+
+// Persona is a reusable system prompt along with the model and parameters it works best with
+type Persona struct {
+	ID           string                 `json:"id"`
+	Owner        string                 `json:"-"` // id of the user who created the persona
+	Name         string                 `json:"name"`
+	SystemPrompt string                 `json:"system_prompt"`
+	Model        string                 `json:"model,omitempty"`   // model used when the chat does not name one
+	Options      map[string]interface{} `json:"options,omitempty"` // default generation parameters such as temperature
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// visibilities of the templates
+const (
+	Private = "private" // only the owner can see the template
+	Shared  = "org"     // every member of the organization of the owner can use the template
+)
+
+// Template is a reusable prompt with named variables written as {{name}}, filled in by the chats
+// using it
+type Template struct {
+	ID          string            `json:"id"`
+	Owner       string            `json:"owner"` // id of the user who created the template, the only one who can change it
+	Org         string            `json:"-"`     // organization of the owner
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Content     string            `json:"content"`
+	Variables   []string          `json:"variables"`          // names of the variables in the order they appear in the content
+	Defaults    map[string]string `json:"defaults,omitempty"` // values of the variables the chats can leave out
+	Visibility  string            `json:"visibility"`         // private or org
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// Conversation is the history of the chats a user continues under the same id
+type Conversation struct {
+	ID           string    `json:"id"`
+	Owner        string    `json:"-"`                       // id of the user having the conversation
+	Title        string    `json:"title,omitempty"`         // title generated after the first exchange
+	Persona      string    `json:"persona,omitempty"`       // id of the persona the conversation is held with
+	SystemPrompt string    `json:"system_prompt,omitempty"` // ad-hoc system prompt, preferred over the persona's
+	Retrieval    bool      `json:"retrieval,omitempty"`     // whether the documents of the user are searched to answer
+	Summary      string    `json:"summary,omitempty"`       // summary of the earlier messages, sent in their place
+	Summarized   int       `json:"summarized,omitempty"`    // number of the first messages covered by the summary
+	Messages     []Message `json:"messages"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Message is a message of a conversation, the files attached to it are kept as uploads
+type Message struct {
+	ai.Message
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment describes a file attached to a message
+type Attachment struct {
+	Upload      string `json:"upload"` // id of the upload holding the file
+	Name        string `json:"name,omitempty"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// Upload is a file uploaded by a user, such as an image attached to chats
+type Upload struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"-"` // id of the user who uploaded the file
+	Name        string    `json:"name,omitempty"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Data        []byte    `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Document is a text document of a user, split into chunks which are searched to answer chats
+type Document struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"-"` // id of the user who uploaded the document
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Chunks      int       `json:"chunks"` // number of chunks the document is split into
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Chunk is a passage of a document along with its embedding
+type Chunk struct {
+	Index     int // position of the chunk in the document
+	Content   string
+	Embedding []float32
+}
+
+// Match is a chunk found by a search
+type Match struct {
+	Document string  `json:"document"` // id of the document
+	Name     string  `json:"name"`     // name of the document
+	Index    int     `json:"chunk"`
+	Content  string  `json:"-"`
+	Score    float64 `json:"score"` // cosine similarity with the searched embedding
+}
+
+// Usage is how much a user generated with a model on a day
+type Usage struct {
+	Owner            string        `json:"user_id"`
+	Org              string        `json:"org,omitempty"`
+	Model            string        `json:"model"`
+	Day              string        `json:"day"` // such as 2006-01-02, in utc
+	Requests         int64         `json:"requests"`
+	PromptTokens     int64         `json:"prompt_tokens"`
+	CompletionTokens int64         `json:"completion_tokens"`
+	Duration         time.Duration `json:"duration"` // time spent generating in nanoseconds, as reported by the models
+}
+
+// UsageFilter selects the usage of a user or an organization over a range of days, the empty
+// fields select everything
+type UsageFilter struct {
+	Owner string
+	Org   string
+	From  string // first day, included
+	To    string // last day, included
+}
+
+// Store keeps the personas, templates, conversations, uploads, documents and usage of the users
+type Store interface {
+	// Personas returns the personas of the user ordered by name
+	Personas(ctx context.Context, owner string) ([]*Persona, error)
+	Persona(ctx context.Context, owner, id string) (*Persona, error)
+	// SavePersona creates the persona or replaces the one with the same id
+	SavePersona(ctx context.Context, persona *Persona) error
+	DeletePersona(ctx context.Context, owner, id string) error
+
+	// Templates returns the templates of the user along with the ones shared within the organization,
+	// ordered by name
+	Templates(ctx context.Context, owner, org string) ([]*Template, error)
+	// Template returns the template when it belongs to the user or is shared within the organization
+	Template(ctx context.Context, owner, org, id string) (*Template, error)
+	// SaveTemplate creates the template or replaces the one with the same id
+	SaveTemplate(ctx context.Context, template *Template) error
+	DeleteTemplate(ctx context.Context, owner, id string) error
+
+	// Conversation returns the conversation of the user along with its messages
+	Conversation(ctx context.Context, owner, id string) (*Conversation, error)
+	// SaveConversation creates the conversation or updates its settings, the messages are left as they are
+	SaveConversation(ctx context.Context, conversation *Conversation) error
+	// AppendMessages adds the messages to the end of the conversation
+	AppendMessages(ctx context.Context, owner, id string, messages ...Message) error
+	// SaveTitle names the conversation
+	SaveTitle(ctx context.Context, owner, id, title string) error
+	// SaveSummary replaces the summary of the conversation, which covers its first summarized messages
+	SaveSummary(ctx context.Context, owner, id, summary string, summarized int) error
+
+	// Upload returns the uploaded file of the user along with its content
+	Upload(ctx context.Context, owner, id string) (*Upload, error)
+	SaveUpload(ctx context.Context, upload *Upload) error
+	DeleteUpload(ctx context.Context, owner, id string) error
+
+	// Documents returns the documents of the user ordered by name
+	Documents(ctx context.Context, owner string) ([]*Document, error)
+	Document(ctx context.Context, owner, id string) (*Document, error)
+	// SaveDocument keeps the document along with its chunks
+	SaveDocument(ctx context.Context, document *Document, chunks []Chunk) error
+	// DeleteDocument removes the document along with its chunks
+	DeleteDocument(ctx context.Context, owner, id string) error
+	// Search returns the chunks of the documents of the user most similar to the embedding, the most similar first
+	Search(ctx context.Context, owner string, embedding []float32, limit int) ([]Match, error)
+
+	// RecordUsage adds the usage to the totals of the user, model and day
+	RecordUsage(ctx context.Context, usage *Usage) error
+	// Usage returns the daily totals matching the filter ordered by day, user and model
+	Usage(ctx context.Context, filter UsageFilter) ([]*Usage, error)
+}
@@ -0,0 +1,40 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"pkg/auth"
+	"time"
+)
+
+// Clock returns the current_time tool telling the model the current date and time, in the
+// time zone it asks for
+func Clock() Tool {
+	return Tool{
+		Name:        "current_time",
+		Description: "Get the current date and time",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"timezone": {"type": "string", "description": "IANA time zone such as Europe/Paris, UTC when omitted"}
+			}
+		}`),
+		Call: func(ctx context.Context, claims *auth.Claims, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Timezone string `json:"timezone"`
+			}
+
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", ErrArguments
+			}
+
+			location, err := time.LoadLocation(args.Timezone)
+
+			if err != nil {
+				return "", err
+			}
+
+			return time.Now().In(location).Format(time.RFC3339), nil
+		},
+	}
+}
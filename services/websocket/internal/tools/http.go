@@ -0,0 +1,106 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"pkg/auth"
+	"time"
+)
+
+// maxResponseSize is how much of the response of an http tool is fed back to the model
+const maxResponseSize = 64 * 1024
+
+// Definition describes an http tool, the arguments of the calls are posted to its url
+type Definition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+	URL         string          `json:"url"`
+	Roles       []string        `json:"roles"`
+	Timeout     string          `json:"timeout"` // such as "5s", DefaultTimeout when empty
+}
+
+// Parse returns the http tools of the json list of definitions
+func Parse(data string) ([]Tool, error) {
+	if data == "" {
+		return nil, nil
+	}
+
+	var definitions []Definition
+
+	if err := json.Unmarshal([]byte(data), &definitions); err != nil {
+		return nil, fmt.Errorf("invalid tools: %w", err)
+	}
+
+	tools := make([]Tool, 0, len(definitions))
+
+	for _, definition := range definitions {
+		if definition.URL == "" {
+			return nil, fmt.Errorf("tool %q has no url", definition.Name)
+		}
+
+		var timeout time.Duration
+
+		if definition.Timeout != "" {
+			var err error
+
+			if timeout, err = time.ParseDuration(definition.Timeout); err != nil {
+				return nil, fmt.Errorf("invalid timeout of tool %q: %w", definition.Name, err)
+			}
+		}
+
+		tools = append(tools, Tool{
+			Name:        definition.Name,
+			Description: definition.Description,
+			Parameters:  definition.Parameters,
+			Roles:       definition.Roles,
+			Timeout:     timeout,
+			Call:        HTTP(http.DefaultClient, definition.URL),
+		})
+	}
+
+	return tools, nil
+}
+
+// HTTP returns a tool posting the arguments to the url along with the identity of the user, the
+// body of the response is the result of the call
+func HTTP(client *http.Client, url string) Func {
+	return func(ctx context.Context, claims *auth.Claims, arguments json.RawMessage) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(arguments))
+
+		if err != nil {
+			return "", err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+
+		if claims != nil {
+			req.Header.Set("X-User-ID", claims.UserID)
+			req.Header.Set("X-User-Role", claims.Role)
+		}
+
+		res, err := client.Do(req)
+
+		if err != nil {
+			return "", err
+		}
+
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+
+		if err != nil {
+			return "", err
+		}
+
+		if res.StatusCode >= http.StatusBadRequest {
+			return "", fmt.Errorf("tool responded with status %d", res.StatusCode)
+		}
+
+		return string(body), nil
+	}
+}
@@ -0,0 +1,224 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"pkg/ai"
+	"pkg/auth"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"websocket/internal/schema"
+)
+
+var (
+	ErrUnknown   = errors.New("unknown tool")
+	ErrForbidden = errors.New("tool not allowed")
+	ErrTimeout   = errors.New("tool timed out")
+	ErrArguments = errors.New("arguments must be a json object")
+	ErrInvalid   = errors.New("invalid arguments")
+)
+
+// DefaultTimeout is how long a tool can run unless it sets its own timeout
+const DefaultTimeout = 10 * time.Second
+
+// names of the tools as accepted by the models
+var name = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Func runs the tool with the arguments provided by the model on behalf of the user and returns
+// the result fed back to the model
+type Func func(ctx context.Context, claims *auth.Claims, arguments json.RawMessage) (string, error)
+
+// Tool is an internal tool the assistant can call during a chat
+type Tool struct {
+	Name        string
+	Description string          // tells the model when to use the tool
+	Parameters  json.RawMessage // json schema of the arguments, an object without properties when empty
+	Roles       []string        // roles allowed to call the tool, every role when empty
+	Timeout     time.Duration   // how long the tool can run, DefaultTimeout when zero
+	Call        Func
+
+	schema *schema.Schema // compiled parameters validating the arguments
+}
+
+// allows reports whether the role can call the tool
+func (t *Tool) allows(role string) bool {
+	return len(t.Roles) == 0 || slices.Contains(t.Roles, role)
+}
+
+// Registry holds the tools the assistant can call, it is safe for concurrent use
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]*Tool
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		tools: make(map[string]*Tool),
+	}
+}
+
+// Register adds the tool to the registry
+func (r *Registry) Register(tool Tool) error {
+	if !name.MatchString(tool.Name) {
+		return fmt.Errorf("invalid tool name %q", tool.Name)
+	}
+
+	if tool.Call == nil {
+		return fmt.Errorf("tool %q has nothing to call", tool.Name)
+	}
+
+	if len(tool.Parameters) == 0 {
+		tool.Parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+
+	kind := struct {
+		Type string `json:"type"`
+	}{}
+
+	if err := json.Unmarshal(tool.Parameters, &kind); err != nil || kind.Type != "object" {
+		return fmt.Errorf("the parameters of tool %q must be the json schema of an object", tool.Name)
+	}
+
+	parameters, err := schema.Compile(tool.Parameters)
+
+	if err != nil {
+		return fmt.Errorf("the parameters of tool %q: %w", tool.Name, err)
+	}
+
+	tool.schema = parameters
+
+	if tool.Timeout <= 0 {
+		tool.Timeout = DefaultTimeout
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tools[tool.Name]; ok {
+		return fmt.Errorf("tool %q is already registered", tool.Name)
+	}
+
+	r.tools[tool.Name] = &tool
+
+	return nil
+}
+
+// Names returns the names of the tools the role can call
+func (r *Registry) Names(role string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := []string{}
+
+	for name, tool := range r.tools {
+		if tool.allows(role) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Definitions returns the definitions of the named tools offered to the model, after checking
+// the role can call every one of them
+func (r *Registry) Definitions(role string, names []string) ([]ai.Tool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	definitions := make([]ai.Tool, 0, len(names))
+
+	for _, name := range names {
+		tool, ok := r.tools[name]
+
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%w %q", ErrUnknown, name)
+		case !tool.allows(role):
+			return nil, fmt.Errorf("%w %q", ErrForbidden, name)
+		}
+
+		definitions = append(definitions, ai.Tool{
+			Type: "function",
+			Function: ai.ToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	return definitions, nil
+}
+
+// Call runs the tool the model asked for on behalf of the user, within the timeout of the tool.
+// Only the tools offered to the model in the chat can be called.
+func (r *Registry) Call(ctx context.Context, claims *auth.Claims, offered []string, call ai.ToolCall) (string, error) {
+	r.mu.RLock()
+	tool, ok := r.tools[call.Function.Name]
+	r.mu.RUnlock()
+
+	role := ""
+
+	if claims != nil {
+		role = claims.Role
+	}
+
+	switch {
+	case !ok:
+		return "", fmt.Errorf("%w %q", ErrUnknown, call.Function.Name)
+	case !slices.Contains(offered, call.Function.Name) || !tool.allows(role):
+		// the model may call a tool it has not been offered
+		return "", fmt.Errorf("%w %q", ErrForbidden, call.Function.Name)
+	}
+
+	arguments := call.Function.Arguments
+
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+
+	var object map[string]interface{}
+
+	if err := json.Unmarshal(arguments, &object); err != nil || object == nil {
+		return "", ErrArguments
+	}
+
+	if err := tool.schema.Validate(arguments); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tool.Timeout)
+	defer cancel()
+
+	type outcome struct {
+		result string
+		err    error
+	}
+
+	done := make(chan outcome, 1)
+
+	// the tool is abandoned when it overruns, even when it does not watch the context
+	go func() {
+		result, err := tool.Call(ctx, claims, arguments)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case outcome := <-done:
+		return outcome.result, outcome.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", ErrTimeout
+		}
+
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,142 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pkg/ai"
+	"pkg/auth"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func echo(ctx context.Context, claims *auth.Claims, arguments json.RawMessage) (string, error) {
+	return string(arguments), nil
+}
+
+func call(name, arguments string) ai.ToolCall {
+	return ai.ToolCall{ID: "1", Function: ai.ToolCallFunction{Name: name, Arguments: json.RawMessage(arguments)}}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	assert.NoError(t, registry.Register(Tool{Name: "echo", Call: echo}))
+	assert.NoError(t, registry.Register(Tool{Name: "purge", Roles: []string{"admin"}, Call: echo}))
+	assert.NoError(t, registry.Register(Tool{
+		Name:    "sleep",
+		Timeout: 10 * time.Millisecond,
+		Call: func(ctx context.Context, claims *auth.Claims, arguments json.RawMessage) (string, error) {
+			time.Sleep(time.Second)
+			return "rested", nil
+		},
+	}))
+
+	assert.Error(t, registry.Register(Tool{Name: "echo", Call: echo}))
+	assert.Error(t, registry.Register(Tool{Name: "no spaces", Call: echo}))
+	assert.Error(t, registry.Register(Tool{Name: "nothing"}))
+	assert.Error(t, registry.Register(Tool{Name: "list", Parameters: json.RawMessage(`{"type":"array"}`), Call: echo}))
+
+	assert.Equal(t, []string{"echo", "sleep"}, registry.Names("user"))
+	assert.Equal(t, []string{"echo", "purge", "sleep"}, registry.Names("admin"))
+
+	definitions, err := registry.Definitions("user", []string{"echo"})
+	assert.NoError(t, err)
+	assert.Equal(t, "function", definitions[0].Type)
+	assert.JSONEq(t, `{"type":"object","properties":{}}`, string(definitions[0].Function.Parameters))
+
+	_, err = registry.Definitions("user", []string{"purge"})
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	_, err = registry.Definitions("user", []string{"unknown"})
+	assert.ErrorIs(t, err, ErrUnknown)
+
+	user := &auth.Claims{UserID: "1", Role: "user"}
+	offered := []string{"echo", "purge", "sleep"}
+
+	result, err := registry.Call(context.Background(), user, offered, call("echo", `{"text":"hi"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"text":"hi"}`, result)
+
+	_, err = registry.Call(context.Background(), user, offered, call("purge", `{}`))
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	_, err = registry.Call(context.Background(), user, offered, call("echo", `"hi"`))
+	assert.ErrorIs(t, err, ErrArguments)
+
+	_, err = registry.Call(context.Background(), user, offered, call("sleep", `{}`))
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	// the tools which have not been offered in the chat cannot be called
+	_, err = registry.Call(context.Background(), user, []string{"sleep"}, call("echo", `{"text":"hi"}`))
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	_, err = registry.Call(context.Background(), user, nil, call("echo", `{"text":"hi"}`))
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-User-ID") != "1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("weather at " + string(body)))
+	}))
+	t.Cleanup(server.Close)
+
+	tools, err := Parse(`[{"name":"weather","url":"` + server.URL + `","timeout":"1s","roles":["user"]}]`)
+	assert.NoError(t, err)
+	assert.Len(t, tools, 1)
+	assert.Equal(t, time.Second, tools[0].Timeout)
+
+	result, err := tools[0].Call(context.Background(), &auth.Claims{UserID: "1"}, json.RawMessage(`{"city":"Paris"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `weather at {"city":"Paris"}`, result)
+
+	_, err = tools[0].Call(context.Background(), &auth.Claims{UserID: "2"}, json.RawMessage(`{}`))
+	assert.Error(t, err)
+
+	_, err = Parse(`[{"name":"weather"}]`)
+	assert.Error(t, err)
+
+	_, err = Parse(`[{"name":"weather","url":"http://localhost","timeout":"soon"}]`)
+	assert.Error(t, err)
+}
+
+func TestClock(t *testing.T) {
+	clock := Clock()
+
+	result, err := clock.Call(context.Background(), nil, json.RawMessage(`{"timezone":"UTC"}`))
+	assert.NoError(t, err)
+
+	_, err = time.Parse(time.RFC3339, result)
+	assert.NoError(t, err)
+
+	_, err = clock.Call(context.Background(), nil, json.RawMessage(`{"timezone":"Nowhere/Land"}`))
+	assert.Error(t, err)
+}
+
+func TestArguments(t *testing.T) {
+	registry := NewRegistry()
+
+	assert.Error(t, registry.Register(Tool{Name: "broken", Parameters: json.RawMessage(`{"type":"object","properties":{"city":{"type":"town"}}}`), Call: echo}))
+	assert.NoError(t, registry.Register(Tool{
+		Name:       "weather",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}`),
+		Call:       echo,
+	}))
+
+	_, err := registry.Call(context.Background(), nil, []string{"weather"}, call("weather", `{"city":42}`))
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.EqualError(t, err, "invalid arguments: $.city: expected string, got integer")
+
+	_, err = registry.Call(context.Background(), nil, []string{"weather"}, call("weather", `{"city":"Paris"}`))
+	assert.NoError(t, err)
+}